@@ -0,0 +1,14 @@
+package main
+
+import "shared/config"
+
+// Config est lue au démarrage depuis l'environnement, voir le paquet shared/config
+type Config struct {
+	config.Server
+	JWTSecret string `env:"JWT_SECRET" required:"true" secret:"true"`
+	// user-service vérifie les mots de passe à la connexion
+	UserServiceURL string `env:"USER_SERVICE_URL" required:"true"`
+	config.Internal
+}
+
+var cfg = Config{Server: config.Server{Port: 8080}}
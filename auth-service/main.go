@@ -1,22 +1,27 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
+	"shared/service"
+	"shared/tracing"
+	"shared/web"
 )
 
-var jwtKey = []byte("your_secret_key")
-
 func generateJWT(userID string) (string, error) {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 		"user_id": userID,
 		"exp":     time.Now().Add(time.Hour * 24).Unix(),
 	})
-	tokenString, err := token.SignedString(jwtKey)
+	tokenString, err := token.SignedString([]byte(cfg.JWTSecret))
 	if err != nil {
 		return "", err
 	}
@@ -26,7 +31,7 @@ func generateJWT(userID string) (string, error) {
 func verifyJWT(tokenString string) (string, error) {
 	claims := &jwt.MapClaims{}
 	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return jwtKey, nil
+		return []byte(cfg.JWTSecret), nil
 	})
 	if err != nil || !token.Valid {
 		return "", err
@@ -36,16 +41,16 @@ func verifyJWT(tokenString string) (string, error) {
 
 func verifyTokenHandler(w http.ResponseWriter, r *http.Request) {
 	var request struct {
-		Token string `json:"token"`
+		Token string `json:"token" validate:"required"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if err := web.DecodeJSON(w, r, &request); err != nil {
+		web.WriteError(w, err, "Token")
 		return
 	}
 
 	userID, err := verifyJWT(request.Token)
 	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		web.Error(w, http.StatusUnauthorized, "invalid_token", "Invalid or expired token")
 		return
 	}
 
@@ -54,35 +59,80 @@ func verifyTokenHandler(w http.ResponseWriter, r *http.Request) {
 
 func loginHandler(w http.ResponseWriter, r *http.Request) {
 	var creds struct {
-		UserID   string `json:"user_id"`
-		Password string `json:"password"`
+		// Identifiant ou email de l'utilisateur
+		UserID   string `json:"user_id" validate:"required,max=254"`
+		Password string `json:"password" validate:"required,max=128"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if err := web.DecodeJSON(w, r, &creds); err != nil {
+		web.WriteError(w, err, "Credentials")
 		return
 	}
 
-	// Vérifiez les informations d'identification ici
+	userID, err := checkCredentials(r.Context(), creds.UserID, creds.Password)
+	if errors.Is(err, errInvalidCredentials) {
+		web.Error(w, http.StatusUnauthorized, "invalid_credentials", "Invalid user or password")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to check credentials", "error", err)
+		web.Error(w, http.StatusBadGateway, "user_service_error", "Could not check credentials")
+		return
+	}
 
-	token, err := generateJWT(creds.UserID)
+	token, err := generateJWT(userID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		web.WriteError(w, err, "Token")
 		return
 	}
 
 	json.NewEncoder(w).Encode(map[string]string{"token": token})
 }
 
-func healthHandler(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("OK"))
+var errInvalidCredentials = errors.New("invalid credentials")
+
+// checkCredentials fait vérifier le mot de passe par user-service, qui en garde le hash, et
+// renvoie l'identifiant de l'utilisateur
+func checkCredentials(ctx context.Context, login, password string) (string, error) {
+	body, err := json.Marshal(map[string]string{"user_id": login, "password": password})
+	if err != nil {
+		return "", err
+	}
+	client := tracing.Client(5 * time.Second)
+	req, err := http.NewRequestWithContext(ctx, "POST", cfg.UserServiceURL+"/internal/credentials", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Internal-Token", cfg.InternalAPIToken)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		var user struct {
+			UserID string `json:"user_id"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
+			return "", err
+		}
+		if user.UserID == "" {
+			return "", errors.New("user-service returned no user id")
+		}
+		return user.UserID, nil
+	}
+	// Un 401 sans ce code vient d'un jeton interne refusé, pas d'un mot de passe faux
+	var problem web.Problem
+	if resp.StatusCode == http.StatusUnauthorized && json.NewDecoder(resp.Body).Decode(&problem) == nil && problem.Code == "invalid_credentials" {
+		return "", errInvalidCredentials
+	}
+	return "", fmt.Errorf("user-service returned status %d", resp.StatusCode)
 }
 
 func main() {
-	http.HandleFunc("/verify-token", verifyTokenHandler)
-	http.HandleFunc("/login", loginHandler)
-	http.HandleFunc("/health", healthHandler)
-
-	log.Println("Starting Auth Service on :8080")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	svc := service.New("Auth Service", &cfg)
+	svc.Router.Handle("/verify-token", web.Methods{"POST": verifyTokenHandler})
+	svc.Router.Handle("/login", web.Methods{"POST": loginHandler})
+	svc.Run()
 }
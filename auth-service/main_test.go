@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"shared/web"
+)
+
+func TestCheckCredentials(t *testing.T) {
+	cfg.InternalAPIToken = "token"
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		want    string
+		wantErr error
+	}{
+		{
+			name: "valid credentials",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				web.WriteJSON(w, http.StatusOK, map[string]string{"user_id": "42"})
+			},
+			want: "42",
+		},
+		{
+			name: "wrong password",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				web.Error(w, http.StatusUnauthorized, "invalid_credentials", "Invalid user or password")
+			},
+			wantErr: errInvalidCredentials,
+		},
+		{
+			name: "internal token refused",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				web.Error(w, http.StatusUnauthorized, "unauthorized", "Unauthorized")
+			},
+			wantErr: errors.New("user-service returned status 401"),
+		},
+		{
+			name: "user-service failure",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				web.Error(w, http.StatusInternalServerError, "internal_error", "An unexpected error occurred")
+			},
+			wantErr: errors.New("user-service returned status 500"),
+		},
+		{
+			name: "no user id",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				web.WriteJSON(w, http.StatusOK, map[string]string{})
+			},
+			wantErr: errors.New("user-service returned no user id"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var body map[string]string
+				json.NewDecoder(r.Body).Decode(&body)
+				if r.URL.Path != "/internal/credentials" || r.Header.Get("X-Internal-Token") != "token" ||
+					body["user_id"] != "user1@example.com" || body["password"] != "password" {
+					t.Errorf("unexpected request %s %v", r.URL.Path, body)
+				}
+				tt.handler(w, r)
+			}))
+			defer server.Close()
+			cfg.UserServiceURL = server.URL
+
+			got, err := checkCredentials(context.Background(), "user1@example.com", "password")
+			if tt.wantErr != nil {
+				if err == nil || (!errors.Is(err, tt.wantErr) && err.Error() != tt.wantErr.Error()) {
+					t.Fatalf("checkCredentials() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("checkCredentials() = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"shared/logging"
+	"shared/tracing"
+)
+
+const (
+	ChannelEmail   = "email"
+	ChannelSMS     = "sms"
+	ChannelWebhook = "webhook"
+	ChannelInApp   = "in_app"
+)
+
+const (
+	NotificationStatusPending = "pending"
+	NotificationStatusSent    = "sent"
+	NotificationStatusFailed  = "failed"
+)
+
+var ErrNoRecipient = errors.New("notification has no recipient")
+
+// smtpTimeout borne la connexion et l'échange avec le serveur SMTP
+const smtpTimeout = 30 * time.Second
+
+// Channel est un moyen de délivrer une notification à son destinataire
+type Channel interface {
+	Name() string
+	Send(ctx context.Context, notification Notification) error
+}
+
+var channels = map[string]Channel{}
+
+func initChannels() {
+	smsProvider, err := newSMSProvider()
+	if err != nil {
+		logging.Fatal("failed to configure SMS provider", "error", err)
+	}
+	for _, channel := range []Channel{
+		&emailChannel{
+			addr:     fmt.Sprintf("%s:%d", cfg.SMTPHost, cfg.SMTPPort),
+			host:     cfg.SMTPHost,
+			username: cfg.SMTPUsername,
+			password: cfg.SMTPPassword,
+			from:     cfg.SMTPFrom,
+		},
+		&smsChannel{provider: smsProvider},
+		&inAppChannel{},
+		&webhookChannel{
+			url:    cfg.WebhookURL,
+			secret: cfg.WebhookSecret,
+			client: tracing.Client(10 * time.Second),
+		},
+	} {
+		channels[channel.Name()] = channel
+	}
+}
+
+// emailChannel envoie les notifications par SMTP
+type emailChannel struct {
+	addr     string
+	host     string
+	username string
+	password string
+	from     string
+}
+
+func (c *emailChannel) Name() string {
+	return ChannelEmail
+}
+
+func (c *emailChannel) Send(ctx context.Context, notification Notification) error {
+	if notification.Recipient == "" {
+		return ErrNoRecipient
+	}
+	subject := notification.Subject
+	if subject == "" {
+		subject = "Notification"
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", c.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", notification.Recipient)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	if notification.HTMLBody == "" {
+		msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+		msg.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+		msg.WriteString(strings.ReplaceAll(notification.Message, "\n", "\r\n"))
+	} else {
+		// Version texte et version HTML, le client de messagerie choisit
+		parts := multipart.NewWriter(&msg)
+		fmt.Fprintf(&msg, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", parts.Boundary())
+		for _, part := range []struct{ contentType, body string }{
+			{"text/plain; charset=UTF-8", notification.Message},
+			{"text/html; charset=UTF-8", notification.HTMLBody},
+		} {
+			w, err := parts.CreatePart(textproto.MIMEHeader{
+				"Content-Type":              {part.contentType},
+				"Content-Transfer-Encoding": {"8bit"},
+			})
+			if err != nil {
+				return err
+			}
+			io.WriteString(w, strings.ReplaceAll(part.body, "\n", "\r\n"))
+		}
+		parts.Close()
+	}
+
+	return c.sendMail(ctx, notification.Recipient, msg.Bytes())
+}
+
+// sendMail fait le travail de smtp.SendMail, qui n'a pas de délai : un serveur muet
+// bloquerait le worker indéfiniment
+func (c *emailChannel) sendMail(ctx context.Context, to string, msg []byte) error {
+	dialer := net.Dialer{Timeout: smtpTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	deadline := time.Now().Add(smtpTimeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+
+	client, err := smtp.NewClient(conn, c.host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: c.host}); err != nil {
+			return err
+		}
+	}
+	if c.username != "" {
+		if err := client.Auth(smtp.PlainAuth("", c.username, c.password, c.host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(c.from); err != nil {
+		return err
+	}
+	if err := client.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// inAppChannel : la notification enregistrée est elle-même l'entrée affichée dans l'application
+type inAppChannel struct{}
+
+func (c *inAppChannel) Name() string {
+	return ChannelInApp
+}
+
+func (c *inAppChannel) Send(ctx context.Context, notification Notification) error {
+	if notification.UserID == "" {
+		return ErrNoRecipient
+	}
+	notification.Status = NotificationStatusSent
+	inbox.publish(notification)
+	return nil
+}
+
+// webhookChannel publie la notification en JSON, signée en HMAC-SHA256, vers l'URL
+// configurée par NOTIFICATION_WEBHOOK_URL ; le destinataire de la notification n'est pas
+// une URL, pour que le service ne puisse pas être dirigé vers le réseau interne
+type webhookChannel struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+func (c *webhookChannel) Name() string {
+	return ChannelWebhook
+}
+
+func (c *webhookChannel) Send(ctx context.Context, notification Notification) error {
+	if c.url == "" {
+		return ErrNoRecipient
+	}
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", c.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.secret != "" {
+		mac := hmac.New(sha256.New, []byte(c.secret))
+		mac.Write(body)
+		req.Header.Set("X-Notification-Signature", hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"shared/config"
+)
+
+// Config est lue au démarrage depuis l'environnement, voir le paquet shared/config
+type Config struct {
+	config.Server
+	config.Database
+	config.Auth
+	UserServiceURL string `env:"USER_SERVICE_URL" required:"true"`
+	config.Internal
+	// Utilisateurs autorisés sur /notifications et /templates
+	config.Admin
+	// Vide ou "memory://" : bus en mémoire, limité au processus
+	EventBusURL string `env:"EVENT_BUS_URL"`
+
+	NotificationWorkers     int    `env:"NOTIFICATION_WORKERS" default:"4"`
+	NotificationMaxAttempts int    `env:"NOTIFICATION_MAX_ATTEMPTS" default:"5"`
+	DefaultLocale           string `env:"DEFAULT_LOCALE" default:"fr"`
+
+	SMTPHost     string `env:"SMTP_HOST" default:"localhost"`
+	SMTPPort     int    `env:"SMTP_PORT" default:"25"`
+	SMTPUsername string `env:"SMTP_USERNAME"`
+	SMTPPassword string `env:"SMTP_PASSWORD" secret:"true"`
+	SMTPFrom     string `env:"SMTP_FROM" default:"no-reply@example.com"`
+
+	SMSProvider  string `env:"SMS_PROVIDER" default:"fake"`
+	SMSAPIURL    string `env:"SMS_API_URL"`
+	SMSAccountID string `env:"SMS_ACCOUNT_ID"`
+	SMSAPIKey    string `env:"SMS_API_KEY" secret:"true"`
+	SMSFrom      string `env:"SMS_FROM"`
+
+	WebhookURL    string `env:"NOTIFICATION_WEBHOOK_URL"`
+	WebhookSecret string `env:"NOTIFICATION_WEBHOOK_SECRET" secret:"true"`
+}
+
+var cfg = Config{Server: config.Server{Port: 8085}}
+
+func (c Config) Validate() error {
+	if c.NotificationWorkers <= 0 || c.NotificationMaxAttempts <= 0 {
+		return errors.New("NOTIFICATION_WORKERS and NOTIFICATION_MAX_ATTEMPTS must be positive")
+	}
+	if !isSupportedLocale(c.DefaultLocale) {
+		return fmt.Errorf("unsupported DEFAULT_LOCALE %q", c.DefaultLocale)
+	}
+	switch c.SMSProvider {
+	case "fake":
+	case "http":
+		if c.SMSAPIURL == "" {
+			return errors.New("SMS_API_URL is required when SMS_PROVIDER=http")
+		}
+	default:
+		return fmt.Errorf("unknown SMS provider %q", c.SMSProvider)
+	}
+	return nil
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"text/template"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"shared/bus"
+	"shared/logging"
+	"shared/tracing"
+)
+
+var eventBus bus.Bus
+
+var (
+	// errUnprocessableEvent : l'événement ne pourra jamais être traité, le remettre est inutile
+	errUnprocessableEvent = errors.New("unprocessable event")
+	// errInvalidUser : user-service refuse l'identifiant, qui n'est pas celui d'un utilisateur
+	errInvalidUser = errors.New("invalid user id")
+)
+
+// Modèle utilisé pour chaque type d'événement auquel le service est abonné ;
+// les autres événements sont acquittés sans créer de notification
+var eventTemplates = map[string]string{
+	"order.placed":     "order_created",
+	"order.paid":       "payment_captured",
+	"order.shipped":    "order_shipped",
+	"order.refunded":   "payment_refunded",
+	"payment.declined": "payment_failed",
+	"payment.failed":   "payment_failed",
+}
+
+// DomainEvent est publié sur le bus par order-service et payment-service
+type DomainEvent struct {
+	ID            string          `json:"id"`
+	Type          string          `json:"type"`
+	AggregateType string          `json:"aggregate_type"`
+	AggregateID   string          `json:"aggregate_id"`
+	UserID        string          `json:"user_id"`
+	OccurredAt    time.Time       `json:"occurred_at"`
+	Data          json.RawMessage `json:"data"`
+}
+
+// ProcessedEvent garantit qu'un événement redélivré ne crée pas deux fois ses notifications
+type ProcessedEvent struct {
+	EventID     string    `gorm:"primaryKey"`
+	Type        string    `json:"type"`
+	ProcessedAt time.Time `json:"processed_at"`
+}
+
+func (ProcessedEvent) TableName() string {
+	return "notification_processed_events"
+}
+
+// Champs des événements commande et paiement utiles aux modèles
+type eventPayload struct {
+	OrderID     json.Number `json:"order_id"`
+	ProductName string      `json:"product_name"`
+	Quantity    int         `json:"quantity"`
+	Amount      float64     `json:"amount"`
+	Currency    string      `json:"currency"`
+}
+
+type userContact struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+	Phone string `json:"phone"`
+}
+
+// subscribeEvents abonne le service aux événements commande et paiement ; les instances
+// du service se partagent les messages au sein du groupe "notification-service"
+func subscribeEvents() {
+	for _, subject := range []string{"order.>", "payment.>"} {
+		if err := eventBus.Subscribe(context.Background(), subject, "notification-service", handleEventMessage); err != nil {
+			logging.Fatal("failed to subscribe to events", "subject", subject, "error", err)
+		}
+	}
+}
+
+func handleEventMessage(ctx context.Context, msg bus.Message) error {
+	var event DomainEvent
+	if err := json.Unmarshal(msg.Data, &event); err != nil || event.ID == "" || event.Type == "" {
+		slog.WarnContext(ctx, "dropping malformed event", "message_id", msg.ID, "subject", msg.Subject, "error", err)
+		return nil
+	}
+	err := handleEvent(ctx, event)
+	if errors.Is(err, errUnprocessableEvent) {
+		slog.WarnContext(ctx, "dropping unprocessable event", "event_type", event.Type, "event_id", event.ID, "error", err)
+		return nil
+	}
+	if err != nil {
+		// Le bus remettra le message avec le même identifiant d'événement
+		slog.ErrorContext(ctx, "failed to handle event", "event_type", event.Type, "event_id", event.ID, "error", err)
+		return err
+	}
+	return nil
+}
+
+// handleEvent crée les notifications d'un événement une seule fois par identifiant
+func handleEvent(ctx context.Context, event DomainEvent) error {
+	templateName, subscribed := eventTemplates[event.Type]
+	if !subscribed || event.UserID == "" {
+		return nil
+	}
+	notifications, err := notificationsForEvent(ctx, event, templateName)
+	if err != nil {
+		return err
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&ProcessedEvent{EventID: event.ID, Type: event.Type, ProcessedAt: time.Now()})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		return tx.Create(&notifications).Error
+	})
+	if err != nil {
+		return err
+	}
+	wakeDispatcher()
+	return nil
+}
+
+// notificationsForEvent prépare une notification in-app, un e-mail si l'utilisateur a une
+// adresse, et un SMS s'il a un numéro et a choisi ce canal pour l'événement
+func notificationsForEvent(ctx context.Context, event DomainEvent, templateName string) ([]Notification, error) {
+	var payload eventPayload
+	if err := json.Unmarshal(event.Data, &payload); err != nil {
+		return nil, fmt.Errorf("%w: invalid payload: %v", errUnprocessableEvent, err)
+	}
+	contact, err := fetchUserContact(ctx, event.UserID)
+	if errors.Is(err, errInvalidUser) {
+		return nil, fmt.Errorf("%w: %v", errUnprocessableEvent, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	preference, err := loadPreferences(event.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	data := map[string]interface{}{
+		"OrderID":      payload.OrderID.String(),
+		"ProductName":  payload.ProductName,
+		"Quantity":     payload.Quantity,
+		"Amount":       payload.Amount,
+		"Currency":     payload.Currency,
+		"CustomerName": contact.Name,
+	}
+	recipients := map[string]string{ChannelInApp: ""}
+	if contact.Email != "" {
+		recipients[ChannelEmail] = contact.Email
+	}
+	// Le SMS est payant : il n'est envoyé qu'aux utilisateurs qui l'ont demandé
+	if contact.Phone != "" && preference.requestsChannel(templateName, ChannelSMS) {
+		recipients[ChannelSMS] = contact.Phone
+	}
+
+	now := time.Now()
+	var notifications []Notification
+	for _, channel := range []string{ChannelInApp, ChannelEmail, ChannelSMS} {
+		recipient, ok := recipients[channel]
+		if !ok {
+			continue
+		}
+		notification := Notification{
+			UserID:        event.UserID,
+			Channel:       channel,
+			Recipient:     recipient,
+			Template:      templateName,
+			Locale:        preference.Locale,
+			Data:          data,
+			Category:      CategoryTransactional,
+			Status:        NotificationStatusPending,
+			NextAttemptAt: &now,
+		}
+		if err := applyTemplate(&notification); err != nil {
+			// Un modèle absent ou qui échoue sur ces données échouera à chaque remise
+			var execErr template.ExecError
+			if errors.Is(err, ErrTemplateNotFound) || errors.As(err, &execErr) {
+				return nil, fmt.Errorf("%w: %v", errUnprocessableEvent, err)
+			}
+			return nil, err
+		}
+		notifications = append(notifications, notification)
+	}
+	return notifications, nil
+}
+
+// fetchUserContact lit le nom, l'adresse e-mail et le numéro de l'utilisateur dans user-service ;
+// un identifiant refusé donne errInvalidUser, les autres erreurs peuvent être réessayées
+func fetchUserContact(ctx context.Context, userID string) (userContact, error) {
+	var contact userContact
+	if _, err := strconv.ParseUint(userID, 10, 64); err != nil {
+		return contact, fmt.Errorf("%w %q", errInvalidUser, userID)
+	}
+	client := tracing.Client(5 * time.Second)
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/internal/users/%s", cfg.UserServiceURL, url.PathEscape(userID)), nil)
+	if err != nil {
+		return contact, err
+	}
+	req.Header.Set("X-Internal-Token", cfg.InternalAPIToken)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return contact, err
+	}
+	defer resp.Body.Close()
+	// Un utilisateur supprimé garde ses notifications in-app
+	if resp.StatusCode == http.StatusNotFound {
+		return contact, nil
+	}
+	if resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusUnprocessableEntity {
+		return contact, fmt.Errorf("%w %q: user-service returned status %d", errInvalidUser, userID, resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return contact, fmt.Errorf("user-service returned status %d", resp.StatusCode)
+	}
+	err = json.NewDecoder(resp.Body).Decode(&contact)
+	return contact, err
+}
+
+// initEventBus déclare aussi les sujets écoutés, pour pouvoir s'abonner avant le premier
+// démarrage des producteurs
+func initEventBus() {
+	var err error
+	eventBus, err = bus.Open(cfg.EventBusURL, bus.Options{Subjects: []string{"order.>", "payment.>"}})
+	if err != nil {
+		logging.Fatal("failed to connect event bus", "error", err)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"shared/bus"
+	"shared/web"
+)
+
+func TestFetchUserContact(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/internal/users/1":
+			web.WriteJSON(w, http.StatusOK, userContact{Name: "Alice", Email: "alice@example.com"})
+		case "/internal/users/2":
+			web.Error(w, http.StatusNotFound, "not_found", "User not found")
+		case "/internal/users/3":
+			web.Error(w, http.StatusBadRequest, "invalid_id", "Invalid user id")
+		case "/internal/users/4":
+			web.Error(w, http.StatusServiceUnavailable, "unavailable", "Service unavailable")
+		case "/internal/users/5":
+			web.Error(w, http.StatusUnauthorized, "unauthorized", "Invalid internal token")
+		}
+	}))
+	defer server.Close()
+	cfg.UserServiceURL = server.URL
+
+	tests := []struct {
+		name        string
+		userID      string
+		want        userContact
+		wantErr     bool
+		wantInvalid bool
+	}{
+		{name: "known user", userID: "1", want: userContact{Name: "Alice", Email: "alice@example.com"}},
+		{name: "deleted user", userID: "2"},
+		{name: "rejected id", userID: "3", wantErr: true, wantInvalid: true},
+		{name: "not a number", userID: "alice", wantErr: true, wantInvalid: true},
+		{name: "user-service unavailable", userID: "4", wantErr: true},
+		{name: "misconfigured token is retried", userID: "5", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := fetchUserContact(context.Background(), tt.userID)
+			if (err != nil) != tt.wantErr || errors.Is(err, errInvalidUser) != tt.wantInvalid {
+				t.Fatalf("fetchUserContact() error = %v, want error %v, invalid user %v", err, tt.wantErr, tt.wantInvalid)
+			}
+			if got != tt.want {
+				t.Errorf("contact = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// Un événement qui ne pourra jamais être traité est acquitté au lieu d'être remis jusqu'à
+// MaxDeliver ; les erreurs passagères restent remises
+func TestHandleEventMessage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		web.Error(w, http.StatusServiceUnavailable, "unavailable", "Service unavailable")
+	}))
+	defer server.Close()
+	cfg.UserServiceURL = server.URL
+
+	message := func(userID, data string) bus.Message {
+		payload, _ := json.Marshal(DomainEvent{ID: "evt_1", Type: "order.placed", UserID: userID, Data: json.RawMessage(data)})
+		return bus.Message{ID: "msg_1", Subject: "order.placed", Data: payload}
+	}
+	tests := []struct {
+		name    string
+		msg     bus.Message
+		wantErr bool
+	}{
+		{name: "malformed message", msg: bus.Message{ID: "msg_1", Subject: "order.placed", Data: []byte("{")}},
+		{name: "unsubscribed event", msg: bus.Message{ID: "msg_1", Subject: "order.viewed", Data: []byte(`{"id":"evt_1","type":"order.viewed","user_id":"1"}`)}},
+		{name: "order id is not a number", msg: message("1", `{"order_id":"abc"}`)},
+		{name: "payload is not an object", msg: message("1", `[1,2]`)},
+		{name: "not a user id", msg: message("alice", `{"order_id":42}`)},
+		{name: "user-service unavailable", msg: message("1", `{"order_id":42}`), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := handleEventMessage(context.Background(), tt.msg); (err != nil) != tt.wantErr {
+				t.Errorf("handleEventMessage() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
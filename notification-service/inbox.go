@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"shared/web"
+)
+
+const inboxHeartbeatInterval = 25 * time.Second
+
+// inboxHub diffuse les notifications in-app aux flux SSE ouverts sur cette instance ;
+// un client qui se reconnecte ailleurs rattrape son retard grâce à Last-Event-ID
+type inboxHub struct {
+	mu          sync.Mutex
+	subscribers map[string]map[chan Notification]struct{}
+	// closed ferme les flux ouverts à l'arrêt du service
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+var inbox = &inboxHub{subscribers: map[string]map[chan Notification]struct{}{}, closed: make(chan struct{})}
+
+func (h *inboxHub) subscribe(userID string) chan Notification {
+	ch := make(chan Notification, 16)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.subscribers[userID] == nil {
+		h.subscribers[userID] = map[chan Notification]struct{}{}
+	}
+	h.subscribers[userID][ch] = struct{}{}
+	return ch
+}
+
+func (h *inboxHub) unsubscribe(userID string, ch chan Notification) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subscribers[userID], ch)
+	if len(h.subscribers[userID]) == 0 {
+		delete(h.subscribers, userID)
+	}
+}
+
+func (h *inboxHub) close() {
+	h.closeOnce.Do(func() { close(h.closed) })
+}
+
+// publish ne bloque jamais le dispatcher : un client trop lent perd l'événement
+// et le retrouvera au prochain GET de sa boîte de réception
+func (h *inboxHub) publish(notification Notification) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subscribers[notification.UserID] {
+		select {
+		case ch <- notification:
+		default:
+		}
+	}
+}
+
+func inboxQuery(userID string) *gorm.DB {
+	return db.Model(&Notification{}).Where("user_id = ? AND channel = ? AND status = ?", userID, ChannelInApp, NotificationStatusSent)
+}
+
+func unreadCount(userID string) (int64, error) {
+	var count int64
+	err := inboxQuery(userID).Where("read_at IS NULL").Count(&count).Error
+	return count, err
+}
+
+// inboxHandler sert /users/me/notifications et ses sous-ressources pour l'utilisateur authentifié
+func inboxHandler(w http.ResponseWriter, r *http.Request) {
+	userID := web.UserID(r.Context())
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/users/me/notifications"), "/")
+
+	switch {
+	case path == "" && r.Method == "GET":
+		getInbox(w, r, userID)
+	case path == "stream" && r.Method == "GET":
+		streamInbox(w, r, userID)
+	case path == "read-all" && r.Method == "POST":
+		result := inboxQuery(userID).Where("read_at IS NULL").Update("read_at", time.Now())
+		if result.Error != nil {
+			web.WriteError(w, result.Error, "Notification")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int64{"updated": result.RowsAffected, "unread_count": 0})
+	case strings.HasSuffix(path, "/read") && r.Method == "POST":
+		markRead(w, userID, strings.TrimSuffix(path, "/read"), true)
+	case strings.HasSuffix(path, "/unread") && r.Method == "POST":
+		markRead(w, userID, strings.TrimSuffix(path, "/unread"), false)
+	default:
+		web.Error(w, http.StatusNotFound, "not_found", "Not found")
+	}
+}
+
+func getInbox(w http.ResponseWriter, r *http.Request, userID string) {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 || limit > 100 {
+		limit = 50
+	}
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+
+	query := inboxQuery(userID)
+	if r.URL.Query().Get("unread") == "true" {
+		query = query.Where("read_at IS NULL")
+	}
+	var notifications []Notification
+	if err := query.Order("id DESC").Limit(limit).Offset(offset).Find(&notifications).Error; err != nil {
+		web.WriteError(w, err, "Notification")
+		return
+	}
+	unread, err := unreadCount(userID)
+	if err != nil {
+		web.WriteError(w, err, "Notification")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Notifications []Notification `json:"notifications"`
+		UnreadCount   int64          `json:"unread_count"`
+	}{notifications, unread})
+}
+
+func markRead(w http.ResponseWriter, userID, id string, read bool) {
+	var readAt interface{}
+	if read {
+		readAt = time.Now()
+	}
+	result := inboxQuery(userID).Where("id = ?", id).Update("read_at", readAt)
+	if result.Error != nil {
+		web.WriteError(w, result.Error, "Notification")
+		return
+	}
+	if result.RowsAffected == 0 {
+		web.Error(w, http.StatusNotFound, "not_found", "Notification not found")
+		return
+	}
+	unread, err := unreadCount(userID)
+	if err != nil {
+		web.WriteError(w, err, "Notification")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int64{"unread_count": unread})
+}
+
+// streamInbox pousse les nouvelles notifications en Server-Sent Events
+func streamInbox(w http.ResponseWriter, r *http.Request, userID string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		web.Error(w, http.StatusInternalServerError, "streaming_unsupported", "Streaming unsupported")
+		return
+	}
+	// Abonnement avant le rattrapage pour ne rien perdre entre les deux
+	ch := inbox.subscribe(userID)
+	defer inbox.unsubscribe(userID, ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	// Le flux dure plus longtemps que le WriteTimeout du serveur
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	replayed := map[uint]bool{}
+	if lastID, _ := strconv.ParseUint(r.Header.Get("Last-Event-ID"), 10, 64); lastID > 0 {
+		var missed []Notification
+		if err := inboxQuery(userID).Where("id > ?", lastID).Order("id").Find(&missed).Error; err == nil {
+			for _, notification := range missed {
+				writeInboxEvent(w, notification)
+				replayed[notification.ID] = true
+			}
+		}
+	}
+	unread, _ := unreadCount(userID)
+	fmt.Fprintf(w, "event: unread_count\ndata: %d\n\n", unread)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(inboxHeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-inbox.closed:
+			return
+		case notification := <-ch:
+			if replayed[notification.ID] {
+				continue
+			}
+			writeInboxEvent(w, notification)
+			flusher.Flush()
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+func writeInboxEvent(w http.ResponseWriter, notification Notification) {
+	data, err := json.Marshal(notification)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "id: %d\nevent: notification\ndata: %s\n\n", notification.ID, data)
+}
@@ -1,49 +1,60 @@
 package main
 
 import (
+	"context"
+	"embed"
 	"encoding/json"
-	"log"
+	"errors"
 	"net/http"
+	"strings"
+	"time"
 
-	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"shared/service"
+	"shared/web"
 )
 
 type Notification struct {
 	ID      uint   `gorm:"primaryKey;autoIncrement" json:"id"`
-	UserID  string `json:"user_id"`
-	Message string `json:"message"`
+	UserID  string `json:"user_id" validate:"max=64"`
+	Message string `json:"message" validate:"max=10000"`
 	Status  string `json:"status"`
+	// Canal de distribution (email, sms, webhook) et adresse correspondante, fixée par le
+	// service d'après l'utilisateur
+	Channel   string     `json:"channel" validate:"oneof=email sms webhook in_app"`
+	Recipient string     `json:"recipient" validate:"max=320"`
+	Subject   string     `json:"subject" validate:"max=255"`
+	HTMLBody  string     `json:"html_body,omitempty"`
+	Error     string     `json:"error,omitempty"`
+	SentAt    *time.Time `json:"sent_at,omitempty"`
+	// Suivi de la file de distribution
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt *time.Time `gorm:"index" json:"next_attempt_at,omitempty"`
+	LockedUntil   *time.Time `json:"-"`
+	// Modèle utilisé pour construire le sujet et le message, à la place d'un Message libre
+	Template        string                 `json:"template,omitempty" validate:"max=100"`
+	Locale          string                 `json:"locale,omitempty" validate:"max=35"`
+	TemplateVersion int                    `json:"template_version,omitempty"`
+	Data            map[string]interface{} `gorm:"-" json:"data,omitempty"`
+	// transactional ou marketing, les utilisateurs peuvent refuser le marketing
+	Category string `json:"category" validate:"oneof=transactional marketing"`
+	// Lecture dans la boîte de réception in-app
+	ReadAt *time.Time `json:"read_at,omitempty"`
 }
 
-var db *gorm.DB
-
-func initDB() {
-	dsn := "host=db user=user password=password dbname=microservices port=5432 sslmode=disable TimeZone=Asia/Shanghai"
-	var err error
-	db, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
-	if err != nil {
-		log.Fatalf("failed to connect database: %v", err)
-	}
-}
+// Migrations SQL du schéma, appliquées par la sous-commande migrate
+//
+//go:embed migrations/*.sql
+var migrations embed.FS
 
-func migrate() {
-	db.AutoMigrate(&Notification{})
-}
-
-func notificationsHandler(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case "GET":
-		getNotifications(w, r)
-	case "POST":
-		createNotification(w, r)
-	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-	}
-}
+var db *gorm.DB
 
 func notificationHandler(w http.ResponseWriter, r *http.Request) {
 	id := r.URL.Path[len("/notifications/"):]
+	if strings.HasSuffix(id, "/redrive") {
+		redriveHandler(w, r)
+		return
+	}
 	switch r.Method {
 	case "GET":
 		getNotification(w, r, id)
@@ -52,14 +63,18 @@ func notificationHandler(w http.ResponseWriter, r *http.Request) {
 	case "DELETE":
 		deleteNotification(w, r, id)
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		web.Error(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
 	}
 }
 
 func getNotifications(w http.ResponseWriter, r *http.Request) {
+	query := db.Order("id")
+	if status := r.URL.Query().Get("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
 	var notifications []Notification
-	if err := db.Find(&notifications).Error; err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := query.Find(&notifications).Error; err != nil {
+		web.WriteError(w, err, "Notification")
 		return
 	}
 	json.NewEncoder(w).Encode(notifications)
@@ -67,22 +82,99 @@ func getNotifications(w http.ResponseWriter, r *http.Request) {
 
 func createNotification(w http.ResponseWriter, r *http.Request) {
 	var notification Notification
-	if err := json.NewDecoder(r.Body).Decode(&notification); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if err := web.DecodeJSON(w, r, &notification); err != nil {
+		web.WriteError(w, err, "Notification")
+		return
+	}
+	// Sans modèle, le message est fourni tel quel
+	if notification.Template == "" && notification.Message == "" {
+		web.WriteError(w, web.ValidationError(web.FieldError{Field: "message", Code: "required", Message: "is required without a template"}), "Notification")
+		return
+	}
+	if notification.Channel == "" {
+		notification.Channel = ChannelEmail
+	}
+	if notification.Category == "" {
+		notification.Category = CategoryTransactional
+	}
+	if err := resolveRecipient(r.Context(), &notification); err != nil {
+		var statusErr *web.StatusError
+		if errors.As(err, &statusErr) {
+			web.WriteError(w, err, "Notification")
+			return
+		}
+		web.Error(w, http.StatusBadGateway, "user_service_error", "Could not fetch the user's contact")
 		return
 	}
+	if notification.Template != "" {
+		err := applyTemplate(&notification)
+		if errors.Is(err, ErrTemplateNotFound) {
+			web.Error(w, http.StatusBadRequest, "unknown_template", "Unknown template")
+			return
+		}
+		if err != nil {
+			web.Error(w, http.StatusUnprocessableEntity, "template_render_failed", err.Error())
+			return
+		}
+	}
+	// Le statut de distribution est tenu par le service, l'envoi se fait en arrière-plan
+	now := time.Now()
+	notification.Status = NotificationStatusPending
+	notification.Error = ""
+	notification.SentAt = nil
+	notification.Attempts = 0
+	notification.NextAttemptAt = &now
 	if err := db.Create(&notification).Error; err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		web.WriteError(w, err, "Notification")
 		return
 	}
+	wakeDispatcher()
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
-	sendNotification(notification)
+	json.NewEncoder(w).Encode(notification)
+}
+
+// resolveRecipient fixe l'adresse de la notification d'après son utilisateur. L'API
+// n'accepte pas d'adresse libre, qui ferait du service un relais ouvert : l'e-mail et le
+// SMS partent à l'adresse et au numéro connus de user-service, le webhook à
+// NOTIFICATION_WEBHOOK_URL.
+func resolveRecipient(ctx context.Context, notification *Notification) error {
+	if notification.Recipient != "" {
+		return web.ValidationError(web.FieldError{Field: "recipient", Code: "read_only", Message: "is taken from the user's contact"})
+	}
+	if notification.Channel == ChannelWebhook {
+		return nil
+	}
+	if notification.UserID == "" {
+		return web.ValidationError(web.FieldError{Field: "user_id", Code: "required", Message: "is required"})
+	}
+	if notification.Channel != ChannelEmail && notification.Channel != ChannelSMS {
+		return nil
+	}
+	contact, err := fetchUserContact(ctx, notification.UserID)
+	if errors.Is(err, errInvalidUser) {
+		return web.ValidationError(web.FieldError{Field: "user_id", Code: "invalid", Message: "is not a user id"})
+	}
+	if err != nil {
+		return err
+	}
+	switch {
+	case notification.Channel == ChannelEmail && contact.Email == "":
+		return web.NewError(http.StatusUnprocessableEntity, "no_recipient", "User has no email address")
+	case notification.Channel == ChannelEmail:
+		notification.Recipient = contact.Email
+	case contact.Phone == "":
+		return web.NewError(http.StatusUnprocessableEntity, "no_recipient", "User has no phone number")
+	default:
+		notification.Recipient = contact.Phone
+	}
+	return nil
 }
 
 func getNotification(w http.ResponseWriter, r *http.Request, id string) {
 	var notification Notification
 	if err := db.First(&notification, "id = ?", id).Error; err != nil {
-		http.Error(w, "Notification not found", http.StatusNotFound)
+		web.Error(w, http.StatusNotFound, "not_found", "Notification not found")
 		return
 	}
 	json.NewEncoder(w).Encode(notification)
@@ -90,12 +182,12 @@ func getNotification(w http.ResponseWriter, r *http.Request, id string) {
 
 func updateNotification(w http.ResponseWriter, r *http.Request, id string) {
 	var notification Notification
-	if err := json.NewDecoder(r.Body).Decode(&notification); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if err := web.DecodeJSONPartial(w, r, &notification); err != nil {
+		web.WriteError(w, err, "Notification")
 		return
 	}
-	if err := db.Model(&Notification{}).Where("id = ?", id).Updates(notification).Error; err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := db.Model(&Notification{}).Where("id = ?", id).Omit("user_id", "channel", "recipient", "status", "error", "sent_at", "read_at", "attempts", "next_attempt_at", "locked_until").Updates(notification).Error; err != nil {
+		web.WriteError(w, err, "Notification")
 		return
 	}
 	w.WriteHeader(http.StatusOK)
@@ -103,30 +195,35 @@ func updateNotification(w http.ResponseWriter, r *http.Request, id string) {
 
 func deleteNotification(w http.ResponseWriter, r *http.Request, id string) {
 	if err := db.Delete(&Notification{}, "id = ?", id).Error; err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		web.WriteError(w, err, "Notification")
 		return
 	}
 	w.WriteHeader(http.StatusOK)
 }
 
-func sendNotification(notification Notification) {
-	// Logic to send notification via email or SMS
-	log.Printf("Sending notification to user %s: %s\n", notification.UserID, notification.Message)
-}
-
-func healthHandler(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("OK"))
-}
-
 func main() {
-	initDB()
-	migrate()
-
-	http.HandleFunc("/notifications", notificationsHandler)
-	http.HandleFunc("/notifications/", notificationHandler)
-	http.HandleFunc("/health", healthHandler)
-
-	log.Println("Starting Notification Service on :8085")
-	log.Fatal(http.ListenAndServe(":8085", nil))
+	svc := service.New("Notification Service", &cfg)
+	db = svc.DB
+	svc.DependsOn("user-service", cfg.UserServiceURL)
+	initChannels()
+	svc.Migrate(migrations)
+	seedTemplates()
+	startDispatcher(svc)
+	initEventBus()
+	subscribeEvents()
+	svc.OnStop(func() { eventBus.Close() })
+	svc.OnShutdown(inbox.close)
+
+	notifications := web.Methods{"GET": getNotifications, "POST": createNotification}
+	templates := web.Methods{"GET": getTemplates, "POST": createTemplate}
+	// Les notifications de tous les utilisateurs ne sont visibles que des administrateurs ;
+	// chacun consulte les siennes sous /users/me/notifications
+	svc.Router.Handle("/notifications", notifications, svc.RequireUser(), svc.RequireAdmin(), web.Idempotency(web.IdempotencyTable{DB: db, Name: "notification_idempotency_keys"}))
+	svc.Router.HandleFunc("/notifications/", notificationHandler, svc.RequireUser(), svc.RequireAdmin())
+	svc.Router.HandleFunc("/users/me/notifications", inboxHandler, svc.RequireUser())
+	svc.Router.HandleFunc("/users/me/notifications/", inboxHandler, svc.RequireUser())
+	svc.Router.HandleFunc("/users/me/preferences", preferencesHandler, svc.RequireUser())
+	svc.Router.Handle("/templates", templates, svc.RequireUser(), svc.RequireAdmin())
+	svc.Router.HandleFunc("/templates/", templateHandler, svc.RequireUser(), svc.RequireAdmin())
+	svc.Run()
 }
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"shared/web"
+)
+
+func TestResolveRecipient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/internal/users/1":
+			web.WriteJSON(w, http.StatusOK, userContact{Name: "Alice", Email: "alice@example.com", Phone: "+33612345678"})
+		case "/internal/users/2":
+			web.WriteJSON(w, http.StatusOK, userContact{Name: "Bob"})
+		default:
+			web.Error(w, http.StatusNotFound, "not_found", "User not found")
+		}
+	}))
+	defer server.Close()
+	cfg.UserServiceURL = server.URL
+
+	tests := []struct {
+		name          string
+		notification  Notification
+		wantRecipient string
+		wantStatus    int
+		wantCode      string
+	}{
+		{name: "email from the user", notification: Notification{UserID: "1", Channel: ChannelEmail}, wantRecipient: "alice@example.com"},
+		{name: "sms from the user", notification: Notification{UserID: "1", Channel: ChannelSMS}, wantRecipient: "+33612345678"},
+		{name: "in-app needs no address", notification: Notification{UserID: "1", Channel: ChannelInApp}},
+		{name: "webhook goes to the configured URL", notification: Notification{Channel: ChannelWebhook}},
+		{name: "client-supplied address", notification: Notification{UserID: "1", Channel: ChannelEmail, Recipient: "victim@example.com"}, wantStatus: http.StatusUnprocessableEntity, wantCode: "validation_failed"},
+		{name: "no user", notification: Notification{Channel: ChannelEmail}, wantStatus: http.StatusUnprocessableEntity, wantCode: "validation_failed"},
+		{name: "user without email", notification: Notification{UserID: "2", Channel: ChannelEmail}, wantStatus: http.StatusUnprocessableEntity, wantCode: "no_recipient"},
+		{name: "user without phone", notification: Notification{UserID: "2", Channel: ChannelSMS}, wantStatus: http.StatusUnprocessableEntity, wantCode: "no_recipient"},
+		{name: "unknown user", notification: Notification{UserID: "3", Channel: ChannelSMS}, wantStatus: http.StatusUnprocessableEntity, wantCode: "no_recipient"},
+		{name: "not a user id", notification: Notification{UserID: "../admin", Channel: ChannelEmail}, wantStatus: http.StatusUnprocessableEntity, wantCode: "validation_failed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			notification := tt.notification
+			err := resolveRecipient(context.Background(), &notification)
+			if tt.wantStatus == 0 {
+				if err != nil {
+					t.Fatalf("resolveRecipient() error = %v", err)
+				}
+				if notification.Recipient != tt.wantRecipient {
+					t.Errorf("recipient = %q, want %q", notification.Recipient, tt.wantRecipient)
+				}
+				return
+			}
+			var statusErr *web.StatusError
+			if !errors.As(err, &statusErr) || statusErr.Status != tt.wantStatus || statusErr.Code != tt.wantCode {
+				t.Errorf("resolveRecipient() error = %v, want %d %s", err, tt.wantStatus, tt.wantCode)
+			}
+		})
+	}
+}
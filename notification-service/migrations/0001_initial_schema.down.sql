@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS notification_idempotency_keys;
+DROP TABLE IF EXISTS notification_processed_events;
+DROP TABLE IF EXISTS event_channel_preferences;
+DROP TABLE IF EXISTS notification_preferences;
+DROP TABLE IF EXISTS notification_templates;
+DROP TABLE IF EXISTS notifications;
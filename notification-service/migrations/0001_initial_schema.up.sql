@@ -0,0 +1,69 @@
+-- Schéma créé jusqu'ici par AutoMigrate ; une base existante s'adopte avec
+-- "migrate baseline", qui vérifie qu'elle correspond à cette migration
+CREATE TABLE notifications (
+    id bigserial PRIMARY KEY,
+    user_id text,
+    message text,
+    status text,
+    channel text,
+    recipient text,
+    subject text,
+    html_body text,
+    error text,
+    sent_at timestamptz,
+    attempts bigint,
+    next_attempt_at timestamptz,
+    locked_until timestamptz,
+    template text,
+    locale text,
+    template_version bigint,
+    category text,
+    read_at timestamptz
+);
+CREATE INDEX idx_notifications_next_attempt_at ON notifications (next_attempt_at);
+
+CREATE TABLE notification_templates (
+    id bigserial PRIMARY KEY,
+    name text,
+    locale text,
+    version bigint,
+    subject text,
+    body text,
+    html_body text,
+    created_at timestamptz
+);
+CREATE UNIQUE INDEX idx_notification_template_version ON notification_templates (name, locale, version);
+
+CREATE TABLE notification_preferences (
+    user_id text PRIMARY KEY,
+    marketing_opt_out boolean,
+    locale text,
+    quiet_hours_start text,
+    quiet_hours_end text,
+    time_zone text,
+    updated_at timestamptz
+);
+
+CREATE TABLE event_channel_preferences (
+    user_id text CONSTRAINT fk_notification_preferences_event_channels REFERENCES notification_preferences (user_id),
+    event_type text,
+    channel text,
+    PRIMARY KEY (user_id, event_type, channel)
+);
+
+CREATE TABLE notification_processed_events (
+    event_id text PRIMARY KEY,
+    type text,
+    processed_at timestamptz
+);
+
+CREATE TABLE notification_idempotency_keys (
+    idempotency_key text,
+    user_id text,
+    request_hash text,
+    status_code bigint,
+    content_type text,
+    body bytea,
+    created_at timestamptz,
+    PRIMARY KEY (idempotency_key, user_id)
+);
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"shared/web"
+)
+
+const (
+	CategoryTransactional = "transactional"
+	CategoryMarketing     = "marketing"
+)
+
+// NotificationStatusSuppressed : la notification n'est pas envoyée à cause des préférences du destinataire
+const NotificationStatusSuppressed = "suppressed"
+
+// NotificationPreference regroupe les choix d'un utilisateur ; sans ligne enregistrée,
+// toutes les notifications sont envoyées sur le canal demandé
+type NotificationPreference struct {
+	UserID          string `gorm:"primaryKey" json:"user_id"`
+	MarketingOptOut bool   `json:"marketing_opt_out"`
+	// Langue des notifications créées à partir des événements métier
+	Locale string `json:"locale"`
+	// Plage "HH:MM" pendant laquelle seules les notifications in-app sont délivrées
+	QuietHoursStart string                   `json:"quiet_hours_start"`
+	QuietHoursEnd   string                   `json:"quiet_hours_end"`
+	TimeZone        string                   `json:"time_zone"`
+	EventChannels   []EventChannelPreference `gorm:"foreignKey:UserID;references:UserID" json:"event_channels"`
+	UpdatedAt       time.Time                `json:"updated_at"`
+}
+
+// EventChannelPreference liste les canaux acceptés pour un type d'événement (nom du modèle)
+type EventChannelPreference struct {
+	UserID    string `gorm:"primaryKey" json:"-"`
+	EventType string `gorm:"primaryKey" json:"event_type" validate:"required,max=100"`
+	Channel   string `gorm:"primaryKey" json:"channel" validate:"required,oneof=email sms webhook in_app"`
+}
+
+func (p NotificationPreference) validate() error {
+	if p.Locale != "" && !isSupportedLocale(p.Locale) {
+		return fmt.Errorf("unsupported locale %q", p.Locale)
+	}
+	if _, err := time.LoadLocation(p.TimeZone); err != nil {
+		return fmt.Errorf("invalid time zone %q", p.TimeZone)
+	}
+	if (p.QuietHoursStart == "") != (p.QuietHoursEnd == "") {
+		return errors.New("quiet hours need both a start and an end")
+	}
+	for _, value := range []string{p.QuietHoursStart, p.QuietHoursEnd} {
+		if _, err := parseClock(value); value != "" && err != nil {
+			return fmt.Errorf("invalid quiet hours time %q, expected HH:MM", value)
+		}
+	}
+	return nil
+}
+
+// allowsChannel : sans préférence pour ce type d'événement, tous les canaux sont acceptés
+func (p NotificationPreference) allowsChannel(eventType, channel string) bool {
+	configured := false
+	for _, pref := range p.EventChannels {
+		if pref.EventType != eventType {
+			continue
+		}
+		configured = true
+		if pref.Channel == channel {
+			return true
+		}
+	}
+	return !configured
+}
+
+// requestsChannel indique si le canal est explicitement choisi pour ce type d'événement
+func (p NotificationPreference) requestsChannel(eventType, channel string) bool {
+	for _, pref := range p.EventChannels {
+		if pref.EventType == eventType && pref.Channel == channel {
+			return true
+		}
+	}
+	return false
+}
+
+// quietHoursEnd renvoie la fin des heures calmes si now y tombe, ou le zéro sinon. Les
+// heures sont celles de l'horloge locale : la fin d'une plage 22:00-07:00 reste 07:00 un
+// jour de changement d'heure.
+func (p NotificationPreference) quietHoursEnd(now time.Time) time.Time {
+	if p.QuietHoursStart == "" || p.QuietHoursStart == p.QuietHoursEnd {
+		return time.Time{}
+	}
+	loc, err := time.LoadLocation(p.TimeZone)
+	if err != nil {
+		loc = time.UTC
+	}
+	start, _ := parseClock(p.QuietHoursStart)
+	end, _ := parseClock(p.QuietHoursEnd)
+
+	local := now.In(loc)
+	current := local.Hour()*60 + local.Minute()
+	endOn := func(days int) time.Time {
+		return time.Date(local.Year(), local.Month(), local.Day()+days, end/60, end%60, 0, 0, loc)
+	}
+	if start < end {
+		if current >= start && current < end {
+			return endOn(0)
+		}
+		return time.Time{}
+	}
+	// Plage à cheval sur minuit, par exemple 22:00-07:00 : elle finit le lendemain si
+	// elle a commencé ce soir, ou ce matin si elle a commencé la veille
+	switch {
+	case current >= start:
+		return endOn(1)
+	case current < end:
+		return endOn(0)
+	}
+	return time.Time{}
+}
+
+// parseClock renvoie le nombre de minutes depuis minuit d'une heure "HH:MM"
+func parseClock(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func loadPreferences(userID string) (NotificationPreference, error) {
+	preference := NotificationPreference{UserID: userID, TimeZone: "UTC"}
+	err := db.Preload("EventChannels").First(&preference, "user_id = ?", userID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return preference, nil
+	}
+	return preference, err
+}
+
+// applyPreferences est appelé par le dispatcher juste avant l'envoi : il renvoie une
+// raison de suppression, ou la date à laquelle reporter l'envoi pendant les heures calmes
+func applyPreferences(notification Notification, now time.Time) (string, time.Time, error) {
+	if notification.UserID == "" {
+		return "", time.Time{}, nil
+	}
+	preference, err := loadPreferences(notification.UserID)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if notification.Category == CategoryMarketing && preference.MarketingOptOut {
+		return "recipient opted out of marketing notifications", time.Time{}, nil
+	}
+	if notification.Template != "" && !preference.allowsChannel(notification.Template, notification.Channel) {
+		return fmt.Sprintf("recipient disabled %s notifications for %s", notification.Channel, notification.Template), time.Time{}, nil
+	}
+	if notification.Channel != ChannelInApp {
+		return "", preference.quietHoursEnd(now), nil
+	}
+	return "", time.Time{}, nil
+}
+
+// preferencesHandler sert GET et PUT /users/me/preferences pour l'utilisateur authentifié
+func preferencesHandler(w http.ResponseWriter, r *http.Request) {
+	userID := web.UserID(r.Context())
+	switch r.Method {
+	case "GET":
+		preference, err := loadPreferences(userID)
+		if err != nil {
+			web.WriteError(w, err, "Preference")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(preference)
+	case "PUT":
+		updatePreferences(w, r, userID)
+	default:
+		web.Error(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+	}
+}
+
+func updatePreferences(w http.ResponseWriter, r *http.Request, userID string) {
+	var preference NotificationPreference
+	if err := web.DecodeJSON(w, r, &preference); err != nil {
+		web.WriteError(w, err, "Preference")
+		return
+	}
+	if preference.TimeZone == "" {
+		preference.TimeZone = "UTC"
+	}
+	if err := preference.validate(); err != nil {
+		web.Error(w, http.StatusBadRequest, "invalid_preferences", err.Error())
+		return
+	}
+	preference.UserID = userID
+	for i := range preference.EventChannels {
+		preference.EventChannels[i].UserID = userID
+	}
+
+	// Les préférences par événement sont remplacées en bloc
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("EventChannels").Clauses(clause.OnConflict{UpdateAll: true}).Create(&preference).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&EventChannelPreference{}, "user_id = ?", userID).Error; err != nil {
+			return err
+		}
+		if len(preference.EventChannels) == 0 {
+			return nil
+		}
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&preference.EventChannels).Error
+	})
+	if err != nil {
+		web.WriteError(w, err, "Preference")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(preference)
+}
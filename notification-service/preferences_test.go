@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// La fin des heures calmes est une heure de l'horloge locale, y compris les jours de
+// changement d'heure (29 mars et 25 octobre 2026 à Paris)
+func TestQuietHoursEnd(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Skip("time zone database unavailable:", err)
+	}
+	at := func(loc *time.Location, month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2026, month, day, hour, minute, 0, 0, loc)
+	}
+	tests := []struct {
+		name     string
+		start    string
+		end      string
+		timeZone string
+		now      time.Time
+		want     time.Time
+	}{
+		{name: "within daytime window", start: "09:00", end: "17:00", timeZone: "Europe/Paris", now: at(paris, time.June, 10, 10, 0), want: at(paris, time.June, 10, 17, 0)},
+		{name: "before daytime window", start: "09:00", end: "17:00", timeZone: "Europe/Paris", now: at(paris, time.June, 10, 8, 59)},
+		{name: "end is excluded", start: "09:00", end: "17:00", timeZone: "Europe/Paris", now: at(paris, time.June, 10, 17, 0)},
+		{name: "overnight, evening", start: "22:00", end: "07:00", timeZone: "Europe/Paris", now: at(paris, time.June, 10, 23, 30), want: at(paris, time.June, 11, 7, 0)},
+		{name: "overnight, start included", start: "22:00", end: "07:00", timeZone: "Europe/Paris", now: at(paris, time.June, 10, 22, 0), want: at(paris, time.June, 11, 7, 0)},
+		{name: "overnight, early morning", start: "22:00", end: "07:00", timeZone: "Europe/Paris", now: at(paris, time.June, 11, 3, 0), want: at(paris, time.June, 11, 7, 0)},
+		{name: "overnight, daytime", start: "22:00", end: "07:00", timeZone: "Europe/Paris", now: at(paris, time.June, 11, 12, 0)},
+		{name: "overnight, end of month", start: "22:00", end: "07:00", timeZone: "Europe/Paris", now: at(paris, time.June, 30, 23, 0), want: at(paris, time.July, 1, 7, 0)},
+		{name: "night before spring forward", start: "22:00", end: "07:00", timeZone: "Europe/Paris", now: at(paris, time.March, 28, 23, 0), want: at(paris, time.March, 29, 7, 0)},
+		{name: "spring forward morning", start: "01:00", end: "09:00", timeZone: "Europe/Paris", now: at(paris, time.March, 29, 1, 30), want: at(paris, time.March, 29, 9, 0)},
+		{name: "night before fall back", start: "22:00", end: "07:00", timeZone: "Europe/Paris", now: at(paris, time.October, 24, 23, 0), want: at(paris, time.October, 25, 7, 0)},
+		{name: "fall back morning", start: "00:00", end: "07:00", timeZone: "Europe/Paris", now: at(paris, time.October, 25, 4, 0), want: at(paris, time.October, 25, 7, 0)},
+		{name: "now in another zone", start: "22:00", end: "07:00", timeZone: "Europe/Paris", now: at(time.UTC, time.June, 10, 21, 0), want: at(paris, time.June, 11, 7, 0)},
+		{name: "unknown zone falls back to UTC", start: "22:00", end: "07:00", timeZone: "Mars/Olympus", now: at(time.UTC, time.June, 10, 23, 0), want: at(time.UTC, time.June, 11, 7, 0)},
+		{name: "no quiet hours", timeZone: "UTC", now: at(time.UTC, time.June, 10, 23, 0)},
+		{name: "empty window", start: "22:00", end: "22:00", timeZone: "UTC", now: at(time.UTC, time.June, 10, 22, 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			preference := NotificationPreference{QuietHoursStart: tt.start, QuietHoursEnd: tt.end, TimeZone: tt.timeZone}
+			if got := preference.quietHoursEnd(tt.now); !got.Equal(tt.want) {
+				t.Errorf("quietHoursEnd() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
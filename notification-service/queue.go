@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"shared/service"
+	"shared/web"
+)
+
+const (
+	NotificationStatusSending    = "sending"
+	NotificationStatusDeadLetter = "dead_letter"
+)
+
+const (
+	dispatchPollInterval = 2 * time.Second
+	dispatchLease        = 2 * time.Minute
+	dispatchSendTimeout  = 30 * time.Second
+	retryBaseDelay       = 30 * time.Second
+	retryMaxDelay        = time.Hour
+)
+
+var dispatchWakeup = make(chan struct{}, 1)
+
+// startDispatcher lance le pool de workers qui vident la file des notifications
+func startDispatcher(svc *service.Service) {
+	for i := 0; i < cfg.NotificationWorkers; i++ {
+		svc.Go(dispatchWorker)
+	}
+	slog.Info("notification dispatcher started", "workers", cfg.NotificationWorkers)
+}
+
+// wakeDispatcher évite d'attendre le prochain tour de scrutation après un ajout dans la file
+func wakeDispatcher() {
+	select {
+	case dispatchWakeup <- struct{}{}:
+	default:
+	}
+}
+
+func dispatchWorker(ctx context.Context) {
+	ticker := time.NewTicker(dispatchPollInterval)
+	defer ticker.Stop()
+	for {
+		// À l'arrêt, le worker termine l'envoi en cours mais n'en réserve plus
+		for ctx.Err() == nil {
+			notification, err := claimNotification()
+			if err != nil {
+				slog.ErrorContext(ctx, "failed to claim notification", "error", err)
+				break
+			}
+			if notification == nil {
+				break
+			}
+			deliverNotification(context.WithoutCancel(ctx), *notification)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-dispatchWakeup:
+		}
+	}
+}
+
+// claimNotification réserve la prochaine notification à envoyer ; SKIP LOCKED permet
+// à plusieurs workers et instances de se partager la file, et les envois interrompus
+// par un redémarrage sont repris à l'expiration de leur bail
+func claimNotification() (*Notification, error) {
+	now := time.Now()
+	var notification Notification
+	result := db.Raw(`UPDATE notifications SET status = ?, locked_until = ?, attempts = attempts + 1
+		WHERE id = (
+			SELECT id FROM notifications
+			WHERE (status IN (?, ?) AND next_attempt_at <= ?) OR (status = ? AND locked_until < ?)
+			ORDER BY next_attempt_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *`,
+		NotificationStatusSending, now.Add(dispatchLease),
+		NotificationStatusPending, NotificationStatusFailed, now,
+		NotificationStatusSending, now,
+	).Scan(&notification)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if notification.ID == 0 {
+		return nil, nil
+	}
+	return &notification, nil
+}
+
+func deliverNotification(ctx context.Context, notification Notification) {
+	ctx, cancel := context.WithTimeout(ctx, dispatchSendTimeout)
+	defer cancel()
+
+	updates := map[string]interface{}{"locked_until": nil}
+	reason, deferUntil, err := applyPreferences(notification, time.Now())
+	switch {
+	case err != nil:
+	case reason != "":
+		updates["status"] = NotificationStatusSuppressed
+		updates["error"] = reason
+		saveDeliveryResult(notification.ID, updates)
+		return
+	case !deferUntil.IsZero():
+		// Heures calmes : le report ne compte pas comme une tentative
+		updates["status"] = NotificationStatusPending
+		updates["attempts"] = notification.Attempts - 1
+		updates["next_attempt_at"] = deferUntil
+		saveDeliveryResult(notification.ID, updates)
+		return
+	default:
+		channel, ok := channels[notification.Channel]
+		if !ok {
+			err = errUnknownChannel
+		} else {
+			err = channel.Send(ctx, notification)
+		}
+	}
+
+	switch {
+	case err == nil:
+		updates["status"] = NotificationStatusSent
+		updates["error"] = ""
+		updates["sent_at"] = time.Now()
+	case isPermanentDeliveryError(err) || notification.Attempts >= cfg.NotificationMaxAttempts:
+		slog.ErrorContext(ctx, "notification moved to dead letter", "notification_id", notification.ID, "attempts", notification.Attempts, "error", err)
+		updates["status"] = NotificationStatusDeadLetter
+		updates["error"] = err.Error()
+	default:
+		delay := retryDelay(notification.Attempts)
+		slog.WarnContext(ctx, "notification delivery failed, retrying", "notification_id", notification.ID, "attempt", notification.Attempts, "retry_in", delay.String(), "error", err)
+		updates["status"] = NotificationStatusFailed
+		updates["error"] = err.Error()
+		updates["next_attempt_at"] = time.Now().Add(delay)
+	}
+	saveDeliveryResult(notification.ID, updates)
+}
+
+func saveDeliveryResult(id uint, updates map[string]interface{}) {
+	if err := db.Model(&Notification{}).Where("id = ?", id).Updates(updates).Error; err != nil {
+		slog.Error("failed to update notification status", "notification_id", id, "error", err)
+	}
+}
+
+var errUnknownChannel = errors.New("unknown channel")
+
+// Réessayer ne corrigera pas une notification sans destinataire ou sur un canal inconnu
+func isPermanentDeliveryError(err error) bool {
+	return errors.Is(err, ErrNoRecipient) || errors.Is(err, errUnknownChannel)
+}
+
+// retryDelay double le délai à chaque tentative, avec ±20 % d'aléa pour étaler les reprises
+func retryDelay(attempts int) time.Duration {
+	delay := float64(retryBaseDelay) * math.Pow(2, float64(attempts-1))
+	if delay > float64(retryMaxDelay) {
+		delay = float64(retryMaxDelay)
+	}
+	jitter := 0.8 + rand.Float64()*0.4
+	return time.Duration(delay * jitter)
+}
+
+// redriveHandler remet en file une notification en dead letter, ou toutes avec /notifications/dead-letters/redrive
+func redriveHandler(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSuffix(web.PathID(r, "/notifications/"), "/redrive")
+	if r.Method != "POST" {
+		web.Error(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+	query := db.Model(&Notification{}).Where("status = ?", NotificationStatusDeadLetter)
+	if id != "dead-letters" {
+		query = query.Where("id = ?", id)
+	}
+	result := query.Updates(map[string]interface{}{
+		"status":          NotificationStatusPending,
+		"attempts":        0,
+		"error":           "",
+		"next_attempt_at": time.Now(),
+	})
+	if result.Error != nil {
+		web.WriteError(w, result.Error, "Notification")
+		return
+	}
+	if id != "dead-letters" && result.RowsAffected == 0 {
+		web.Error(w, http.StatusNotFound, "not_found", "Notification not found in dead letter")
+		return
+	}
+	wakeDispatcher()
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"redriven":` + strconv.FormatInt(result.RowsAffected, 10) + `}`))
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempts int
+		base     time.Duration
+	}{
+		{attempts: 1, base: 30 * time.Second},
+		{attempts: 2, base: time.Minute},
+		{attempts: 3, base: 2 * time.Minute},
+		{attempts: 5, base: 8 * time.Minute},
+		{attempts: 7, base: 32 * time.Minute},
+		// Plafonné à une heure
+		{attempts: 8, base: time.Hour},
+		{attempts: 100, base: time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("attempt %d", tt.attempts), func(t *testing.T) {
+			low, high := tt.base*8/10, tt.base*12/10
+			for i := 0; i < 100; i++ {
+				if got := retryDelay(tt.attempts); got < low || got > high {
+					t.Fatalf("retryDelay(%d) = %v, want between %v and %v", tt.attempts, got, low, high)
+				}
+			}
+		})
+	}
+}
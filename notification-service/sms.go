@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"shared/tracing"
+)
+
+// SMSProvider est implémenté par chaque opérateur SMS
+type SMSProvider interface {
+	SendSMS(ctx context.Context, to string, body string) error
+}
+
+func newSMSProvider() (SMSProvider, error) {
+	switch name := cfg.SMSProvider; name {
+	case "fake":
+		return &fakeSMSProvider{}, nil
+	case "http":
+		return &httpSMSProvider{
+			apiURL:    cfg.SMSAPIURL,
+			accountID: cfg.SMSAccountID,
+			apiKey:    cfg.SMSAPIKey,
+			from:      cfg.SMSFrom,
+			client:    tracing.Client(10 * time.Second),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown SMS provider %q", name)
+	}
+}
+
+type smsChannel struct {
+	provider SMSProvider
+}
+
+func (c *smsChannel) Name() string {
+	return ChannelSMS
+}
+
+func (c *smsChannel) Send(ctx context.Context, notification Notification) error {
+	if notification.Recipient == "" {
+		return ErrNoRecipient
+	}
+	return c.provider.SendSMS(ctx, notification.Recipient, notification.Message)
+}
+
+type sentSMS struct {
+	To   string
+	Body string
+}
+
+// fakeSMSProvider garde les SMS en mémoire pour le développement et les tests
+type fakeSMSProvider struct {
+	mu   sync.Mutex
+	sent []sentSMS
+}
+
+func (p *fakeSMSProvider) SendSMS(ctx context.Context, to string, body string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sent = append(p.sent, sentSMS{To: to, Body: body})
+	slog.InfoContext(ctx, "fake SMS sent", "to", to, "body", body)
+	return nil
+}
+
+// httpSMSProvider parle à une API de type Twilio (formulaire POST + authentification basique)
+type httpSMSProvider struct {
+	apiURL    string
+	accountID string
+	apiKey    string
+	from      string
+	client    *http.Client
+}
+
+func (p *httpSMSProvider) SendSMS(ctx context.Context, to string, body string) error {
+	form := url.Values{}
+	form.Set("To", to)
+	form.Set("From", p.from)
+	form.Set("Body", body)
+	req, err := http.NewRequestWithContext(ctx, "POST", p.apiURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(p.accountID, p.apiKey)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("SMS provider returned status %d", resp.StatusCode)
+	}
+	return nil
+}
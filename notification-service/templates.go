@@ -0,0 +1,411 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"shared/web"
+)
+
+var supportedLocales = []string{"fr", "en"}
+
+var ErrTemplateNotFound = errors.New("notification template not found")
+
+// NotificationTemplate est versionné : une modification crée une nouvelle version,
+// les notifications déjà émises gardent la trace de celle utilisée
+type NotificationTemplate struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	Name      string    `gorm:"uniqueIndex:idx_notification_template_version" json:"name" validate:"required,max=100"`
+	Locale    string    `gorm:"uniqueIndex:idx_notification_template_version" json:"locale"`
+	Version   int       `gorm:"uniqueIndex:idx_notification_template_version" json:"version"`
+	Subject   string    `json:"subject" validate:"max=255"`
+	Body      string    `json:"body" validate:"required"`
+	HTMLBody  string    `json:"html_body,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type renderedTemplate struct {
+	Subject  string `json:"subject"`
+	Message  string `json:"message"`
+	HTMLBody string `json:"html_body,omitempty"`
+}
+
+// Modèles livrés avec le service, insérés en version 1 s'ils n'existent pas encore
+var defaultTemplates = []NotificationTemplate{
+	{
+		Name: "order_created", Locale: "fr",
+		Subject: "Commande n° {{.OrderID}} enregistrée",
+		Body:    "Bonjour{{with .CustomerName}} {{.}}{{end}},\n\nNous avons bien reçu votre commande n° {{.OrderID}} : {{.Quantity}} × {{.ProductName}}.\nNous vous préviendrons dès son expédition.",
+	},
+	{
+		Name: "order_created", Locale: "en",
+		Subject: "Order #{{.OrderID}} received",
+		Body:    "Hello{{with .CustomerName}} {{.}}{{end}},\n\nWe have received your order #{{.OrderID}}: {{.Quantity}} × {{.ProductName}}.\nWe will let you know as soon as it ships.",
+	},
+	{
+		Name: "order_shipped", Locale: "fr",
+		Subject: "Votre commande n° {{.OrderID}} a été expédiée",
+		Body:    "Bonjour{{with .CustomerName}} {{.}}{{end}},\n\nVotre commande n° {{.OrderID}} ({{.ProductName}}) a été expédiée.",
+	},
+	{
+		Name: "order_shipped", Locale: "en",
+		Subject: "Your order #{{.OrderID}} has shipped",
+		Body:    "Hello{{with .CustomerName}} {{.}}{{end}},\n\nYour order #{{.OrderID}} ({{.ProductName}}) is on its way.",
+	},
+	{
+		Name: "payment_captured", Locale: "fr",
+		Subject: "Paiement reçu pour la commande n° {{.OrderID}}",
+		Body:    "Nous avons reçu votre paiement de {{money .Amount .Currency}} pour la commande n° {{.OrderID}}.",
+	},
+	{
+		Name: "payment_captured", Locale: "en",
+		Subject: "Payment received for order #{{.OrderID}}",
+		Body:    "We have received your payment of {{money .Amount .Currency}} for order #{{.OrderID}}.",
+	},
+	{
+		Name: "payment_refunded", Locale: "fr",
+		Subject: "Remboursement de la commande n° {{.OrderID}}",
+		Body:    "Un remboursement de {{money .Amount .Currency}} a été effectué pour la commande n° {{.OrderID}}.",
+	},
+	{
+		Name: "payment_refunded", Locale: "en",
+		Subject: "Refund for order #{{.OrderID}}",
+		Body:    "A refund of {{money .Amount .Currency}} has been issued for order #{{.OrderID}}.",
+	},
+	{
+		Name: "payment_failed", Locale: "fr",
+		Subject: "Échec du paiement de la commande n° {{.OrderID}}",
+		Body:    "Votre paiement de {{money .Amount .Currency}} pour la commande n° {{.OrderID}} n'a pas abouti. Vous pouvez réessayer avec un autre moyen de paiement.",
+	},
+	{
+		Name: "payment_failed", Locale: "en",
+		Subject: "Payment failed for order #{{.OrderID}}",
+		Body:    "Your payment of {{money .Amount .Currency}} for order #{{.OrderID}} could not be completed. Please try again with another payment method.",
+	},
+}
+
+func seedTemplates() {
+	for _, tpl := range defaultTemplates {
+		tpl.Version = 1
+		if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&tpl).Error; err != nil {
+			slog.Error("failed to seed template", "template", tpl.Name, "locale", tpl.Locale, "error", err)
+		}
+	}
+}
+
+// templateFuncs met en forme montants et dates selon la langue du destinataire
+func templateFuncs(locale string) map[string]interface{} {
+	return map[string]interface{}{
+		"money": func(amount interface{}, currency interface{}) (string, error) {
+			value, err := toFloat(amount)
+			if err != nil {
+				return "", err
+			}
+			code := strings.ToUpper(fmt.Sprint(currency))
+			symbol := map[string]string{"EUR": "€", "USD": "$", "GBP": "£"}[code]
+			if symbol == "" {
+				symbol = code
+			}
+			if locale == "fr" {
+				return strings.Replace(fmt.Sprintf("%.2f", value), ".", ",", 1) + " " + symbol, nil
+			}
+			return symbol + fmt.Sprintf("%.2f", value), nil
+		},
+		"date": func(value interface{}) (string, error) {
+			var t time.Time
+			switch v := value.(type) {
+			case time.Time:
+				t = v
+			case string:
+				parsed, err := time.Parse(time.RFC3339, v)
+				if err != nil {
+					return "", err
+				}
+				t = parsed
+			default:
+				return "", fmt.Errorf("cannot format %T as a date", value)
+			}
+			if locale == "fr" {
+				return t.Format("02/01/2006"), nil
+			}
+			return t.Format("2 January 2006"), nil
+		},
+	}
+}
+
+func toFloat(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case int:
+		return float64(v), nil
+	case json.Number:
+		return v.Float64()
+	case string:
+		return strconv.ParseFloat(v, 64)
+	default:
+		return 0, fmt.Errorf("cannot format %T as an amount", value)
+	}
+}
+
+// render applique les données au modèle ; une variable manquante est une erreur
+// plutôt qu'un "<no value>" envoyé au client
+func (tpl NotificationTemplate) render(data map[string]interface{}) (renderedTemplate, error) {
+	var rendered renderedTemplate
+	funcs := templateFuncs(tpl.Locale)
+
+	execute := func(name, text string) (string, error) {
+		t, err := template.New(name).Funcs(funcs).Option("missingkey=error").Parse(text)
+		if err != nil {
+			return "", err
+		}
+		var buf bytes.Buffer
+		if err := t.Execute(&buf, data); err != nil {
+			return "", err
+		}
+		return buf.String(), nil
+	}
+
+	var err error
+	if rendered.Subject, err = execute("subject", tpl.Subject); err != nil {
+		return rendered, err
+	}
+	if rendered.Message, err = execute("body", tpl.Body); err != nil {
+		return rendered, err
+	}
+	if tpl.HTMLBody != "" {
+		t, err := htmltemplate.New("html").Funcs(funcs).Option("missingkey=error").Parse(tpl.HTMLBody)
+		if err != nil {
+			return rendered, err
+		}
+		var buf bytes.Buffer
+		if err := t.Execute(&buf, data); err != nil {
+			return rendered, err
+		}
+		rendered.HTMLBody = buf.String()
+	}
+	return rendered, nil
+}
+
+// validate vérifie la syntaxe du modèle avant de l'enregistrer
+func (tpl NotificationTemplate) validate() error {
+	funcs := templateFuncs(tpl.Locale)
+	if _, err := template.New("subject").Funcs(funcs).Parse(tpl.Subject); err != nil {
+		return err
+	}
+	if _, err := template.New("body").Funcs(funcs).Parse(tpl.Body); err != nil {
+		return err
+	}
+	if _, err := htmltemplate.New("html").Funcs(funcs).Parse(tpl.HTMLBody); err != nil {
+		return err
+	}
+	return nil
+}
+
+// findTemplate cherche la langue demandée, puis la langue sans région ("fr-CA" → "fr"),
+// puis la langue par défaut ; version 0 désigne la dernière version
+func findTemplate(name, locale string, version int) (NotificationTemplate, error) {
+	var tpl NotificationTemplate
+	candidates := []string{strings.ToLower(locale)}
+	if base, _, found := strings.Cut(candidates[0], "-"); found {
+		candidates = append(candidates, base)
+	}
+	candidates = append(candidates, cfg.DefaultLocale)
+
+	for _, candidate := range candidates {
+		if candidate == "" {
+			continue
+		}
+		query := db.Where("name = ? AND locale = ?", name, candidate)
+		if version > 0 {
+			query = query.Where("version = ?", version)
+		}
+		err := query.Order("version DESC").First(&tpl).Error
+		if err == nil {
+			return tpl, nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return tpl, err
+		}
+	}
+	return tpl, ErrTemplateNotFound
+}
+
+// applyTemplate remplit le sujet et le message d'une notification créée à partir d'un modèle
+func applyTemplate(notification *Notification) error {
+	tpl, err := findTemplate(notification.Template, notification.Locale, notification.TemplateVersion)
+	if err != nil {
+		return err
+	}
+	rendered, err := tpl.render(notification.Data)
+	if err != nil {
+		return err
+	}
+	notification.Locale = tpl.Locale
+	notification.TemplateVersion = tpl.Version
+	notification.Subject = rendered.Subject
+	notification.Message = rendered.Message
+	notification.HTMLBody = rendered.HTMLBody
+	return nil
+}
+
+// templateHandler sert /templates/{name}/{locale} et /templates/preview
+func templateHandler(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path[len("/templates/"):]
+	if path == "preview" {
+		previewTemplate(w, r)
+		return
+	}
+	if r.Method != "GET" {
+		web.Error(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+	name, locale, _ := strings.Cut(path, "/")
+	version, _ := strconv.Atoi(r.URL.Query().Get("version"))
+	tpl, err := findTemplate(name, locale, version)
+	if errors.Is(err, ErrTemplateNotFound) {
+		web.Error(w, http.StatusNotFound, "not_found", "Template not found")
+		return
+	}
+	if err != nil {
+		web.WriteError(w, err, "Template")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tpl)
+}
+
+func getTemplates(w http.ResponseWriter, r *http.Request) {
+	query := db.Order("name, locale, version")
+	if name := r.URL.Query().Get("name"); name != "" {
+		query = query.Where("name = ?", name)
+	}
+	if locale := r.URL.Query().Get("locale"); locale != "" {
+		query = query.Where("locale = ?", locale)
+	}
+	var templates []NotificationTemplate
+	if err := query.Find(&templates).Error; err != nil {
+		web.WriteError(w, err, "Template")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(templates)
+}
+
+// createTemplate enregistre une nouvelle version du modèle pour cette langue
+func createTemplate(w http.ResponseWriter, r *http.Request) {
+	var tpl NotificationTemplate
+	if err := web.DecodeJSON(w, r, &tpl); err != nil {
+		web.WriteError(w, err, "Template")
+		return
+	}
+	tpl.Locale = strings.ToLower(tpl.Locale)
+	if !isSupportedLocale(tpl.Locale) {
+		web.Error(w, http.StatusBadRequest, "unsupported_locale", "Unsupported locale")
+		return
+	}
+	if err := tpl.validate(); err != nil {
+		web.Error(w, http.StatusUnprocessableEntity, "invalid_template", err.Error())
+		return
+	}
+
+	tpl.ID = 0
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var latest int
+		if err := tx.Model(&NotificationTemplate{}).
+			Where("name = ? AND locale = ?", tpl.Name, tpl.Locale).
+			Select("COALESCE(MAX(version), 0)").Scan(&latest).Error; err != nil {
+			return err
+		}
+		tpl.Version = latest + 1
+		return tx.Create(&tpl).Error
+	})
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		// Une création concurrente a pris le même numéro de version
+		web.Error(w, http.StatusConflict, "template_version_conflict", "A concurrent update created the same template version")
+		return
+	}
+	if err != nil {
+		web.WriteError(w, err, "Template")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(tpl)
+}
+
+type previewRequest struct {
+	Template string                 `json:"template"`
+	Locale   string                 `json:"locale"`
+	Version  int                    `json:"version"`
+	Data     map[string]interface{} `json:"data"`
+	// Brouillon à prévisualiser avant de l'enregistrer
+	Subject  string `json:"subject"`
+	Body     string `json:"body"`
+	HTMLBody string `json:"html_body"`
+}
+
+// previewTemplate rend un modèle enregistré ou un brouillon sans créer de notification
+func previewTemplate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		web.Error(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+	var req previewRequest
+	if err := web.DecodeJSON(w, r, &req); err != nil {
+		web.WriteError(w, err, "Template")
+		return
+	}
+
+	var tpl NotificationTemplate
+	if req.Body != "" {
+		tpl = NotificationTemplate{Name: req.Template, Locale: strings.ToLower(req.Locale), Subject: req.Subject, Body: req.Body, HTMLBody: req.HTMLBody}
+		if tpl.Locale == "" {
+			tpl.Locale = cfg.DefaultLocale
+		}
+	} else {
+		var err error
+		tpl, err = findTemplate(req.Template, req.Locale, req.Version)
+		if errors.Is(err, ErrTemplateNotFound) {
+			web.Error(w, http.StatusNotFound, "not_found", "Template not found")
+			return
+		}
+		if err != nil {
+			web.WriteError(w, err, "Template")
+			return
+		}
+	}
+
+	rendered, err := tpl.render(req.Data)
+	if err != nil {
+		web.Error(w, http.StatusUnprocessableEntity, "template_render_failed", err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Template string `json:"template"`
+		Locale   string `json:"locale"`
+		Version  int    `json:"version,omitempty"`
+		renderedTemplate
+	}{tpl.Name, tpl.Locale, tpl.Version, rendered})
+}
+
+func isSupportedLocale(locale string) bool {
+	for _, supported := range supportedLocales {
+		if locale == supported {
+			return true
+		}
+	}
+	return false
+}
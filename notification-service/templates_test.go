@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func defaultTemplate(t *testing.T, name, locale string) NotificationTemplate {
+	t.Helper()
+	for _, tpl := range defaultTemplates {
+		if tpl.Name == name && tpl.Locale == locale {
+			return tpl
+		}
+	}
+	t.Fatalf("no default template %s/%s", name, locale)
+	return NotificationTemplate{}
+}
+
+func TestRenderDefaultTemplates(t *testing.T) {
+	order := map[string]interface{}{"OrderID": "42", "ProductName": "Clavier", "Quantity": 2, "CustomerName": "Alice"}
+	payment := map[string]interface{}{"OrderID": "42", "Amount": 1234.5, "Currency": "eur"}
+	tests := []struct {
+		name        string
+		template    string
+		locale      string
+		data        map[string]interface{}
+		wantSubject string
+		wantMessage string
+	}{
+		{
+			name: "order created in French", template: "order_created", locale: "fr", data: order,
+			wantSubject: "Commande n° 42 enregistrée",
+			wantMessage: "Bonjour Alice,\n\nNous avons bien reçu votre commande n° 42 : 2 × Clavier.\nNous vous préviendrons dès son expédition.",
+		},
+		{
+			name: "order created without customer name", template: "order_created", locale: "en",
+			data:        map[string]interface{}{"OrderID": "42", "ProductName": "Keyboard", "Quantity": 1, "CustomerName": ""},
+			wantSubject: "Order #42 received",
+			wantMessage: "Hello,\n\nWe have received your order #42: 1 × Keyboard.\nWe will let you know as soon as it ships.",
+		},
+		{
+			name: "amount in French", template: "payment_captured", locale: "fr", data: payment,
+			wantSubject: "Paiement reçu pour la commande n° 42",
+			wantMessage: "Nous avons reçu votre paiement de 1234,50 € pour la commande n° 42.",
+		},
+		{
+			name: "amount in English", template: "payment_refunded", locale: "en", data: payment,
+			wantSubject: "Refund for order #42",
+			wantMessage: "A refund of €1234.50 has been issued for order #42.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rendered, err := defaultTemplate(t, tt.template, tt.locale).render(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rendered.Subject != tt.wantSubject {
+				t.Errorf("subject = %q, want %q", rendered.Subject, tt.wantSubject)
+			}
+			if rendered.Message != tt.wantMessage {
+				t.Errorf("message = %q, want %q", rendered.Message, tt.wantMessage)
+			}
+		})
+	}
+}
+
+func TestRenderTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		template NotificationTemplate
+		data     map[string]interface{}
+		want     renderedTemplate
+		wantErr  string
+	}{
+		{
+			name:     "unknown currency keeps its code",
+			template: NotificationTemplate{Locale: "en", Body: "{{money .Amount .Currency}}"},
+			data:     map[string]interface{}{"Amount": json.Number("9.9"), "Currency": "chf"},
+			want:     renderedTemplate{Message: "CHF9.90"},
+		},
+		{
+			name:     "amount given as a string",
+			template: NotificationTemplate{Locale: "fr", Body: "{{money .Amount .Currency}}"},
+			data:     map[string]interface{}{"Amount": "0.5", "Currency": "GBP"},
+			want:     renderedTemplate{Message: "0,50 £"},
+		},
+		{
+			name:     "dates follow the locale",
+			template: NotificationTemplate{Locale: "fr", Subject: "{{date .ShippedAt}}", Body: "{{date .ShippedAt}}"},
+			data:     map[string]interface{}{"ShippedAt": "2026-03-05T10:00:00Z"},
+			want:     renderedTemplate{Subject: "05/03/2026", Message: "05/03/2026"},
+		},
+		{
+			name:     "English date",
+			template: NotificationTemplate{Locale: "en", Body: "{{date .ShippedAt}}"},
+			data:     map[string]interface{}{"ShippedAt": "2026-03-05T10:00:00Z"},
+			want:     renderedTemplate{Message: "5 March 2026"},
+		},
+		{
+			name:     "HTML body is escaped, text body is not",
+			template: NotificationTemplate{Locale: "en", Body: "Hi {{.Name}}", HTMLBody: "<p>Hi {{.Name}}</p>"},
+			data:     map[string]interface{}{"Name": "<b>Bob</b>"},
+			want:     renderedTemplate{Message: "Hi <b>Bob</b>", HTMLBody: "<p>Hi &lt;b&gt;Bob&lt;/b&gt;</p>"},
+		},
+		{
+			name:     "missing variable",
+			template: NotificationTemplate{Locale: "en", Subject: "Order #{{.OrderID}}", Body: "Hello"},
+			data:     map[string]interface{}{},
+			wantErr:  `map has no entry for key "OrderID"`,
+		},
+		{
+			name:     "missing variable in HTML body",
+			template: NotificationTemplate{Locale: "en", Body: "Hello", HTMLBody: "<p>{{.Name}}</p>"},
+			data:     map[string]interface{}{},
+			wantErr:  `map has no entry for key "Name"`,
+		},
+		{
+			name:     "amount that is not a number",
+			template: NotificationTemplate{Locale: "en", Body: "{{money .Amount .Currency}}"},
+			data:     map[string]interface{}{"Amount": true, "Currency": "EUR"},
+			wantErr:  "cannot format bool as an amount",
+		},
+		{
+			name:     "invalid date",
+			template: NotificationTemplate{Locale: "en", Body: "{{date .ShippedAt}}"},
+			data:     map[string]interface{}{"ShippedAt": "tomorrow"},
+			wantErr:  "cannot parse",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.template.render(tt.data)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("render() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("render() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		template NotificationTemplate
+		wantErr  bool
+	}{
+		{name: "default template", template: NotificationTemplate{Locale: "fr", Subject: "{{.OrderID}}", Body: "{{money .Amount .Currency}}"}},
+		{name: "unclosed action", template: NotificationTemplate{Locale: "fr", Body: "{{.OrderID"}, wantErr: true},
+		{name: "unknown function", template: NotificationTemplate{Locale: "fr", Body: "{{upper .Name}}"}, wantErr: true},
+		{name: "invalid HTML body", template: NotificationTemplate{Locale: "fr", Body: "ok", HTMLBody: "{{end}}"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.template.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
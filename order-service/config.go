@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+
+	"shared/config"
+)
+
+// Config est lue au démarrage depuis l'environnement, voir le paquet shared/config
+type Config struct {
+	config.Server
+	config.Database
+	config.Auth
+	ProductServiceURL string `env:"PRODUCT_SERVICE_URL" required:"true"`
+	config.Internal
+	// Utilisateurs qui voient toutes les commandes et en font avancer le statut
+	config.Admin
+	// Vide ou "memory://" : bus en mémoire, limité au processus
+	EventBusURL string `env:"EVENT_BUS_URL"`
+
+	// Vendeur indiqué sur les factures
+	SellerName         string  `env:"SELLER_NAME" default:"Microservices Shop"`
+	SellerAddressLine1 string  `env:"SELLER_ADDRESS_LINE1"`
+	SellerPostalCode   string  `env:"SELLER_POSTAL_CODE"`
+	SellerCity         string  `env:"SELLER_CITY"`
+	SellerCountry      string  `env:"SELLER_COUNTRY" default:"FR"`
+	SellerVATNumber    string  `env:"SELLER_VAT_NUMBER"`
+	DefaultVATRate     float64 `env:"DEFAULT_VAT_RATE" default:"0.20"`
+}
+
+var cfg = Config{Server: config.Server{Port: 8083}}
+
+func (c Config) Validate() error {
+	if c.DefaultVATRate < 0 || c.DefaultVATRate >= 1 {
+		return errors.New("DEFAULT_VAT_RATE must be a fraction between 0 and 1")
+	}
+	return nil
+}
+
+func (c Config) sellerAddress() BillingAddress {
+	return BillingAddress{
+		Name:       c.SellerName,
+		Line1:      c.SellerAddressLine1,
+		PostalCode: c.SellerPostalCode,
+		City:       c.SellerCity,
+		Country:    c.SellerCountry,
+	}
+}
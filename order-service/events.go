@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+	"shared/bus"
+	"shared/logging"
+	"shared/outbox"
+)
+
+const (
+	EventOrderPlaced   = "order.placed"
+	EventOrderPaid     = "order.paid"
+	EventOrderShipped  = "order.shipped"
+	EventOrderRefunded = "order.refunded"
+)
+
+const orderStatusShipped = "shipped"
+
+var eventBus bus.Bus
+
+// events est l'outbox du service, publiée sur eventBus
+var events outbox.Outbox
+
+type orderEventData struct {
+	OrderID     uint    `json:"order_id"`
+	ProductID   string  `json:"product_id"`
+	ProductName string  `json:"product_name,omitempty"`
+	Quantity    int     `json:"quantity"`
+	Status      string  `json:"status"`
+	Amount      float64 `json:"amount,omitempty"`
+	Currency    string  `json:"currency,omitempty"`
+}
+
+func newEventID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// orderEvent construit l'événement ; amount est le montant concerné (encaissé ou remboursé)
+func orderEvent(eventType string, order Order, amount float64, productName string) outbox.Event {
+	return outbox.Event{
+		ID:            newEventID(),
+		Type:          eventType,
+		AggregateType: "order",
+		AggregateID:   fmt.Sprint(order.ID),
+		UserID:        order.UserID,
+		OccurredAt:    time.Now(),
+		Data: orderEventData{
+			OrderID:     order.ID,
+			ProductID:   order.ProductID,
+			ProductName: productName,
+			Quantity:    order.Quantity,
+			Status:      order.Status,
+			Amount:      amount,
+			Currency:    order.Currency,
+		},
+	}
+}
+
+// productName est lu avant d'ouvrir la transaction, l'événement n'en dépend pas pour être publié
+func productName(ctx context.Context, productID string) string {
+	product, err := fetchProduct(ctx, productID)
+	if err != nil {
+		slog.WarnContext(ctx, "failed to fetch product for event", "product_id", productID, "error", err)
+		return ""
+	}
+	return product.Name
+}
+
+// initEventBus ouvre le bus d'événements et déclare les sujets publiés ("order.*") et écoutés ("payment.*")
+func initEventBus() {
+	var err error
+	eventBus, err = bus.Open(cfg.EventBusURL, bus.Options{Subjects: []string{"order.>", "payment.>"}})
+	if err != nil {
+		logging.Fatal("failed to connect event bus", "error", err)
+	}
+	events = outbox.Outbox{DB: db, Table: "order_outbox", Publisher: eventBus}
+}
+
+// subscribePaymentEvents tient le statut de paiement des commandes à jour à partir des
+// événements "payment.*" publiés par payment-service
+func subscribePaymentEvents() {
+	if err := eventBus.Subscribe(context.Background(), "payment.>", "order-service", handlePaymentEvent); err != nil {
+		logging.Fatal("failed to subscribe to payment events", "error", err)
+	}
+}
+
+func handlePaymentEvent(ctx context.Context, msg bus.Message) error {
+	var event struct {
+		OccurredAt time.Time           `json:"occurred_at"`
+		Data       paymentStatusUpdate `json:"data"`
+	}
+	if err := json.Unmarshal(msg.Data, &event); err != nil {
+		slog.WarnContext(ctx, "dropping malformed payment event", "message_id", msg.ID, "error", err)
+		return nil
+	}
+	update := event.Data
+	update.OccurredAt = event.OccurredAt
+	err := applyPaymentStatus(ctx, update)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		slog.WarnContext(ctx, "ignoring payment event for unknown order", "message_id", msg.ID, "order_id", update.OrderID)
+		return nil
+	}
+	return err
+}
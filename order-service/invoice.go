@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"shared/tracing"
+	"shared/web"
+)
+
+const (
+	paymentStatusCaptured = "captured"
+	paymentStatusDeclined = "declined"
+	paymentStatusFailed   = "failed"
+	paymentStatusVoided   = "voided"
+)
+
+// catalogCurrency est la devise des prix de product-service
+const catalogCurrency = "EUR"
+
+// Taux de TVA par pays de facturation, DEFAULT_VAT_RATE s'applique aux autres
+var vatRates = map[string]float64{
+	"AT": 0.20, "BE": 0.21, "DE": 0.19, "ES": 0.21, "FR": 0.20,
+	"IE": 0.23, "IT": 0.22, "LU": 0.17, "NL": 0.21, "PT": 0.23,
+}
+
+// BillingAddress est l'adresse de facturation saisie lors de la commande
+type BillingAddress struct {
+	Name       string `json:"name" validate:"max=200"`
+	Line1      string `json:"line1" validate:"max=200"`
+	Line2      string `json:"line2" validate:"max=200"`
+	PostalCode string `json:"postal_code" validate:"max=20"`
+	City       string `json:"city" validate:"max=100"`
+	// Code pays ISO 3166-1 alpha-2, qui détermine le taux de TVA
+	Country string `json:"country" validate:"len=2"`
+}
+
+// Invoice est figée à l'émission : les documents HTML et PDF sont rendus une seule fois
+type Invoice struct {
+	ID             uint           `gorm:"primaryKey;autoIncrement" json:"id"`
+	Number         string         `gorm:"uniqueIndex" json:"number"`
+	OrderID        uint           `gorm:"uniqueIndex" json:"order_id"`
+	IssuedAt       time.Time      `json:"issued_at"`
+	Currency       string         `json:"currency"`
+	SellerVAT      string         `json:"seller_vat_number"`
+	Seller         BillingAddress `gorm:"embedded;embeddedPrefix:seller_" json:"seller"`
+	BillingAddress BillingAddress `gorm:"embedded;embeddedPrefix:billing_" json:"billing_address"`
+	Lines          []InvoiceLine  `gorm:"foreignKey:InvoiceID" json:"lines"`
+	TotalNet       float64        `json:"total_net"`
+	TotalTax       float64        `json:"total_tax"`
+	TotalGross     float64        `json:"total_gross"`
+	AmountPaid     float64        `json:"amount_paid"`
+	HTML           []byte         `json:"-"`
+	PDF            []byte         `json:"-"`
+}
+
+// InvoiceLine : les prix catalogue s'entendent TTC
+type InvoiceLine struct {
+	ID          uint    `gorm:"primaryKey;autoIncrement" json:"id"`
+	InvoiceID   uint    `gorm:"index" json:"invoice_id"`
+	Description string  `json:"description"`
+	Quantity    int     `json:"quantity"`
+	UnitPrice   float64 `json:"unit_price"`
+	TaxRate     float64 `json:"tax_rate"`
+	NetAmount   float64 `json:"net_amount"`
+	TaxAmount   float64 `json:"tax_amount"`
+	GrossAmount float64 `json:"gross_amount"`
+}
+
+// InvoiceSequence garantit une numérotation continue, sans trou, par année
+type InvoiceSequence struct {
+	Year       int `gorm:"primaryKey;autoIncrement:false"`
+	LastNumber int
+}
+
+type TaxSummary struct {
+	Rate      float64 `json:"rate"`
+	NetAmount float64 `json:"net_amount"`
+	TaxAmount float64 `json:"tax_amount"`
+}
+
+// TaxBreakdown regroupe les montants par taux de TVA
+func (invoice Invoice) TaxBreakdown() []TaxSummary {
+	var summaries []TaxSummary
+	for _, line := range invoice.Lines {
+		found := false
+		for i := range summaries {
+			if summaries[i].Rate == line.TaxRate {
+				summaries[i].NetAmount = roundAmount(summaries[i].NetAmount + line.NetAmount)
+				summaries[i].TaxAmount = roundAmount(summaries[i].TaxAmount + line.TaxAmount)
+				found = true
+			}
+		}
+		if !found {
+			summaries = append(summaries, TaxSummary{Rate: line.TaxRate, NetAmount: line.NetAmount, TaxAmount: line.TaxAmount})
+		}
+	}
+	return summaries
+}
+
+func invoiceHandler(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != "GET" {
+		web.Error(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+	var order Order
+	err := db.WithContext(r.Context()).First(&order, "id = ?", id).Error
+	if err != nil || !canAccessOrder(r.Context(), order) {
+		web.Error(w, http.StatusNotFound, "not_found", "Order not found")
+		return
+	}
+	invoice, err := ensureInvoice(r.Context(), order)
+	if errors.Is(err, errOrderNotPaid) {
+		web.Error(w, http.StatusConflict, "order_not_paid", err.Error())
+		return
+	}
+	if err != nil {
+		web.WriteError(w, err, "Invoice")
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" && strings.Contains(r.Header.Get("Accept"), "text/html") {
+		format = "html"
+	}
+	switch format {
+	case "", "pdf":
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.pdf"`, invoice.Number))
+		w.Write(invoice.PDF)
+	case "html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(invoice.HTML)
+	case "json":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(invoice)
+	default:
+		web.Error(w, http.StatusBadRequest, "unsupported_format", "Unsupported format")
+	}
+}
+
+var errOrderNotPaid = errors.New("order has not been paid")
+
+// ensureInvoice renvoie la facture de la commande, en l'émettant si elle n'existe pas encore
+func ensureInvoice(ctx context.Context, order Order) (Invoice, error) {
+	db := db.WithContext(ctx)
+	var invoice Invoice
+	err := db.Preload("Lines").First(&invoice, "order_id = ?", order.ID).Error
+	if err == nil {
+		return invoice, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return invoice, err
+	}
+	if order.PaymentStatus != paymentStatusCaptured || order.PaidAmount <= 0 {
+		return invoice, errOrderNotPaid
+	}
+
+	// Les commandes passées avant que le nom du produit soit figé le relisent au catalogue
+	name := order.ProductName
+	if name == "" {
+		product, err := fetchProduct(ctx, order.ProductID)
+		if err != nil {
+			return invoice, err
+		}
+		name = product.Name
+	}
+	line := invoiceLine(name, order.Quantity, order.PaidAmount, vatRate(order.BillingAddress.Country))
+
+	invoice = Invoice{
+		OrderID:        order.ID,
+		IssuedAt:       time.Now(),
+		Currency:       order.Currency,
+		SellerVAT:      cfg.SellerVATNumber,
+		Seller:         cfg.sellerAddress(),
+		BillingAddress: order.BillingAddress,
+		Lines:          []InvoiceLine{line},
+		TotalNet:       line.NetAmount,
+		TotalTax:       line.TaxAmount,
+		TotalGross:     line.GrossAmount,
+		AmountPaid:     order.PaidAmount,
+	}
+	if invoice.Currency == "" {
+		invoice.Currency = catalogCurrency
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		number, err := nextInvoiceNumber(tx, invoice.IssuedAt.Year())
+		if err != nil {
+			return err
+		}
+		invoice.Number = number
+		if invoice.HTML, err = renderInvoiceHTML(invoice); err != nil {
+			return err
+		}
+		invoice.PDF = renderInvoicePDF(invoice)
+		return tx.Create(&invoice).Error
+	})
+	if err != nil {
+		// Une autre requête a pu émettre la facture en parallèle
+		var existing Invoice
+		if db.Preload("Lines").First(&existing, "order_id = ?", order.ID).Error == nil {
+			return existing, nil
+		}
+		return invoice, err
+	}
+	return invoice, nil
+}
+
+// invoiceLine facture le montant encaissé par payment-service, TTC, et non le prix du
+// catalogue : c'est ce que le client a payé, y compris après une capture partielle
+func invoiceLine(description string, quantity int, paid float64, rate float64) InvoiceLine {
+	gross := roundAmount(paid)
+	net := roundAmount(gross / (1 + rate))
+	line := InvoiceLine{
+		Description: description,
+		Quantity:    quantity,
+		TaxRate:     rate,
+		NetAmount:   net,
+		TaxAmount:   roundAmount(gross - net),
+		GrossAmount: gross,
+	}
+	if quantity > 0 {
+		line.UnitPrice = roundAmount(gross / float64(quantity))
+	}
+	return line
+}
+
+// nextInvoiceNumber réserve le numéro suivant dans la transaction de création de la facture
+func nextInvoiceNumber(tx *gorm.DB, year int) (string, error) {
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&InvoiceSequence{Year: year}).Error; err != nil {
+		return "", err
+	}
+	var sequence InvoiceSequence
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&sequence, "year = ?", year).Error; err != nil {
+		return "", err
+	}
+	sequence.LastNumber++
+	if err := tx.Model(&sequence).Update("last_number", sequence.LastNumber).Error; err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("INV-%d-%06d", year, sequence.LastNumber), nil
+}
+
+type catalogProduct struct {
+	Name  string
+	Price float64
+}
+
+func fetchProduct(ctx context.Context, productID string) (catalogProduct, error) {
+	var product catalogProduct
+	client := tracing.Client(5 * time.Second)
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/internal/products/%s", cfg.ProductServiceURL, productID), nil)
+	if err != nil {
+		return product, err
+	}
+	req.Header.Set("X-Internal-Token", cfg.InternalAPIToken)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return product, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return product, fmt.Errorf("product-service returned status %d", resp.StatusCode)
+	}
+	err = json.NewDecoder(resp.Body).Decode(&product)
+	return product, err
+}
+
+func vatRate(country string) float64 {
+	if rate, ok := vatRates[strings.ToUpper(country)]; ok {
+		return rate
+	}
+	return cfg.DefaultVATRate
+}
+
+func roundAmount(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
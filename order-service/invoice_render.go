@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"strings"
+)
+
+var invoiceTemplate = template.Must(template.New("invoice").Funcs(template.FuncMap{
+	"money":   func(amount float64) string { return fmt.Sprintf("%.2f", amount) },
+	"percent": func(rate float64) string { return fmt.Sprintf("%.1f %%", rate*100) },
+}).Parse(`<!DOCTYPE html>
+<html lang="fr">
+<head>
+<meta charset="utf-8">
+<title>Facture {{.Number}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; width: 100%; margin-top: 1em; }
+th, td { border-bottom: 1px solid #ccc; padding: 0.4em; text-align: right; }
+th:first-child, td:first-child { text-align: left; }
+.parties { display: flex; justify-content: space-between; }
+</style>
+</head>
+<body>
+<h1>Facture {{.Number}}</h1>
+<p>Date : {{.IssuedAt.Format "02/01/2006"}} &mdash; Commande n° {{.OrderID}}</p>
+<div class="parties">
+<address>
+<strong>{{.Seller.Name}}</strong><br>
+{{.Seller.Line1}}<br>
+{{.Seller.PostalCode}} {{.Seller.City}} {{.Seller.Country}}<br>
+{{if .SellerVAT}}N° TVA : {{.SellerVAT}}{{end}}
+</address>
+<address>
+<strong>{{.BillingAddress.Name}}</strong><br>
+{{.BillingAddress.Line1}}<br>
+{{if .BillingAddress.Line2}}{{.BillingAddress.Line2}}<br>{{end}}
+{{.BillingAddress.PostalCode}} {{.BillingAddress.City}} {{.BillingAddress.Country}}
+</address>
+</div>
+<table>
+<tr><th>Désignation</th><th>Qté</th><th>Prix unitaire TTC</th><th>TVA</th><th>Total HT</th><th>Total TTC</th></tr>
+{{range .Lines}}<tr><td>{{.Description}}</td><td>{{.Quantity}}</td><td>{{money .UnitPrice}}</td><td>{{percent .TaxRate}}</td><td>{{money .NetAmount}}</td><td>{{money .GrossAmount}}</td></tr>
+{{end}}</table>
+<table>
+<tr><th>Taux de TVA</th><th>Base HT</th><th>Montant TVA</th></tr>
+{{range .TaxBreakdown}}<tr><td>{{percent .Rate}}</td><td>{{money .NetAmount}}</td><td>{{money .TaxAmount}}</td></tr>
+{{end}}</table>
+<table>
+<tr><td>Total HT</td><td>{{money .TotalNet}} {{.Currency}}</td></tr>
+<tr><td>Total TVA</td><td>{{money .TotalTax}} {{.Currency}}</td></tr>
+<tr><td><strong>Total TTC</strong></td><td><strong>{{money .TotalGross}} {{.Currency}}</strong></td></tr>
+<tr><td>Montant payé</td><td>{{money .AmountPaid}} {{.Currency}}</td></tr>
+</table>
+</body>
+</html>
+`))
+
+func renderInvoiceHTML(invoice Invoice) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := invoiceTemplate.Execute(&buf, invoice); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// renderInvoicePDF produit un PDF d'une page en Courier, sans dépendance externe
+func renderInvoicePDF(invoice Invoice) []byte {
+	lines := []string{
+		"FACTURE " + invoice.Number,
+		"",
+		fmt.Sprintf("Date : %s    Commande n° %d", invoice.IssuedAt.Format("02/01/2006"), invoice.OrderID),
+		"",
+		invoice.Seller.Name,
+		invoice.Seller.Line1,
+		strings.TrimSpace(invoice.Seller.PostalCode + " " + invoice.Seller.City + " " + invoice.Seller.Country),
+	}
+	if invoice.SellerVAT != "" {
+		lines = append(lines, "N° TVA : "+invoice.SellerVAT)
+	}
+	lines = append(lines, "", "Facturé à :", invoice.BillingAddress.Name, invoice.BillingAddress.Line1)
+	if invoice.BillingAddress.Line2 != "" {
+		lines = append(lines, invoice.BillingAddress.Line2)
+	}
+	lines = append(lines,
+		strings.TrimSpace(invoice.BillingAddress.PostalCode+" "+invoice.BillingAddress.City+" "+invoice.BillingAddress.Country),
+		"",
+		fmt.Sprintf("%-30s %5s %12s %7s %12s", "Désignation", "Qté", "P.U. TTC", "TVA", "Total TTC"),
+		strings.Repeat("-", 70),
+	)
+	for _, line := range invoice.Lines {
+		lines = append(lines, fmt.Sprintf("%-30.30s %5d %12.2f %6.1f%% %12.2f",
+			line.Description, line.Quantity, line.UnitPrice, line.TaxRate*100, line.GrossAmount))
+	}
+	lines = append(lines, strings.Repeat("-", 70), "")
+	for _, tax := range invoice.TaxBreakdown() {
+		lines = append(lines, fmt.Sprintf("TVA %.1f%% sur %.2f : %.2f", tax.Rate*100, tax.NetAmount, tax.TaxAmount))
+	}
+	lines = append(lines,
+		"",
+		fmt.Sprintf("%-20s %12.2f %s", "Total HT", invoice.TotalNet, invoice.Currency),
+		fmt.Sprintf("%-20s %12.2f %s", "Total TVA", invoice.TotalTax, invoice.Currency),
+		fmt.Sprintf("%-20s %12.2f %s", "Total TTC", invoice.TotalGross, invoice.Currency),
+		fmt.Sprintf("%-20s %12.2f %s", "Montant payé", invoice.AmountPaid, invoice.Currency),
+	)
+
+	var content bytes.Buffer
+	content.WriteString("BT\n/F1 10 Tf\n14 TL\n50 800 Td\n")
+	for _, line := range lines {
+		content.WriteString("(")
+		content.Write(pdfText(line))
+		content.WriteString(") '\n")
+	}
+	content.WriteString("ET\n")
+
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 595 842] /Resources << /Font << /F1 4 0 R >> >> /Contents 5 0 R >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()),
+	}
+
+	var pdf bytes.Buffer
+	pdf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = pdf.Len()
+		fmt.Fprintf(&pdf, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+	xref := pdf.Len()
+	fmt.Fprintf(&pdf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&pdf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&pdf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return pdf.Bytes()
+}
+
+// pdfText encode une chaîne en WinAnsi et échappe les caractères réservés du PDF
+func pdfText(s string) []byte {
+	var out []byte
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			out = append(out, '\\', byte(r))
+		case r == '€':
+			out = append(out, 0x80)
+		case r < 0x80 || (r >= 0xA0 && r <= 0xFF):
+			out = append(out, byte(r))
+		default:
+			out = append(out, '?')
+		}
+	}
+	return out
+}
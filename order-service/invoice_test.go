@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testDB remplace la base du service par une base SQLite en mémoire, propre au test
+func testDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name())
+	database, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := database.AutoMigrate(&Invoice{}, &InvoiceLine{}, &InvoiceSequence{}); err != nil {
+		t.Fatal(err)
+	}
+	previous := db
+	db = database
+	t.Cleanup(func() {
+		db = previous
+		if sqlDB, err := database.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return database
+}
+
+func TestInvoiceLine(t *testing.T) {
+	tests := []struct {
+		name     string
+		quantity int
+		paid     float64
+		rate     float64
+		want     InvoiceLine
+	}{
+		{
+			name:     "standard rate",
+			quantity: 2, paid: 24, rate: 0.20,
+			want: InvoiceLine{Quantity: 2, UnitPrice: 12, TaxRate: 0.20, NetAmount: 20, TaxAmount: 4, GrossAmount: 24},
+		},
+		{
+			name:     "net rounded to the cent, tax takes the remainder",
+			quantity: 1, paid: 9.99, rate: 0.20,
+			want: InvoiceLine{Quantity: 1, UnitPrice: 9.99, TaxRate: 0.20, NetAmount: 8.33, TaxAmount: 1.66, GrossAmount: 9.99},
+		},
+		{
+			name:     "unit price rounded",
+			quantity: 3, paid: 10, rate: 0.19,
+			want: InvoiceLine{Quantity: 3, UnitPrice: 3.33, TaxRate: 0.19, NetAmount: 8.4, TaxAmount: 1.6, GrossAmount: 10},
+		},
+		{
+			name:     "floating point noise",
+			quantity: 1, paid: 0.1 + 0.2, rate: 0.21,
+			want: InvoiceLine{Quantity: 1, UnitPrice: 0.3, TaxRate: 0.21, NetAmount: 0.25, TaxAmount: 0.05, GrossAmount: 0.3},
+		},
+		{
+			name:     "zero rate",
+			quantity: 4, paid: 100, rate: 0,
+			want: InvoiceLine{Quantity: 4, UnitPrice: 25, NetAmount: 100, GrossAmount: 100},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := invoiceLine("", tt.quantity, tt.paid, tt.rate)
+			if got != tt.want {
+				t.Errorf("invoiceLine() = %+v, want %+v", got, tt.want)
+			}
+			if sum := roundAmount(got.NetAmount + got.TaxAmount); sum != got.GrossAmount {
+				t.Errorf("net + tax = %v, gross %v", sum, got.GrossAmount)
+			}
+		})
+	}
+}
+
+func TestTaxBreakdown(t *testing.T) {
+	invoice := Invoice{Lines: []InvoiceLine{
+		{TaxRate: 0.20, NetAmount: 8.33, TaxAmount: 1.66},
+		{TaxRate: 0.055, NetAmount: 10, TaxAmount: 0.55},
+		{TaxRate: 0.20, NetAmount: 0.17, TaxAmount: 0.04},
+	}}
+	want := []TaxSummary{{Rate: 0.20, NetAmount: 8.5, TaxAmount: 1.7}, {Rate: 0.055, NetAmount: 10, TaxAmount: 0.55}}
+	if got := invoice.TaxBreakdown(); !reflect.DeepEqual(got, want) {
+		t.Errorf("TaxBreakdown() = %+v, want %+v", got, want)
+	}
+}
+
+// Les numéros se suivent par année, et une facture annulée ne consomme pas de numéro
+func TestNextInvoiceNumber(t *testing.T) {
+	database := testDB(t)
+	next := func(year int) string {
+		t.Helper()
+		var number string
+		err := database.Transaction(func(tx *gorm.DB) (err error) {
+			number, err = nextInvoiceNumber(tx, year)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return number
+	}
+
+	var got []string
+	got = append(got, next(2026), next(2026))
+	errRollback := errors.New("rollback")
+	err := database.Transaction(func(tx *gorm.DB) error {
+		if _, err := nextInvoiceNumber(tx, 2026); err != nil {
+			return err
+		}
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) {
+		t.Fatal(err)
+	}
+	got = append(got, next(2026), next(2027), next(2026))
+
+	want := []string{"INV-2026-000001", "INV-2026-000002", "INV-2026-000003", "INV-2027-000001", "INV-2026-000004"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("numbers = %v, want %v", got, want)
+	}
+}
+
+func TestEnsureInvoice(t *testing.T) {
+	testDB(t)
+	order := Order{
+		ID: 7, Quantity: 2, ProductName: "Keyboard", UnitPrice: 60, Currency: "EUR",
+		PaymentStatus: paymentStatusCaptured, PaidAmount: 100,
+		BillingAddress: BillingAddress{Country: "DE"},
+	}
+	invoice, err := ensureInvoice(context.Background(), order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// La facture porte sur les 100 encaissés, pas sur 2 × 60 au prix de la commande
+	if invoice.TotalGross != 100 || invoice.TotalNet != 84.03 || invoice.TotalTax != 15.97 || invoice.AmountPaid != 100 {
+		t.Errorf("totals = %v gross, %v net, %v tax, %v paid", invoice.TotalGross, invoice.TotalNet, invoice.TotalTax, invoice.AmountPaid)
+	}
+	if invoice.Number != fmt.Sprintf("INV-%d-000001", invoice.IssuedAt.Year()) || len(invoice.PDF) == 0 || len(invoice.HTML) == 0 {
+		t.Errorf("invoice %q not rendered", invoice.Number)
+	}
+
+	// La facture est émise une seule fois, même si la commande change ensuite
+	order.PaidAmount = 120
+	again, err := ensureInvoice(context.Background(), order)
+	if err != nil || again.ID != invoice.ID || again.TotalGross != 100 {
+		t.Errorf("second call = invoice %d (%v), %v, want invoice %d", again.ID, again.TotalGross, err, invoice.ID)
+	}
+
+	unpaid := order
+	unpaid.ID, unpaid.PaymentStatus = 8, "authorized"
+	if _, err := ensureInvoice(context.Background(), unpaid); !errors.Is(err, errOrderNotPaid) {
+		t.Errorf("unpaid order: error = %v, want %v", err, errOrderNotPaid)
+	}
+}
@@ -2,75 +2,76 @@ package main
 
 import (
 	"context"
+	"embed"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
-	"os"
 	"strings"
 	"time"
 
-	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
-)
-
-var (
-	productServiceURL = os.Getenv("PRODUCT_SERVICE_URL")
-	jwtKey            = []byte(os.Getenv("JWT_SECRET"))
+	"gorm.io/gorm/clause"
+	"shared/metrics"
+	"shared/service"
+	"shared/tracing"
+	"shared/web"
 )
 
 type Order struct {
 	ID        uint   `gorm:"primaryKey;autoIncrement" json:"id"`
-	UserID    string `json:"user_id"`
-	ProductID string `json:"product_id"`
-	Quantity  int    `json:"quantity"`
-	Status    string `json:"status"`
+	UserID    string `json:"user_id" validate:"max=64"`
+	ProductID string `json:"product_id" validate:"required,max=64"`
+	Quantity  int    `json:"quantity" validate:"required,min=1,max=1000"`
+	Status    string `json:"status" validate:"max=32"`
+	// Produit au moment de la commande, facturé à ce prix même si le catalogue change
+	ProductName string  `json:"product_name"`
+	UnitPrice   float64 `json:"unit_price"`
+	Currency    string  `json:"currency"`
+	// Statut du paiement associé, tenu à jour par payment-service
+	PaymentStatus  string         `json:"payment_status"`
+	PaidAmount     float64        `json:"paid_amount"`
+	RefundedAmount float64        `json:"refunded_amount"`
+	BillingAddress BillingAddress `gorm:"embedded;embeddedPrefix:billing_" json:"billing_address"`
+	// Date de l'état de paiement appliqué, pour écarter les événements en retard
+	PaymentUpdatedAt *time.Time `json:"-"`
 }
 
-var db *gorm.DB
-
-func initDB() {
-	dsn := "host=db user=user password=password dbname=microservices port=5432 sslmode=disable TimeZone=Asia/Shanghai"
-	var err error
-	db, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
-	if err != nil {
-		log.Fatalf("failed to connect database: %v", err)
-	}
-}
-
-func migrate() {
-	db.AutoMigrate(&Order{})
-}
+// Migrations SQL du schéma, appliquées par la sous-commande migrate
+//
+//go:embed migrations/*.sql
+var migrations embed.FS
 
-func ordersHandler(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case "GET":
-		getOrders(w)
-	case "POST":
-		createOrder(w, r)
-	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-	}
-}
+var db *gorm.DB
 
 func orderHandler(w http.ResponseWriter, r *http.Request) {
 	id := r.URL.Path[len("/orders/"):]
+	if orderID, found := strings.CutSuffix(id, "/invoice"); found {
+		invoiceHandler(w, r, orderID)
+		return
+	}
 	switch r.Method {
 	case "GET":
-		getOrder(w, id)
+		getOrder(w, r, id)
 	case "PUT":
 		updateOrder(w, r, id)
 	case "DELETE":
-		deleteOrder(w, id)
+		deleteOrder(w, r, id)
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		web.Error(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
 	}
 }
 
-func getOrders(w http.ResponseWriter) {
+func getOrders(w http.ResponseWriter, r *http.Request) {
+	query := db.WithContext(r.Context())
+	// Chacun ne voit que ses commandes, les administrateurs les voient toutes
+	if userID := web.UserID(r.Context()); !web.IsAdmin(cfg.AdminUserIDs, userID) {
+		query = query.Where("user_id = ?", userID)
+	}
 	var orders []Order
-	if err := db.Find(&orders).Error; err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := query.Find(&orders).Error; err != nil {
+		web.WriteError(w, err, "Order")
 		return
 	}
 	json.NewEncoder(w).Encode(orders)
@@ -79,35 +80,58 @@ func getOrders(w http.ResponseWriter) {
 func createOrder(w http.ResponseWriter, r *http.Request) {
 	token := r.Header.Get("Authorization")
 	if token == "" {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		web.Error(w, http.StatusUnauthorized, "unauthorized", "Unauthorized")
 		return
 	}
 	var order Order
-	if err := json.NewDecoder(r.Body).Decode(&order); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if err := web.DecodeJSON(w, r, &order); err != nil {
+		web.WriteError(w, err, "Order")
 		return
 	}
+	// La commande appartient à l'utilisateur authentifié, pas à celui indiqué dans le corps
+	order.UserID = web.UserID(r.Context())
+	// Le paiement est tenu par payment-service, quel que soit le corps reçu
+	order.PaymentStatus = ""
+	order.PaidAmount = 0
+	order.RefundedAmount = 0
+	order.PaymentUpdatedAt = nil
 
 	// Vérifier la disponibilité du produit
-	productAvailable := checkProductAvailability(order.ProductID, token)
+	productAvailable := checkProductAvailability(r.Context(), order.ProductID, token)
 	if !productAvailable {
-		http.Error(w, "Product not available", http.StatusBadRequest)
+		web.Error(w, http.StatusBadRequest, "product_unavailable", "Product not available")
 		return
 	}
 
-	if err := db.Create(&order).Error; err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	product, err := fetchProduct(r.Context(), order.ProductID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to fetch product price", "product_id", order.ProductID, "error", err)
+		web.Error(w, http.StatusBadGateway, "product_unavailable", "Could not read product price")
 		return
 	}
+	order.ProductName = product.Name
+	order.UnitPrice = product.Price
+	order.Currency = catalogCurrency
+	err = db.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&order).Error; err != nil {
+			return err
+		}
+		return events.Enqueue(tx, orderEvent(EventOrderPlaced, order, 0, product.Name))
+	})
+	if err != nil {
+		web.WriteError(w, err, "Order")
+		return
+	}
+	ordersCreated.Inc()
 	w.WriteHeader(http.StatusCreated)
 }
 
-func checkProductAvailability(productID string, token string) bool {
-	client := &http.Client{
-		Timeout: 5 * time.Second,
-	}
+func checkProductAvailability(ctx context.Context, productID string, token string) (available bool) {
+	start := time.Now()
+	defer func() { metrics.ObserveCall("check_product_availability", start, available) }()
+	client := tracing.Client(5 * time.Second)
 	for i := 0; i < 3; i++ {
-		req, err := http.NewRequest("GET", fmt.Sprintf("%s/products/%s", productServiceURL, productID), nil)
+		req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/products/%s", cfg.ProductServiceURL, productID), nil)
 		if err != nil {
 			continue
 		}
@@ -122,81 +146,205 @@ func checkProductAvailability(productID string, token string) bool {
 	return false
 }
 
-func getOrder(w http.ResponseWriter, id string) {
+func getOrder(w http.ResponseWriter, r *http.Request, id string) {
 	var order Order
-	if err := db.First(&order, "id = ?", id).Error; err != nil {
-		http.Error(w, "Order not found", http.StatusNotFound)
+	if err := db.WithContext(r.Context()).First(&order, "id = ?", id).Error; err != nil || !canAccessOrder(r.Context(), order) {
+		web.Error(w, http.StatusNotFound, "not_found", "Order not found")
 		return
 	}
 	json.NewEncoder(w).Encode(order)
 }
 
+// canAccessOrder réserve une commande à son propriétaire et aux administrateurs ; celle
+// d'un autre utilisateur est traitée comme inexistante
+func canAccessOrder(ctx context.Context, order Order) bool {
+	userID := web.UserID(ctx)
+	return userID != "" && (userID == order.UserID || web.IsAdmin(cfg.AdminUserIDs, userID))
+}
+
+// paymentStarted indique si un paiement est en cours ou abouti pour la commande ; après un
+// refus, un échec ou une annulation, la commande peut encore changer avant un nouveau paiement
+func paymentStarted(order Order) bool {
+	switch order.PaymentStatus {
+	case "", paymentStatusDeclined, paymentStatusFailed, paymentStatusVoided:
+		return false
+	}
+	return true
+}
+
+var errOrderLocked = web.NewError(http.StatusConflict, "order_locked", "Order has a payment and can no longer change")
+
+// checkOrderUpdate vérifie les changements demandés sur previous. Le produit ne change
+// jamais, son prix ayant été figé à la commande ; la quantité ne change plus une fois le
+// paiement engagé ; seuls les administrateurs font avancer le statut (expédition).
+func checkOrderUpdate(previous, update Order, admin bool) error {
+	if update.ProductID != "" && update.ProductID != previous.ProductID {
+		return web.ValidationError(web.FieldError{Field: "product_id", Code: "read_only", Message: "cannot be changed; place a new order"})
+	}
+	if update.Status != "" && update.Status != previous.Status && !admin {
+		return web.NewError(http.StatusForbidden, "forbidden", "Only administrators can change the order status")
+	}
+	if update.Quantity != 0 && update.Quantity != previous.Quantity && paymentStarted(previous) {
+		return errOrderLocked
+	}
+	return nil
+}
+
 func updateOrder(w http.ResponseWriter, r *http.Request, id string) {
 	var order Order
-	if err := json.NewDecoder(r.Body).Decode(&order); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if err := web.DecodeJSONPartial(w, r, &order); err != nil {
+		web.WriteError(w, err, "Order")
 		return
 	}
-	if err := db.Model(&Order{}).Where("id = ?", id).Updates(order).Error; err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	db := db.WithContext(r.Context())
+	admin := web.IsAdmin(cfg.AdminUserIDs, web.UserID(r.Context()))
+	var name string
+	if order.Status == orderStatusShipped {
+		var current Order
+		if err := db.First(&current, "id = ?", id).Error; err == nil {
+			name = productName(r.Context(), current.ProductID)
+		}
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var previous Order
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&previous, "id = ?", id).Error; err != nil {
+			return err
+		}
+		if !canAccessOrder(r.Context(), previous) {
+			return gorm.ErrRecordNotFound
+		}
+		if err := checkOrderUpdate(previous, order, admin); err != nil {
+			return err
+		}
+		// Le propriétaire, le produit et son prix ne changent pas, et le paiement n'est modifiable que par payment-service
+		if err := tx.Model(&Order{}).Where("id = ?", id).Omit("user_id", "product_id", "product_name", "unit_price", "payment_status", "paid_amount", "refunded_amount", "currency").Updates(order).Error; err != nil {
+			return err
+		}
+		if order.Status != orderStatusShipped || previous.Status == orderStatusShipped {
+			return nil
+		}
+		var updated Order
+		if err := tx.First(&updated, "id = ?", id).Error; err != nil {
+			return err
+		}
+		return events.Enqueue(tx, orderEvent(EventOrderShipped, updated, 0, name))
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		web.Error(w, http.StatusNotFound, "not_found", "Order not found")
+		return
+	}
+	if err != nil {
+		web.WriteError(w, err, "Order")
 		return
 	}
 	w.WriteHeader(http.StatusOK)
 }
 
-func deleteOrder(w http.ResponseWriter, id string) {
-	if err := db.Delete(&Order{}, "id = ?", id).Error; err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+// deleteOrder est idempotent ; une commande dont le paiement est engagé n'est plus supprimée
+func deleteOrder(w http.ResponseWriter, r *http.Request, id string) {
+	err := db.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		var order Order
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, "id = ?", id).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !canAccessOrder(r.Context(), order) {
+			return gorm.ErrRecordNotFound
+		}
+		if paymentStarted(order) {
+			return errOrderLocked
+		}
+		return tx.Delete(&order).Error
+	})
+	if err != nil {
+		web.WriteError(w, err, "Order")
 		return
 	}
 	w.WriteHeader(http.StatusOK)
 }
 
-func jwtMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		token := r.Header.Get("Authorization")
-		if token == "" {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
+// paymentStatusUpdate est l'état d'un paiement reçu de payment-service
+type paymentStatusUpdate struct {
+	OrderID        string    `json:"order_id"`
+	Status         string    `json:"status"`
+	CapturedAmount float64   `json:"captured_amount"`
+	RefundedAmount float64   `json:"refunded_amount"`
+	Currency       string    `json:"currency"`
+	OccurredAt     time.Time `json:"-"`
+}
 
-		resp, err := http.Post("http://auth-service:8080/verify-token", "application/json", strings.NewReader(fmt.Sprintf(`{"token":"%s"}`, token)))
-		if err != nil {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
+// applyPaymentStatus reporte le statut du paiement sur la commande ; un état plus ancien
+// que le dernier appliqué est ignoré, le bus pouvant remettre les messages dans le désordre
+func applyPaymentStatus(ctx context.Context, update paymentStatusUpdate) error {
+	db := db.WithContext(ctx)
+	id := update.OrderID
+	var current Order
+	if err := db.First(&current, "id = ?", id).Error; err != nil {
+		return err
+	}
+	name := productName(ctx, current.ProductID)
 
-		if resp.StatusCode != http.StatusOK {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
+	var order Order
+	paid := false
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var previous Order
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&previous, "id = ?", id).Error; err != nil {
+			return err
 		}
-
-		var result struct {
-			UserID string `json:"user_id"`
+		if previous.PaymentUpdatedAt != nil && update.OccurredAt.Before(*previous.PaymentUpdatedAt) {
+			return nil
+		}
+		if err := tx.Model(&Order{}).Where("id = ?", id).Updates(map[string]interface{}{
+			"payment_status":     update.Status,
+			"paid_amount":        update.CapturedAmount,
+			"refunded_amount":    update.RefundedAmount,
+			"currency":           update.Currency,
+			"payment_updated_at": update.OccurredAt,
+		}).Error; err != nil {
+			return err
 		}
-		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
+		if err := tx.First(&order, "id = ?", id).Error; err != nil {
+			return err
 		}
-		ctx := context.WithValue(r.Context(), "user_id", result.UserID)
-		next.ServeHTTP(w, r.WithContext(ctx))
+		if order.PaymentStatus == paymentStatusCaptured && previous.PaymentStatus != paymentStatusCaptured {
+			paid = true
+			if err := events.Enqueue(tx, orderEvent(EventOrderPaid, order, order.PaidAmount, name)); err != nil {
+				return err
+			}
+		}
+		if refunded := roundAmount(order.RefundedAmount - previous.RefundedAmount); refunded > 0 {
+			return events.Enqueue(tx, orderEvent(EventOrderRefunded, order, refunded, name))
+		}
+		return nil
 	})
-}
+	if err != nil {
+		return err
+	}
 
-func healthHandler(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("OK"))
+	// La facture est émise dès que le paiement est encaissé
+	if paid {
+		if _, err := ensureInvoice(ctx, order); err != nil {
+			slog.ErrorContext(ctx, "failed to issue invoice", "order_id", id, "error", err)
+		}
+	}
+	return nil
 }
 
 func main() {
-	initDB()
-	migrate()
-
-	mux := http.NewServeMux()
-	mux.Handle("/orders", jwtMiddleware(http.HandlerFunc(ordersHandler)))
-	mux.Handle("/orders/", jwtMiddleware(http.HandlerFunc(orderHandler)))
-	mux.HandleFunc("/health", healthHandler)
+	svc := service.New("Order Service", &cfg)
+	db = svc.DB
+	svc.DependsOn("product-service", cfg.ProductServiceURL)
+	svc.Migrate(migrations)
+	initEventBus()
+	subscribePaymentEvents()
+	svc.OnStop(func() { eventBus.Close() })
+	svc.Go(events.Run)
 
-	log.Println("Starting Order Service on :8083")
-	log.Fatal(http.ListenAndServe(":8083", mux))
+	orders := web.Methods{"GET": getOrders, "POST": createOrder}
+	svc.Router.Handle("/orders", orders, svc.RequireUser(), web.Idempotency(web.IdempotencyTable{DB: db, Name: "order_idempotency_keys"}))
+	svc.Router.HandleFunc("/orders/", orderHandler, svc.RequireUser())
+	svc.Run()
 }
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS order_idempotency_keys;
+DROP TABLE IF EXISTS order_outbox;
+DROP TABLE IF EXISTS invoice_sequences;
+DROP TABLE IF EXISTS invoice_lines;
+DROP TABLE IF EXISTS invoices;
+DROP TABLE IF EXISTS orders;
@@ -0,0 +1,95 @@
+-- Schéma créé jusqu'ici par AutoMigrate ; une base existante s'adopte avec
+-- "migrate baseline", qui vérifie qu'elle correspond à cette migration
+CREATE TABLE orders (
+    id bigserial PRIMARY KEY,
+    user_id text,
+    product_id text,
+    quantity bigint,
+    status text,
+    payment_status text,
+    paid_amount numeric,
+    refunded_amount numeric,
+    currency text,
+    billing_name text,
+    billing_line1 text,
+    billing_line2 text,
+    billing_postal_code text,
+    billing_city text,
+    billing_country text,
+    payment_updated_at timestamptz
+);
+
+CREATE TABLE invoices (
+    id bigserial PRIMARY KEY,
+    number text,
+    order_id bigint,
+    issued_at timestamptz,
+    currency text,
+    seller_vat text,
+    seller_name text,
+    seller_line1 text,
+    seller_line2 text,
+    seller_postal_code text,
+    seller_city text,
+    seller_country text,
+    billing_name text,
+    billing_line1 text,
+    billing_line2 text,
+    billing_postal_code text,
+    billing_city text,
+    billing_country text,
+    total_net numeric,
+    total_tax numeric,
+    total_gross numeric,
+    amount_paid numeric,
+    html bytea,
+    pdf bytea
+);
+CREATE UNIQUE INDEX idx_invoices_number ON invoices (number);
+CREATE UNIQUE INDEX idx_invoices_order_id ON invoices (order_id);
+
+CREATE TABLE invoice_lines (
+    id bigserial PRIMARY KEY,
+    invoice_id bigint CONSTRAINT fk_invoices_lines REFERENCES invoices (id),
+    description text,
+    quantity bigint,
+    unit_price numeric,
+    tax_rate numeric,
+    net_amount numeric,
+    tax_amount numeric,
+    gross_amount numeric
+);
+CREATE INDEX idx_invoice_lines_invoice_id ON invoice_lines (invoice_id);
+
+CREATE TABLE invoice_sequences (
+    year bigint PRIMARY KEY,
+    last_number bigint
+);
+
+CREATE TABLE order_outbox (
+    id bigserial PRIMARY KEY,
+    event_id text,
+    event_type text,
+    aggregate_type text,
+    aggregate_id text,
+    payload bytea,
+    created_at timestamptz,
+    published_at timestamptz,
+    attempts bigint,
+    last_error text,
+    next_attempt_at timestamptz
+);
+CREATE UNIQUE INDEX idx_order_outbox_event_id ON order_outbox (event_id);
+CREATE INDEX idx_order_outbox_published_at ON order_outbox (published_at);
+CREATE INDEX idx_order_outbox_aggregate ON order_outbox (aggregate_type, aggregate_id);
+
+CREATE TABLE order_idempotency_keys (
+    idempotency_key text,
+    user_id text,
+    request_hash text,
+    status_code bigint,
+    content_type text,
+    body bytea,
+    created_at timestamptz,
+    PRIMARY KEY (idempotency_key, user_id)
+);
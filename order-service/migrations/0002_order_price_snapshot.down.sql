@@ -0,0 +1,2 @@
+ALTER TABLE orders DROP COLUMN IF EXISTS unit_price;
+ALTER TABLE orders DROP COLUMN IF EXISTS product_name;
@@ -0,0 +1,4 @@
+-- Le prix et le nom du produit sont figés à la commande : la facture ne dépend plus du
+-- catalogue au moment du paiement. Les commandes antérieures restent à NULL.
+ALTER TABLE orders ADD COLUMN product_name text;
+ALTER TABLE orders ADD COLUMN unit_price numeric;
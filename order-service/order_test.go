@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"shared/web"
+)
+
+func TestCanAccessOrder(t *testing.T) {
+	cfg.AdminUserIDs = []string{"admin"}
+	t.Cleanup(func() { cfg.AdminUserIDs = nil })
+	order := Order{ID: 1, UserID: "owner"}
+	tests := []struct {
+		userID string
+		want   bool
+	}{
+		{userID: "owner", want: true},
+		{userID: "admin", want: true},
+		{userID: "other"},
+		{userID: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.userID, func(t *testing.T) {
+			ctx := web.WithUserID(context.Background(), tt.userID)
+			if got := canAccessOrder(ctx, order); got != tt.want {
+				t.Errorf("canAccessOrder() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckOrderUpdate(t *testing.T) {
+	unpaid := Order{ProductID: "3", Quantity: 2, Status: "pending"}
+	authorized := unpaid
+	authorized.PaymentStatus = "authorized"
+	captured := unpaid
+	captured.PaymentStatus = paymentStatusCaptured
+	declined := unpaid
+	declined.PaymentStatus = paymentStatusDeclined
+
+	tests := []struct {
+		name       string
+		previous   Order
+		update     Order
+		admin      bool
+		wantStatus int
+	}{
+		{name: "quantity before payment", previous: unpaid, update: Order{Quantity: 5}},
+		{name: "quantity after a declined payment", previous: declined, update: Order{Quantity: 5}},
+		{name: "quantity once authorized", previous: authorized, update: Order{Quantity: 5}, wantStatus: http.StatusConflict},
+		{name: "quantity once captured", previous: captured, update: Order{Quantity: 5}, wantStatus: http.StatusConflict},
+		{name: "quantity once captured, even for admins", previous: captured, update: Order{Quantity: 5}, admin: true, wantStatus: http.StatusConflict},
+		{name: "same quantity once captured", previous: captured, update: Order{Quantity: 2}},
+		{name: "billing address once captured", previous: captured, update: Order{BillingAddress: BillingAddress{City: "Lyon"}}},
+		{name: "other product", previous: unpaid, update: Order{ProductID: "4"}, wantStatus: http.StatusUnprocessableEntity},
+		{name: "same product", previous: captured, update: Order{ProductID: "3"}},
+		{name: "status by the owner", previous: authorized, update: Order{Status: orderStatusShipped}, wantStatus: http.StatusForbidden},
+		{name: "same status by the owner", previous: authorized, update: Order{Status: "pending"}},
+		{name: "status by an admin", previous: authorized, update: Order{Status: orderStatusShipped}, admin: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkOrderUpdate(tt.previous, tt.update, tt.admin)
+			if tt.wantStatus == 0 {
+				if err != nil {
+					t.Fatalf("checkOrderUpdate() error = %v", err)
+				}
+				return
+			}
+			var statusErr *web.StatusError
+			if !errors.As(err, &statusErr) || statusErr.Status != tt.wantStatus {
+				t.Errorf("checkOrderUpdate() error = %v, want status %d", err, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"shared/web"
+)
+
+func TestPaymentAccess(t *testing.T) {
+	cfg.AdminUserIDs = []string{"admin"}
+	t.Cleanup(func() { cfg.AdminUserIDs = nil })
+	payment := Payment{ID: 1, UserID: "owner"}
+	tests := []struct {
+		name        string
+		userID      string
+		internal    bool
+		wantRead    bool
+		wantOperate bool
+	}{
+		{name: "owner reads only", userID: "owner", wantRead: true},
+		{name: "other user", userID: "other"},
+		{name: "anonymous", userID: ""},
+		{name: "admin", userID: "admin", wantRead: true, wantOperate: true},
+		{name: "internal service", internal: true, wantRead: true, wantOperate: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := web.WithUserID(context.Background(), tt.userID)
+			if tt.internal {
+				ctx = internalContext(t)
+			}
+			if got := canAccessPayment(ctx, payment); got != tt.wantRead {
+				t.Errorf("canAccessPayment() = %v, want %v", got, tt.wantRead)
+			}
+			if got := canOperatePayment(ctx); got != tt.wantOperate {
+				t.Errorf("canOperatePayment() = %v, want %v", got, tt.wantOperate)
+			}
+		})
+	}
+}
+
+// Le propriétaire est refusé avant tout accès à la base
+func TestPaymentActionsForbiddenToOwner(t *testing.T) {
+	for _, action := range []string{"capture", "void", "refunds"} {
+		t.Run(action, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/payments/1/"+action, nil)
+			req = req.WithContext(web.WithUserID(req.Context(), "owner"))
+			rec := httptest.NewRecorder()
+			paymentActionHandler(rec, req, "1", action)
+			if rec.Code != http.StatusForbidden {
+				t.Errorf("status = %d, want 403", rec.Code)
+			}
+		})
+	}
+}
+
+// internalContext renvoie le contexte d'une requête authentifiée par le jeton interne
+func internalContext(t *testing.T) context.Context {
+	t.Helper()
+	var ctx context.Context
+	handler := web.RequireInternalToken("token")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx = r.Context()
+	}))
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Internal-Token", "token")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if ctx == nil {
+		t.Fatal("internal token rejected")
+	}
+	return ctx
+}
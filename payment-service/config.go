@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"shared/config"
+)
+
+// Config est lue au démarrage depuis l'environnement, voir le paquet shared/config
+type Config struct {
+	config.Server
+	config.Database
+	config.Auth
+	OrderServiceURL string `env:"ORDER_SERVICE_URL" required:"true"`
+	config.Internal
+	// Vide ou "memory://" : bus en mémoire, limité au processus
+	EventBusURL string `env:"EVENT_BUS_URL"`
+
+	PaymentProvider     string `env:"PAYMENT_PROVIDER" default:"fake"`
+	StripeSecretKey     string `env:"STRIPE_SECRET_KEY" secret:"true"`
+	StripeAPIURL        string `env:"STRIPE_API_URL" default:"https://api.stripe.com"`
+	WebhookSecretFake   string `env:"WEBHOOK_SECRET_FAKE" secret:"true"`
+	WebhookSecretStripe string `env:"WEBHOOK_SECRET_STRIPE" secret:"true"`
+	// Utilisateurs autorisés sur les routes /admin et /ledger
+	config.Admin
+}
+
+var cfg = Config{Server: config.Server{Port: 8084}}
+
+func (c Config) Validate() error {
+	switch c.PaymentProvider {
+	case "fake":
+	case "stripe":
+		if c.StripeSecretKey == "" {
+			return errors.New("STRIPE_SECRET_KEY is required when PAYMENT_PROVIDER=stripe")
+		}
+	default:
+		return fmt.Errorf("unknown payment provider %q", c.PaymentProvider)
+	}
+	return nil
+}
+
+// webhookSecret renvoie le secret de signature des webhooks du prestataire, vide s'il n'est pas configuré
+func (c Config) webhookSecret(provider string) string {
+	switch provider {
+	case "fake":
+		return c.WebhookSecretFake
+	case "stripe":
+		return c.WebhookSecretStripe
+	}
+	return ""
+}
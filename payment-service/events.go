@@ -0,0 +1,74 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"shared/bus"
+	"shared/logging"
+	"shared/outbox"
+)
+
+var eventBus bus.Bus
+
+// events est l'outbox du service, publiée sur eventBus
+var events outbox.Outbox
+
+type paymentEventData struct {
+	PaymentID      uint    `json:"payment_id"`
+	OrderID        string  `json:"order_id"`
+	Status         string  `json:"status"`
+	Amount         float64 `json:"amount"`
+	CapturedAmount float64 `json:"captured_amount"`
+	RefundedAmount float64 `json:"refunded_amount"`
+	Currency       string  `json:"currency"`
+}
+
+func newEventID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// paymentEvent décrit le nouveau statut du paiement, publié sous le type "payment.<statut>"
+func paymentEvent(payment Payment) outbox.Event {
+	return outbox.Event{
+		ID:            newEventID(),
+		Type:          "payment." + payment.Status,
+		AggregateType: "payment",
+		AggregateID:   fmt.Sprint(payment.ID),
+		UserID:        payment.UserID,
+		OccurredAt:    time.Now(),
+		Data: paymentEventData{
+			PaymentID:      payment.ID,
+			OrderID:        payment.OrderID,
+			Status:         payment.Status,
+			Amount:         payment.Amount,
+			CapturedAmount: payment.CapturedAmount,
+			RefundedAmount: payment.RefundedAmount,
+			Currency:       payment.Currency,
+		},
+	}
+}
+
+// enqueuePaymentEvent relit le paiement dans la transaction pour publier son état final
+func enqueuePaymentEvent(tx *gorm.DB, id uint) error {
+	var payment Payment
+	if err := tx.First(&payment, id).Error; err != nil {
+		return err
+	}
+	return events.Enqueue(tx, paymentEvent(payment))
+}
+
+// initEventBus ouvre le bus d'événements ; payment-service déclare les sujets "payment.*" qu'il publie
+func initEventBus() {
+	var err error
+	eventBus, err = bus.Open(cfg.EventBusURL, bus.Options{Subjects: []string{"payment.>"}})
+	if err != nil {
+		logging.Fatal("failed to connect event bus", "error", err)
+	}
+	events = outbox.Outbox{DB: db, Table: "payment_outbox", Publisher: eventBus}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"shared/web"
+)
+
+// Comptes du grand livre
+const (
+	AccountCustomer = "customer"
+	AccountMerchant = "merchant"
+	AccountFees     = "fees"
+	AccountRefunds  = "refunds"
+)
+
+// Types d'écritures
+const (
+	LedgerEventCapture        = "capture"
+	LedgerEventRefund         = "refund"
+	LedgerEventRefundReversal = "refund_reversal"
+	LedgerEventChargeback     = "chargeback"
+)
+
+var ledgerAccounts = []string{AccountCustomer, AccountMerchant, AccountFees, AccountRefunds}
+
+var ErrUnbalancedEntry = errors.New("journal entry is not balanced")
+
+// JournalEntry est une écriture comptable ; le grand livre est en ajout seul
+type JournalEntry struct {
+	ID        uint          `gorm:"primaryKey;autoIncrement" json:"id"`
+	PaymentID uint          `gorm:"index" json:"payment_id"`
+	EventType string        `json:"event_type"`
+	Reference string        `json:"reference"`
+	Currency  string        `json:"currency"`
+	CreatedAt time.Time     `json:"created_at"`
+	Lines     []JournalLine `gorm:"foreignKey:EntryID" json:"lines"`
+}
+
+// JournalLine débite ou crédite un compte, les montants sont en centimes
+type JournalLine struct {
+	ID      uint   `gorm:"primaryKey;autoIncrement" json:"id"`
+	EntryID uint   `gorm:"index" json:"entry_id"`
+	Account string `gorm:"index" json:"account"`
+	Debit   int64  `json:"debit"`
+	Credit  int64  `json:"credit"`
+}
+
+type AccountBalance struct {
+	Account string `json:"account"`
+	Debit   int64  `json:"debit"`
+	Credit  int64  `json:"credit"`
+	Balance int64  `json:"balance"`
+}
+
+type LedgerCheck struct {
+	Consistent  bool     `json:"consistent"`
+	TotalDebit  int64    `json:"total_debit"`
+	TotalCredit int64    `json:"total_credit"`
+	Issues      []string `json:"issues"`
+}
+
+func postJournalEntry(tx *gorm.DB, payment Payment, eventType string, reference string, lines ...JournalLine) error {
+	var debit, credit int64
+	filtered := lines[:0]
+	for _, line := range lines {
+		if line.Debit == 0 && line.Credit == 0 {
+			continue
+		}
+		debit += line.Debit
+		credit += line.Credit
+		filtered = append(filtered, line)
+	}
+	if len(filtered) == 0 {
+		return nil
+	}
+	if debit != credit {
+		return fmt.Errorf("%w: %s on payment %d debits %d, credits %d", ErrUnbalancedEntry, eventType, payment.ID, debit, credit)
+	}
+	entry := JournalEntry{
+		PaymentID: payment.ID,
+		EventType: eventType,
+		Reference: reference,
+		Currency:  payment.Currency,
+		Lines:     filtered,
+	}
+	return tx.Create(&entry).Error
+}
+
+// Encaissement : le client paie le montant, le prestataire garde ses frais, le reste revient au marchand
+func postCaptureEntry(tx *gorm.DB, payment Payment, reference string, amount float64, fee float64) error {
+	gross, fees := toMinorUnits(amount), toMinorUnits(fee)
+	return postJournalEntry(tx, payment, LedgerEventCapture, reference,
+		JournalLine{Account: AccountMerchant, Debit: gross - fees},
+		JournalLine{Account: AccountFees, Debit: fees},
+		JournalLine{Account: AccountCustomer, Credit: gross},
+	)
+}
+
+func postRefundEntry(tx *gorm.DB, payment Payment, reference string, amount float64) error {
+	cents := toMinorUnits(amount)
+	return postJournalEntry(tx, payment, LedgerEventRefund, reference,
+		JournalLine{Account: AccountRefunds, Debit: cents},
+		JournalLine{Account: AccountMerchant, Credit: cents},
+	)
+}
+
+func postRefundReversalEntry(tx *gorm.DB, payment Payment, reference string, amount float64) error {
+	cents := toMinorUnits(amount)
+	return postJournalEntry(tx, payment, LedgerEventRefundReversal, reference,
+		JournalLine{Account: AccountMerchant, Debit: cents},
+		JournalLine{Account: AccountRefunds, Credit: cents},
+	)
+}
+
+func postChargebackEntry(tx *gorm.DB, payment Payment, reference string, amount float64) error {
+	cents := toMinorUnits(amount)
+	return postJournalEntry(tx, payment, LedgerEventChargeback, reference,
+		JournalLine{Account: AccountRefunds, Debit: cents},
+		JournalLine{Account: AccountMerchant, Credit: cents},
+	)
+}
+
+func ledgerHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		web.Error(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+	path := r.URL.Path[len("/ledger/"):]
+	switch {
+	case path == "entries":
+		getJournalEntries(w, r)
+	case path == "accounts":
+		getAccountBalances(w, "")
+	case strings.HasPrefix(path, "accounts/"):
+		getAccountBalances(w, strings.TrimPrefix(path, "accounts/"))
+	case path == "check":
+		checkLedger(w)
+	default:
+		web.Error(w, http.StatusNotFound, "not_found", "Not found")
+	}
+}
+
+func getJournalEntries(w http.ResponseWriter, r *http.Request) {
+	query := db.Preload("Lines").Order("id")
+	if paymentID := r.URL.Query().Get("payment_id"); paymentID != "" {
+		query = query.Where("payment_id = ?", paymentID)
+	}
+	var entries []JournalEntry
+	if err := query.Find(&entries).Error; err != nil {
+		web.WriteError(w, err, "Ledger entry")
+		return
+	}
+	json.NewEncoder(w).Encode(entries)
+}
+
+func getAccountBalances(w http.ResponseWriter, account string) {
+	balances := make(map[string]*AccountBalance)
+	for _, name := range ledgerAccounts {
+		balances[name] = &AccountBalance{Account: name}
+	}
+	if account != "" && balances[account] == nil {
+		web.Error(w, http.StatusNotFound, "not_found", "Account not found")
+		return
+	}
+
+	var rows []AccountBalance
+	if err := db.Model(&JournalLine{}).
+		Select("account, SUM(debit) AS debit, SUM(credit) AS credit").
+		Group("account").
+		Scan(&rows).Error; err != nil {
+		web.WriteError(w, err, "Ledger entry")
+		return
+	}
+	for i := range rows {
+		rows[i].Balance = rows[i].Debit - rows[i].Credit
+		balances[rows[i].Account] = &rows[i]
+	}
+
+	if account != "" {
+		json.NewEncoder(w).Encode(balances[account])
+		return
+	}
+	result := make([]AccountBalance, 0, len(ledgerAccounts))
+	for _, name := range ledgerAccounts {
+		result = append(result, *balances[name])
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
+// checkLedger vérifie l'équilibre de chaque écriture et la concordance avec les paiements
+func checkLedger(w http.ResponseWriter) {
+	check := LedgerCheck{Issues: []string{}}
+
+	var totals struct {
+		Debit  int64
+		Credit int64
+	}
+	if err := db.Model(&JournalLine{}).Select("COALESCE(SUM(debit), 0) AS debit, COALESCE(SUM(credit), 0) AS credit").Scan(&totals).Error; err != nil {
+		web.WriteError(w, err, "Ledger entry")
+		return
+	}
+	check.TotalDebit, check.TotalCredit = totals.Debit, totals.Credit
+	if totals.Debit != totals.Credit {
+		check.Issues = append(check.Issues, fmt.Sprintf("ledger total debits %d differ from credits %d", totals.Debit, totals.Credit))
+	}
+
+	var unbalanced []struct {
+		EntryID uint
+		Debit   int64
+		Credit  int64
+	}
+	if err := db.Raw(`SELECT e.id AS entry_id, COALESCE(SUM(l.debit), 0) AS debit, COALESCE(SUM(l.credit), 0) AS credit
+		FROM journal_entries e LEFT JOIN journal_lines l ON l.entry_id = e.id
+		GROUP BY e.id
+		HAVING COALESCE(SUM(l.debit), 0) <> COALESCE(SUM(l.credit), 0) OR COUNT(l.id) = 0`).
+		Scan(&unbalanced).Error; err != nil {
+		web.WriteError(w, err, "Ledger entry")
+		return
+	}
+	for _, entry := range unbalanced {
+		check.Issues = append(check.Issues, fmt.Sprintf("entry %d is unbalanced: debits %d, credits %d", entry.EntryID, entry.Debit, entry.Credit))
+	}
+
+	var mismatches []struct {
+		ID             uint
+		CapturedAmount float64
+		RefundedAmount float64
+		LedgerCaptured int64
+		LedgerRefunded int64
+	}
+	if err := db.Raw(`SELECT p.id, p.captured_amount, p.refunded_amount,
+			COALESCE(SUM(CASE WHEN l.account = ? AND e.event_type = ? THEN l.credit ELSE 0 END), 0) AS ledger_captured,
+			COALESCE(SUM(CASE WHEN l.account = ? AND e.event_type IN (?, ?) THEN l.debit - l.credit ELSE 0 END), 0) AS ledger_refunded
+		FROM payments p
+		LEFT JOIN journal_entries e ON e.payment_id = p.id
+		LEFT JOIN journal_lines l ON l.entry_id = e.id
+		GROUP BY p.id, p.captured_amount, p.refunded_amount
+		HAVING COALESCE(SUM(CASE WHEN l.account = ? AND e.event_type = ? THEN l.credit ELSE 0 END), 0) <> ROUND(p.captured_amount * 100)
+			OR COALESCE(SUM(CASE WHEN l.account = ? AND e.event_type IN (?, ?) THEN l.debit - l.credit ELSE 0 END), 0) <> ROUND(p.refunded_amount * 100)`,
+		AccountCustomer, LedgerEventCapture, AccountRefunds, LedgerEventRefund, LedgerEventRefundReversal,
+		AccountCustomer, LedgerEventCapture, AccountRefunds, LedgerEventRefund, LedgerEventRefundReversal).
+		Scan(&mismatches).Error; err != nil {
+		web.WriteError(w, err, "Ledger entry")
+		return
+	}
+	for _, m := range mismatches {
+		check.Issues = append(check.Issues, fmt.Sprintf(
+			"payment %d does not match ledger: captured %d/%d, refunded %d/%d",
+			m.ID, toMinorUnits(m.CapturedAmount), m.LedgerCaptured, toMinorUnits(m.RefundedAmount), m.LedgerRefunded))
+	}
+
+	check.Consistent = len(check.Issues) == 0
+	if !check.Consistent {
+		slog.Warn("ledger check found issues", "issues", len(check.Issues))
+	}
+	json.NewEncoder(w).Encode(check)
+}
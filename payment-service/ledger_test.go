@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// postedEntries relit les écritures enregistrées avec leurs lignes, dans l'ordre
+func postedEntries(t *testing.T, database *gorm.DB) []JournalEntry {
+	t.Helper()
+	var entries []JournalEntry
+	if err := database.Preload("Lines", func(tx *gorm.DB) *gorm.DB { return tx.Order("id") }).Order("id").Find(&entries).Error; err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+type ledgerLine struct {
+	account string
+	debit   int64
+	credit  int64
+}
+
+func TestPostJournalEntry(t *testing.T) {
+	payment := Payment{ID: 7, Currency: "EUR"}
+	tests := []struct {
+		name      string
+		lines     []JournalLine
+		wantLines []ledgerLine
+		wantErr   error
+	}{
+		{
+			name: "balanced entry",
+			lines: []JournalLine{
+				{Account: AccountMerchant, Debit: 970},
+				{Account: AccountFees, Debit: 30},
+				{Account: AccountCustomer, Credit: 1000},
+			},
+			wantLines: []ledgerLine{{AccountMerchant, 970, 0}, {AccountFees, 30, 0}, {AccountCustomer, 0, 1000}},
+		},
+		{
+			name: "empty lines are dropped",
+			lines: []JournalLine{
+				{Account: AccountMerchant, Debit: 1000},
+				{Account: AccountFees},
+				{Account: AccountCustomer, Credit: 1000},
+			},
+			wantLines: []ledgerLine{{AccountMerchant, 1000, 0}, {AccountCustomer, 0, 1000}},
+		},
+		{
+			name:  "nothing to post",
+			lines: []JournalLine{{Account: AccountRefunds}, {Account: AccountMerchant}},
+		},
+		{
+			name: "unbalanced entry",
+			lines: []JournalLine{
+				{Account: AccountMerchant, Debit: 1000},
+				{Account: AccountCustomer, Credit: 999},
+			},
+			wantErr: ErrUnbalancedEntry,
+		},
+		{
+			name:    "debit without credit",
+			lines:   []JournalLine{{Account: AccountRefunds, Debit: 500}},
+			wantErr: ErrUnbalancedEntry,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			database := testDB(t)
+			err := postJournalEntry(database, payment, LedgerEventCapture, "ref_1", tt.lines...)
+			entries := postedEntries(t, database)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("postJournalEntry() error = %v, want %v", err, tt.wantErr)
+				}
+				if len(entries) != 0 {
+					t.Errorf("%d entries posted, want none", len(entries))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("postJournalEntry() error = %v", err)
+			}
+			if tt.wantLines == nil {
+				if len(entries) != 0 {
+					t.Errorf("%d entries posted, want none", len(entries))
+				}
+				return
+			}
+			if len(entries) != 1 {
+				t.Fatalf("%d entries posted, want 1", len(entries))
+			}
+			entry := entries[0]
+			if entry.PaymentID != payment.ID || entry.EventType != LedgerEventCapture || entry.Reference != "ref_1" || entry.Currency != "EUR" {
+				t.Errorf("entry = %+v", entry)
+			}
+			if got := entryLines(entry); !reflect.DeepEqual(got, tt.wantLines) {
+				t.Errorf("lines = %v, want %v", got, tt.wantLines)
+			}
+		})
+	}
+}
+
+func TestPostedEntriesBalance(t *testing.T) {
+	payment := Payment{ID: 7, Currency: "EUR"}
+	tests := []struct {
+		name      string
+		post      func(tx *gorm.DB) error
+		wantLines []ledgerLine
+	}{
+		{
+			name: "capture with fee",
+			post: func(tx *gorm.DB) error { return postCaptureEntry(tx, payment, "cap_1", 100.10, 2.93) },
+			wantLines: []ledgerLine{
+				{AccountMerchant, 9717, 0}, {AccountFees, 293, 0}, {AccountCustomer, 0, 10010},
+			},
+		},
+		{
+			name:      "capture without fee",
+			post:      func(tx *gorm.DB) error { return postCaptureEntry(tx, payment, "cap_1", 19.99, 0) },
+			wantLines: []ledgerLine{{AccountMerchant, 1999, 0}, {AccountCustomer, 0, 1999}},
+		},
+		{
+			name:      "amounts are rounded to the cent",
+			post:      func(tx *gorm.DB) error { return postCaptureEntry(tx, payment, "cap_1", 0.1+0.2, 0) },
+			wantLines: []ledgerLine{{AccountMerchant, 30, 0}, {AccountCustomer, 0, 30}},
+		},
+		{
+			name:      "refund",
+			post:      func(tx *gorm.DB) error { return postRefundEntry(tx, payment, "re_1", 25) },
+			wantLines: []ledgerLine{{AccountRefunds, 2500, 0}, {AccountMerchant, 0, 2500}},
+		},
+		{
+			name:      "refund reversal",
+			post:      func(tx *gorm.DB) error { return postRefundReversalEntry(tx, payment, "re_1", 25) },
+			wantLines: []ledgerLine{{AccountMerchant, 2500, 0}, {AccountRefunds, 0, 2500}},
+		},
+		{
+			name:      "chargeback",
+			post:      func(tx *gorm.DB) error { return postChargebackEntry(tx, payment, "dp_1", 40) },
+			wantLines: []ledgerLine{{AccountRefunds, 4000, 0}, {AccountMerchant, 0, 4000}},
+		},
+		{
+			name: "zero refund posts nothing",
+			post: func(tx *gorm.DB) error { return postRefundEntry(tx, payment, "re_1", 0) },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			database := testDB(t)
+			if err := tt.post(database); err != nil {
+				t.Fatalf("error = %v", err)
+			}
+			var got []ledgerLine
+			for _, entry := range postedEntries(t, database) {
+				got = append(got, entryLines(entry)...)
+			}
+			if !reflect.DeepEqual(got, tt.wantLines) {
+				t.Errorf("lines = %v, want %v", got, tt.wantLines)
+			}
+			var debit, credit int64
+			for _, line := range got {
+				debit, credit = debit+line.debit, credit+line.credit
+			}
+			if debit != credit {
+				t.Errorf("debits %d, credits %d", debit, credit)
+			}
+		})
+	}
+}
+
+func entryLines(entry JournalEntry) []ledgerLine {
+	var lines []ledgerLine
+	for _, line := range entry.Lines {
+		lines = append(lines, ledgerLine{account: line.Account, debit: line.Debit, credit: line.Credit})
+	}
+	return lines
+}
+
+func TestCheckLedger(t *testing.T) {
+	tests := []struct {
+		name       string
+		setup      func(t *testing.T, database *gorm.DB, payment Payment)
+		captured   float64
+		wantIssues int
+	}{
+		{
+			name:     "capture and partial refund",
+			captured: 100,
+			setup: func(t *testing.T, database *gorm.DB, payment Payment) {
+				mustPost(t, postCaptureEntry(database, payment, "cap_1", 100, 1.65))
+				mustPost(t, postRefundEntry(database, payment, "re_1", 30))
+				database.Model(&payment).Update("refunded_amount", 30)
+			},
+		},
+		{
+			name:     "refund reversed",
+			captured: 100,
+			setup: func(t *testing.T, database *gorm.DB, payment Payment) {
+				mustPost(t, postCaptureEntry(database, payment, "cap_1", 100, 0))
+				mustPost(t, postRefundEntry(database, payment, "re_1", 30))
+				mustPost(t, postRefundReversalEntry(database, payment, "re_1", 30))
+			},
+		},
+		{
+			name:       "capture missing from the ledger",
+			captured:   100,
+			setup:      func(t *testing.T, database *gorm.DB, payment Payment) {},
+			wantIssues: 1,
+		},
+		{
+			name:     "unbalanced entry",
+			captured: 10,
+			setup: func(t *testing.T, database *gorm.DB, payment Payment) {
+				mustPost(t, postCaptureEntry(database, payment, "cap_1", 10, 0))
+				// Ligne ajoutée hors de postJournalEntry
+				database.Create(&JournalLine{EntryID: 1, Account: AccountMerchant, Debit: 1})
+			},
+			wantIssues: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			database := testDB(t)
+			payment := Payment{OrderID: "1", Currency: "EUR", Status: PaymentStatusCaptured, CapturedAmount: tt.captured}
+			if err := database.Create(&payment).Error; err != nil {
+				t.Fatal(err)
+			}
+			tt.setup(t, database, payment)
+
+			rec := httptest.NewRecorder()
+			checkLedger(rec)
+			var check LedgerCheck
+			if err := json.NewDecoder(rec.Body).Decode(&check); err != nil {
+				t.Fatal(err)
+			}
+			if len(check.Issues) != tt.wantIssues || check.Consistent != (tt.wantIssues == 0) {
+				t.Errorf("check = %+v, want %d issues", check, tt.wantIssues)
+			}
+		})
+	}
+}
+
+func mustPost(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"shared/web"
+)
+
+const PaymentStatusPartiallyRefunded = "partially_refunded"
+
+// PaymentAuthorization trace l'autorisation obtenue lors du passage en caisse
+type PaymentAuthorization struct {
+	ID                uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	PaymentID         uint      `gorm:"index" json:"payment_id"`
+	Amount            float64   `json:"amount"`
+	Status            string    `json:"status"`
+	ProviderReference string    `json:"provider_reference"`
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+// PaymentCapture trace l'encaissement effectué à l'expédition
+type PaymentCapture struct {
+	ID        uint    `gorm:"primaryKey;autoIncrement" json:"id"`
+	PaymentID uint    `gorm:"index" json:"payment_id"`
+	Amount    float64 `json:"amount"`
+	Fee       float64 `json:"fee"`
+	// FeePending : les frais n'étaient pas connus à l'encaissement et restent à rapprocher
+	FeePending        bool      `json:"fee_pending"`
+	Status            string    `json:"status"`
+	ProviderReference string    `json:"provider_reference"`
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+// PaymentRefund trace un remboursement, éventuellement partiel
+type PaymentRefund struct {
+	ID                uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	PaymentID         uint      `gorm:"index" json:"payment_id"`
+	Amount            float64   `json:"amount"`
+	Reason            string    `json:"reason"`
+	Status            string    `json:"status"`
+	ProviderReference string    `json:"provider_reference"`
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+func paymentActionHandler(w http.ResponseWriter, r *http.Request, id string, action string) {
+	if r.Method != "POST" {
+		web.Error(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+	// Le propriétaire consulte son paiement mais ne le capture, n'annule ni ne rembourse
+	if !canOperatePayment(r.Context()) {
+		web.Error(w, http.StatusForbidden, "forbidden", "Only services and administrators can operate on payments")
+		return
+	}
+	switch action {
+	case "capture":
+		capturePayment(w, r, id)
+	case "void":
+		voidPayment(w, r, id)
+	case "refunds":
+		refundPayment(w, r, id)
+	default:
+		web.Error(w, http.StatusNotFound, "not_found", "Unknown payment action")
+	}
+}
+
+func capturePayment(w http.ResponseWriter, r *http.Request, id string) {
+	var request struct {
+		Amount float64 `json:"amount" validate:"min=0"`
+	}
+	if err := web.DecodeJSON(w, r, &request); err != nil && !errors.Is(err, web.ErrEmptyBody) {
+		web.WriteError(w, err, "Payment")
+		return
+	}
+
+	var amount float64
+	payment, key, err := beginOperation(r.Context(), id, "capture", func(payment Payment) error {
+		if payment.Status != PaymentStatusAuthorized {
+			return ErrInvalidState
+		}
+		// Sans montant, on encaisse la totalité de l'autorisation
+		amount = request.Amount
+		if amount == 0 {
+			amount = payment.Amount
+		}
+		if amount < 0 || toMinorUnits(amount) > toMinorUnits(payment.Amount) {
+			return ErrInvalidAmount
+		}
+		return nil
+	})
+	if err != nil {
+		writePaymentResult(w, r, id, err, nil)
+		return
+	}
+
+	ctx := context.WithoutCancel(r.Context())
+	result, providerErr := provider.Capture(ctx, payment.ProviderReference, amount, key)
+	err = finishOperation(ctx, id, key, func(tx *gorm.DB, payment Payment) error {
+		// Le webhook du prestataire a pu enregistrer la capture entre-temps
+		if payment.Status != PaymentStatusAuthorized {
+			return nil
+		}
+		capture := PaymentCapture{PaymentID: payment.ID, Amount: amount}
+		if providerErr != nil {
+			capture.Status = PaymentStatusFailed
+			return tx.Create(&capture).Error
+		}
+		capture.Status = result.Status
+		capture.ProviderReference = result.Reference
+		capture.Fee = result.Fee
+		if err := tx.Create(&capture).Error; err != nil {
+			return err
+		}
+		if err := postCaptureEntry(tx, payment, result.Reference, amount, result.Fee); err != nil {
+			return err
+		}
+		if err := tx.Model(&payment).Updates(map[string]interface{}{
+			"status":          PaymentStatusCaptured,
+			"captured_amount": amount,
+		}).Error; err != nil {
+			return err
+		}
+		return enqueuePaymentEvent(tx, payment.ID)
+	})
+	writePaymentResult(w, r, id, err, providerErr)
+}
+
+func voidPayment(w http.ResponseWriter, r *http.Request, id string) {
+	payment, key, err := beginOperation(r.Context(), id, "void", func(payment Payment) error {
+		if payment.Status != PaymentStatusAuthorized {
+			return ErrInvalidState
+		}
+		return nil
+	})
+	if err != nil {
+		writePaymentResult(w, r, id, err, nil)
+		return
+	}
+
+	ctx := context.WithoutCancel(r.Context())
+	_, providerErr := provider.Void(ctx, payment.ProviderReference, key)
+	err = finishOperation(ctx, id, key, func(tx *gorm.DB, payment Payment) error {
+		if providerErr != nil || payment.Status != PaymentStatusAuthorized {
+			return nil
+		}
+		if err := tx.Model(&PaymentAuthorization{}).Where("payment_id = ?", payment.ID).
+			Update("status", PaymentStatusVoided).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&payment).Update("status", PaymentStatusVoided).Error; err != nil {
+			return err
+		}
+		return enqueuePaymentEvent(tx, payment.ID)
+	})
+	writePaymentResult(w, r, id, err, providerErr)
+}
+
+func refundPayment(w http.ResponseWriter, r *http.Request, id string) {
+	var request struct {
+		Amount float64 `json:"amount" validate:"required,min=0"`
+		Reason string  `json:"reason" validate:"max=500"`
+	}
+	if err := web.DecodeJSON(w, r, &request); err != nil {
+		web.WriteError(w, err, "Refund")
+		return
+	}
+
+	payment, key, err := beginOperation(r.Context(), id, "refund", func(payment Payment) error {
+		if payment.Status != PaymentStatusCaptured && payment.Status != PaymentStatusPartiallyRefunded {
+			return ErrInvalidState
+		}
+		// Le cumul des remboursements ne peut pas dépasser le montant encaissé
+		refunded := toMinorUnits(payment.RefundedAmount) + toMinorUnits(request.Amount)
+		if request.Amount <= 0 || refunded > toMinorUnits(payment.CapturedAmount) {
+			return ErrInvalidAmount
+		}
+		return nil
+	})
+	if err != nil {
+		writePaymentResult(w, r, id, err, nil)
+		return
+	}
+
+	ctx := context.WithoutCancel(r.Context())
+	result, providerErr := provider.Refund(ctx, payment.ProviderReference, request.Amount, key)
+	err = finishOperation(ctx, id, key, func(tx *gorm.DB, payment Payment) error {
+		refund := PaymentRefund{PaymentID: payment.ID, Amount: request.Amount, Reason: request.Reason}
+		if providerErr != nil {
+			refund.Status = PaymentStatusFailed
+			return tx.Create(&refund).Error
+		}
+		refund.Status = result.Status
+		refund.ProviderReference = result.Reference
+		if err := tx.Create(&refund).Error; err != nil {
+			return err
+		}
+		if err := postRefundEntry(tx, payment, result.Reference, request.Amount); err != nil {
+			return err
+		}
+
+		refunded := toMinorUnits(payment.RefundedAmount) + toMinorUnits(request.Amount)
+		status := PaymentStatusPartiallyRefunded
+		if refunded >= toMinorUnits(payment.CapturedAmount) {
+			status = PaymentStatusRefunded
+		}
+		if err := tx.Model(&payment).Updates(map[string]interface{}{
+			"status":          status,
+			"refunded_amount": payment.RefundedAmount + request.Amount,
+		}).Error; err != nil {
+			return err
+		}
+		return enqueuePaymentEvent(tx, payment.ID)
+	})
+	writePaymentResult(w, r, id, err, providerErr)
+}
+
+// pendingOperationTimeout libère un paiement dont l'opération n'a pas abouti, le service
+// s'étant arrêté pendant l'appel au prestataire
+const pendingOperationTimeout = 2 * time.Minute
+
+// beginOperation vérifie sous verrou que l'opération est permise (check) puis enregistre
+// l'intention sur le paiement, ce qui écarte les opérations concurrentes. Le verrou est
+// relâché au retour : l'appel au prestataire se fait hors transaction, avec la clé
+// d'idempotence renvoyée, et finishOperation en applique le résultat.
+func beginOperation(ctx context.Context, id, operation string, check func(payment Payment) error) (Payment, string, error) {
+	var payment Payment
+	var key string
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := lockPayment(tx, id, &payment); err != nil {
+			return err
+		}
+		if payment.PendingOperation != "" && payment.PendingSince != nil && time.Since(*payment.PendingSince) < pendingOperationTimeout {
+			return ErrOperationInProgress
+		}
+		if err := check(payment); err != nil {
+			return err
+		}
+		now := time.Now()
+		key = fmt.Sprintf("payment-%d-%s-%d", payment.ID, operation, now.UnixNano())
+		return tx.Model(&payment).Updates(map[string]interface{}{"pending_operation": key, "pending_since": now}).Error
+	})
+	return payment, key, err
+}
+
+// finishOperation applique le résultat du prestataire au paiement relu sous verrou, puis
+// efface l'intention. Une opération expirée et remplacée par une autre n'est pas appliquée :
+// le webhook du prestataire en reportera l'effet.
+func finishOperation(ctx context.Context, id, key string, apply func(tx *gorm.DB, payment Payment) error) error {
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var payment Payment
+		if err := lockPayment(tx, id, &payment); err != nil {
+			return err
+		}
+		if payment.PendingOperation != key {
+			slog.WarnContext(ctx, "payment operation expired before completion", "payment_id", id, "operation", key)
+			return ErrOperationInProgress
+		}
+		if err := apply(tx, payment); err != nil {
+			return err
+		}
+		return tx.Model(&Payment{}).Where("id = ?", payment.ID).
+			Updates(map[string]interface{}{"pending_operation": nil, "pending_since": nil}).Error
+	})
+}
+
+// canAccessPayment réserve la lecture d'un paiement à son propriétaire, aux administrateurs
+// et aux autres services
+func canAccessPayment(ctx context.Context, payment Payment) bool {
+	if canOperatePayment(ctx) {
+		return true
+	}
+	userID := web.UserID(ctx)
+	return userID != "" && userID == payment.UserID
+}
+
+// canOperatePayment réserve capture, annulation et remboursement aux autres services et
+// aux administrateurs
+func canOperatePayment(ctx context.Context) bool {
+	return web.Internal(ctx) || web.IsAdmin(cfg.AdminUserIDs, web.UserID(ctx))
+}
+
+// lockPayment charge le paiement en le verrouillant jusqu'à la fin de la transaction
+func lockPayment(tx *gorm.DB, id string, payment *Payment) error {
+	return tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(payment, "id = ?", id).Error
+}
+
+func loadPayment(id string) (Payment, error) {
+	var payment Payment
+	err := db.Preload("Authorization").Preload("Capture").Preload("Refunds").First(&payment, "id = ?", id).Error
+	return payment, err
+}
+
+func writePaymentResult(w http.ResponseWriter, r *http.Request, id string, err error, providerErr error) {
+	if err == nil && providerErr != nil {
+		slog.ErrorContext(r.Context(), "provider operation failed", "payment_id", id, "error", providerErr)
+		err = providerErr
+	}
+	switch {
+	case err == nil:
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		web.Error(w, http.StatusNotFound, "not_found", "Payment not found")
+		return
+	case errors.Is(err, ErrInvalidAmount):
+		web.Error(w, http.StatusBadRequest, "invalid_amount", err.Error())
+		return
+	case errors.Is(err, ErrInvalidState):
+		web.Error(w, http.StatusConflict, "invalid_payment_state", err.Error())
+		return
+	case errors.Is(err, ErrOperationInProgress):
+		web.Error(w, http.StatusConflict, "operation_in_progress", err.Error())
+		return
+	case errors.Is(err, ErrPaymentDeclined):
+		web.Error(w, http.StatusPaymentRequired, "payment_declined", "Payment declined")
+		return
+	case err == providerErr:
+		web.Error(w, http.StatusBadGateway, "payment_provider_error", "Payment provider error")
+		return
+	default:
+		web.WriteError(w, err, "Payment")
+		return
+	}
+
+	payment, err := loadPayment(id)
+	if err != nil {
+		web.WriteError(w, err, "Payment")
+		return
+	}
+	paymentsByStatus.WithLabelValues(payment.Status).Inc()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(payment)
+}
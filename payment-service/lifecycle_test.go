@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+	"shared/outbox"
+)
+
+// lifecycleDB prépare la base, l'outbox et un prestataire factice sur lequel 50 EUR sont
+// autorisés ; la référence de l'autorisation est renvoyée
+func lifecycleDB(t *testing.T) (*gorm.DB, *fakeProvider, string) {
+	t.Helper()
+	database := testDB(t)
+	if err := database.Table("payment_outbox").AutoMigrate(&outbox.Message{}); err != nil {
+		t.Fatal(err)
+	}
+	previousProvider, previousEvents := provider, events
+	fake := newFakeProvider()
+	provider, events = fake, outbox.Outbox{DB: database, Table: "payment_outbox"}
+	t.Cleanup(func() { provider, events = previousProvider, previousEvents })
+
+	result, err := fake.Authorize(context.Background(), AuthorizeRequest{OrderID: "1", Amount: 50, Currency: "EUR"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return database, fake, result.Reference
+}
+
+func TestCapturePaymentLimits(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       string
+		body         string
+		wantCode     int
+		wantCaptured float64
+	}{
+		{name: "whole authorization", status: PaymentStatusAuthorized, wantCode: 200, wantCaptured: 50},
+		{name: "partial capture", status: PaymentStatusAuthorized, body: `{"amount":20}`, wantCode: 200, wantCaptured: 20},
+		{name: "exact authorization", status: PaymentStatusAuthorized, body: `{"amount":50.00}`, wantCode: 200, wantCaptured: 50},
+		{name: "one cent above authorization", status: PaymentStatusAuthorized, body: `{"amount":50.01}`, wantCode: 400},
+		{name: "negative amount", status: PaymentStatusAuthorized, body: `{"amount":-5}`, wantCode: 422},
+		{name: "already captured", status: PaymentStatusCaptured, wantCode: 409},
+		{name: "voided", status: PaymentStatusVoided, wantCode: 409},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			database, _, reference := lifecycleDB(t)
+			payment := Payment{OrderID: "1", Amount: 50, Currency: "EUR", Status: tt.status, Provider: "fake", ProviderReference: reference}
+			if err := database.Create(&payment).Error; err != nil {
+				t.Fatal(err)
+			}
+
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest("POST", fmt.Sprintf("/payments/%d/capture", payment.ID), strings.NewReader(tt.body))
+			capturePayment(rec, req, fmt.Sprint(payment.ID))
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+
+			var stored Payment
+			database.First(&stored, payment.ID)
+			if stored.PendingOperation != "" {
+				t.Errorf("pending operation %q left on the payment", stored.PendingOperation)
+			}
+			if tt.wantCode != 200 {
+				if stored.Status != tt.status || stored.CapturedAmount != 0 {
+					t.Errorf("payment = %s, captured %v, want unchanged", stored.Status, stored.CapturedAmount)
+				}
+				return
+			}
+			if stored.Status != PaymentStatusCaptured || stored.CapturedAmount != tt.wantCaptured {
+				t.Errorf("payment = %s, captured %v, want captured %v", stored.Status, stored.CapturedAmount, tt.wantCaptured)
+			}
+		})
+	}
+}
+
+func TestRefundPaymentLimits(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       string
+		refunded     float64
+		body         string
+		wantCode     int
+		wantStatus   string
+		wantRefunded float64
+	}{
+		{name: "partial refund", status: PaymentStatusCaptured, body: `{"amount":20}`, wantCode: 200, wantStatus: PaymentStatusPartiallyRefunded, wantRefunded: 20},
+		{name: "full refund", status: PaymentStatusCaptured, body: `{"amount":50}`, wantCode: 200, wantStatus: PaymentStatusRefunded, wantRefunded: 50},
+		{name: "remaining amount", status: PaymentStatusPartiallyRefunded, refunded: 20, body: `{"amount":30}`, wantCode: 200, wantStatus: PaymentStatusRefunded, wantRefunded: 50},
+		{name: "one cent above captured", status: PaymentStatusCaptured, body: `{"amount":50.01}`, wantCode: 400},
+		{name: "above remaining amount", status: PaymentStatusPartiallyRefunded, refunded: 20, body: `{"amount":30.01}`, wantCode: 400},
+		{name: "missing amount", status: PaymentStatusCaptured, body: `{}`, wantCode: 422},
+		{name: "not captured", status: PaymentStatusAuthorized, body: `{"amount":10}`, wantCode: 409},
+		{name: "already refunded", status: PaymentStatusRefunded, refunded: 50, body: `{"amount":1}`, wantCode: 409},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			database, fake, reference := lifecycleDB(t)
+			if _, err := fake.capture(reference, 50); err != nil {
+				t.Fatal(err)
+			}
+			if tt.refunded > 0 {
+				if _, err := fake.refund(reference, tt.refunded); err != nil {
+					t.Fatal(err)
+				}
+			}
+			payment := Payment{OrderID: "1", Amount: 50, Currency: "EUR", Status: tt.status, Provider: "fake", ProviderReference: reference,
+				CapturedAmount: 50, RefundedAmount: tt.refunded}
+			if tt.status == PaymentStatusAuthorized {
+				payment.CapturedAmount = 0
+			}
+			if err := database.Create(&payment).Error; err != nil {
+				t.Fatal(err)
+			}
+
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest("POST", fmt.Sprintf("/payments/%d/refunds", payment.ID), strings.NewReader(tt.body))
+			refundPayment(rec, req, fmt.Sprint(payment.ID))
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+
+			var stored Payment
+			database.First(&stored, payment.ID)
+			if tt.wantCode != 200 {
+				if stored.Status != tt.status || stored.RefundedAmount != tt.refunded {
+					t.Errorf("payment = %s, refunded %v, want unchanged", stored.Status, stored.RefundedAmount)
+				}
+				return
+			}
+			if stored.Status != tt.wantStatus || stored.RefundedAmount != tt.wantRefunded {
+				t.Errorf("payment = %s, refunded %v, want %s, refunded %v", stored.Status, stored.RefundedAmount, tt.wantStatus, tt.wantRefunded)
+			}
+		})
+	}
+}
@@ -2,68 +2,94 @@ package main
 
 import (
 	"context"
+	"embed"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"os"
+	"strconv"
 	"strings"
 	"time"
 
-	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"shared/logging"
+	"shared/metrics"
+	"shared/service"
+	"shared/tracing"
+	"shared/web"
 )
 
+const defaultCurrency = "EUR"
+
 type Payment struct {
-	ID      uint    `gorm:"primaryKey;autoIncrement" json:"id"`
-	OrderID string  `json:"order_id"`
-	Amount  float64 `json:"amount"`
-	Status  string  `json:"status"`
+	ID      uint   `gorm:"primaryKey;autoIncrement" json:"id"`
+	OrderID string `json:"order_id" validate:"required,max=64"`
+	UserID  string `json:"user_id"`
+	// Total de la commande, relu auprès d'order-service : le client ne fixe pas le montant
+	Amount            float64 `json:"amount"`
+	Currency          string  `json:"currency"`
+	Status            string  `json:"status"`
+	Provider          string  `json:"provider"`
+	ProviderReference string  `json:"provider_reference"`
+	CapturedAmount    float64 `json:"captured_amount"`
+	RefundedAmount    float64 `json:"refunded_amount"`
+	// Moyen de paiement transmis au prestataire, jamais stocké
+	PaymentMethod string `gorm:"-" json:"payment_method,omitempty" validate:"max=255"`
+	// Clé d'idempotence de la capture, de l'annulation ou du remboursement en cours auprès
+	// du prestataire, et son début
+	PendingOperation string     `json:"-"`
+	PendingSince     *time.Time `json:"-"`
+
+	Authorization *PaymentAuthorization `json:"authorization,omitempty"`
+	Capture       *PaymentCapture       `json:"capture,omitempty"`
+	Refunds       []PaymentRefund       `json:"refunds,omitempty"`
 }
 
-var db *gorm.DB
+// Migrations SQL du schéma, appliquées par la sous-commande migrate
+//
+//go:embed migrations/*.sql
+var migrations embed.FS
 
-func initDB() {
-	dsn := "host=db user=user password=password dbname=microservices port=5432 sslmode=disable TimeZone=Asia/Shanghai"
+var (
+	db       *gorm.DB
+	provider PaymentProvider
+)
+
+func initProvider() {
 	var err error
-	db, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	provider, err = newPaymentProvider()
 	if err != nil {
-		log.Fatalf("failed to connect database: %v", err)
-	}
-}
-
-func migrate() {
-	db.AutoMigrate(&Payment{})
-}
-
-func paymentsHandler(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case "GET":
-		getPayments(w)
-	case "POST":
-		createPayment(w, r)
-	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		logging.Fatal("failed to configure payment provider", "error", err)
 	}
 }
 
 func paymentHandler(w http.ResponseWriter, r *http.Request) {
 	id := r.URL.Path[len("/payments/"):]
+	if paymentID, action, ok := strings.Cut(id, "/"); ok {
+		paymentActionHandler(w, r, paymentID, action)
+		return
+	}
 	switch r.Method {
 	case "GET":
-		getPayment(w, id)
-	case "PUT":
-		updatePayment(w, r, id)
-	case "DELETE":
-		deletePayment(w, id)
+		getPayment(w, r, id)
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		// Les paiements ne sont jamais modifiés ni supprimés, seulement capturés, annulés ou remboursés
+		web.Error(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
 	}
 }
 
-func getPayments(w http.ResponseWriter) {
+func getPayments(w http.ResponseWriter, r *http.Request) {
+	query := db.WithContext(r.Context())
+	// Chacun ne voit que ses paiements, les administrateurs et les autres services les voient tous
+	if !canOperatePayment(r.Context()) {
+		query = query.Where("user_id = ?", web.UserID(r.Context()))
+	}
 	var payments []Payment
-	if err := db.Find(&payments).Error; err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := query.Find(&payments).Error; err != nil {
+		web.WriteError(w, err, "Payment")
 		return
 	}
 	json.NewEncoder(w).Encode(payments)
@@ -72,121 +98,204 @@ func getPayments(w http.ResponseWriter) {
 func createPayment(w http.ResponseWriter, r *http.Request) {
 	token := r.Header.Get("Authorization")
 	if token == "" {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		web.Error(w, http.StatusUnauthorized, "unauthorized", "Unauthorized")
 		return
 	}
 	var payment Payment
-	if err := json.NewDecoder(r.Body).Decode(&payment); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if err := web.DecodeJSON(w, r, &payment); err != nil {
+		web.WriteError(w, err, "Payment")
 		return
 	}
 
-	// Vérifier l'existence de la commande
-	orderExists := checkOrderExists(payment.OrderID, token)
-	if !orderExists {
-		http.Error(w, "Order not found", http.StatusBadRequest)
+	orderID, ok := canonicalOrderID(payment.OrderID)
+	if !ok {
+		web.WriteError(w, web.ValidationError(web.FieldError{Field: "order_id", Code: "invalid", Message: "must be an order id"}), "Payment")
 		return
 	}
+	payment.OrderID = orderID
 
-	if err := db.Create(&payment).Error; err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	// Seul le propriétaire de la commande la paie, au montant de la commande
+	order, err := fetchOrder(r.Context(), payment.OrderID, token)
+	if errors.Is(err, errOrderNotFound) || (err == nil && order.UserID != web.UserID(r.Context())) {
+		web.Error(w, http.StatusBadRequest, "order_not_found", "Order not found")
 		return
 	}
-	w.WriteHeader(http.StatusCreated)
-}
-
-func checkOrderExists(orderID string, token string) bool {
-	client := &http.Client{
-		Timeout: 5 * time.Second,
-	}
-	req, err := http.NewRequest("GET", fmt.Sprintf("http://order-service:8083/orders/%s", orderID), nil)
 	if err != nil {
-		return false
+		slog.ErrorContext(r.Context(), "failed to fetch order", "order_id", payment.OrderID, "error", err)
+		web.Error(w, http.StatusBadGateway, "order_service_error", "Could not read order")
+		return
 	}
-	req.Header.Set("Authorization", token)
-
-	resp, err := client.Do(req)
-	if err != nil || resp.StatusCode != http.StatusOK {
-		return false
+	payment.Amount = float64(toMinorUnits(order.UnitPrice*float64(order.Quantity))) / 100
+	payment.Currency = order.Currency
+	if payment.Currency == "" {
+		payment.Currency = defaultCurrency
+	}
+	if payment.Amount <= 0 {
+		// Commande passée avant que son prix ne soit enregistré
+		web.Error(w, http.StatusUnprocessableEntity, "order_not_payable", "Order has no price")
+		return
 	}
-	return true
-}
 
-func getPayment(w http.ResponseWriter, id string) {
-	var payment Payment
-	if err := db.First(&payment, "id = ?", id).Error; err != nil {
-		http.Error(w, "Payment not found", http.StatusNotFound)
+	// Le statut est fixé par le prestataire, jamais par le client
+	payment.ID = 0
+	payment.UserID = web.UserID(r.Context())
+	payment.Status = PaymentStatusPending
+	payment.Provider = provider.Name()
+	payment.ProviderReference = ""
+	payment.CapturedAmount = 0
+	payment.RefundedAmount = 0
+	payment.Authorization, payment.Capture, payment.Refunds = nil, nil, nil
+	db := db.WithContext(r.Context())
+	// L'index unique sur order_id n'admet qu'un paiement en cours ou abouti par commande
+	err = db.Create(&payment).Error
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		web.Error(w, http.StatusConflict, "order_already_paid", "Order already has a payment")
+		return
+	}
+	if err != nil {
+		web.WriteError(w, err, "Payment")
 		return
 	}
-	json.NewEncoder(w).Encode(payment)
-}
 
-func updatePayment(w http.ResponseWriter, r *http.Request, id string) {
-	var payment Payment
-	if err := json.NewDecoder(r.Body).Decode(&payment); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	// L'encaissement se fait plus tard, à l'expédition, via /payments/{id}/capture
+	authorization, authErr := authorizePayment(r.Context(), &payment)
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&authorization).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&payment).Select("status", "provider_reference").Updates(&payment).Error; err != nil {
+			return err
+		}
+		return events.Enqueue(tx, paymentEvent(payment))
+	})
+	if err != nil {
+		web.WriteError(w, err, "Payment")
 		return
 	}
-	if err := db.Model(&Payment{}).Where("id = ?", id).Updates(payment).Error; err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	paymentsByStatus.WithLabelValues(payment.Status).Inc()
+	if authErr != nil {
+		slog.WarnContext(r.Context(), "payment authorization failed", "payment_id", payment.ID, "status", payment.Status, "error", authErr)
+		if errors.Is(authErr, ErrPaymentDeclined) {
+			web.Error(w, http.StatusPaymentRequired, "payment_declined", "Payment declined")
+			return
+		}
+		web.Error(w, http.StatusBadGateway, "payment_provider_error", "Payment provider error")
 		return
 	}
-	w.WriteHeader(http.StatusOK)
+
+	payment.Authorization = &authorization
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(payment)
 }
 
-func deletePayment(w http.ResponseWriter, id string) {
-	if err := db.Delete(&Payment{}, "id = ?", id).Error; err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+// canonicalOrderID écrit l'identifiant de commande sous sa forme canonique : "07" et "7"
+// désignent la même commande, l'index unique sur order_id ne doit voir que "7"
+func canonicalOrderID(orderID string) (string, bool) {
+	id, err := strconv.ParseUint(orderID, 10, 64)
+	if err != nil || id == 0 {
+		return "", false
 	}
-	w.WriteHeader(http.StatusOK)
+	return strconv.FormatUint(id, 10), true
 }
 
-func jwtMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		token := r.Header.Get("Authorization")
-		if token == "" {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
+// authorizePayment réserve le montant du paiement auprès du prestataire
+func authorizePayment(ctx context.Context, payment *Payment) (PaymentAuthorization, error) {
+	result, err := provider.Authorize(ctx, AuthorizeRequest{
+		OrderID:        payment.OrderID,
+		Amount:         payment.Amount,
+		Currency:       payment.Currency,
+		PaymentMethod:  payment.PaymentMethod,
+		IdempotencyKey: fmt.Sprintf("payment-%d-authorize", payment.ID),
+	})
+	payment.ProviderReference = result.Reference
+	payment.Status = result.Status
+	if err != nil {
+		payment.Status = PaymentStatusFailed
+		if errors.Is(err, ErrPaymentDeclined) {
+			payment.Status = PaymentStatusDeclined
 		}
+	} else if result.Status != PaymentStatusAuthorized && result.Status != PaymentStatusPending {
+		// pending: action du client requise (3DS), le prestataire confirmera plus tard
+		err = fmt.Errorf("unexpected authorization status %q", result.Status)
+	}
+	return PaymentAuthorization{
+		PaymentID:         payment.ID,
+		Amount:            payment.Amount,
+		Status:            payment.Status,
+		ProviderReference: result.Reference,
+	}, err
+}
 
-		resp, err := http.Post("http://auth-service:8080/verify-token", "application/json", strings.NewReader(fmt.Sprintf(`{"token":"%s"}`, token)))
-		if err != nil {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
+// orderSummary est la partie de la commande dont dépend le paiement
+type orderSummary struct {
+	UserID    string  `json:"user_id"`
+	Quantity  int     `json:"quantity"`
+	UnitPrice float64 `json:"unit_price"`
+	Currency  string  `json:"currency"`
+}
 
-		if resp.StatusCode != http.StatusOK {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
+var errOrderNotFound = errors.New("order not found")
 
-		var result struct {
-			UserID string `json:"user_id"`
-		}
-		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-		ctx := context.WithValue(r.Context(), "user_id", result.UserID)
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
+// fetchOrder lit la commande auprès d'order-service avec le jeton de l'utilisateur
+func fetchOrder(ctx context.Context, orderID string, token string) (order orderSummary, err error) {
+	start := time.Now()
+	defer func() { metrics.ObserveCall("fetch_order", start, err == nil || errors.Is(err, errOrderNotFound)) }()
+	client := tracing.Client(5 * time.Second)
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/orders/%s", cfg.OrderServiceURL, url.PathEscape(orderID)), nil)
+	if err != nil {
+		return order, err
+	}
+	req.Header.Set("Authorization", token)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return order, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return order, errOrderNotFound
+	default:
+		return order, fmt.Errorf("order-service returned status %d", resp.StatusCode)
+	}
+	err = json.NewDecoder(resp.Body).Decode(&order)
+	return order, err
 }
 
-func healthHandler(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("OK"))
+func getPayment(w http.ResponseWriter, r *http.Request, id string) {
+	payment, err := loadPayment(id)
+	if err != nil || !canAccessPayment(r.Context(), payment) {
+		web.Error(w, http.StatusNotFound, "not_found", "Payment not found")
+		return
+	}
+	json.NewEncoder(w).Encode(payment)
 }
 
 func main() {
-	initDB()
-	migrate()
+	svc := service.New("Payment Service", &cfg)
+	db = svc.DB
+	svc.DependsOn("order-service", cfg.OrderServiceURL)
+	initProvider()
+	svc.Migrate(migrations)
 
-	mux := http.NewServeMux()
-	mux.Handle("/payments", jwtMiddleware(http.HandlerFunc(paymentsHandler)))
-	mux.Handle("/payments/", jwtMiddleware(http.HandlerFunc(paymentHandler)))
-	mux.HandleFunc("/health", healthHandler)
+	if len(os.Args) > 1 && os.Args[1] == "reconcile" {
+		runReconcileCommand(os.Args[2:])
+		return
+	}
+	initEventBus()
+	svc.OnStop(func() { eventBus.Close() })
+	svc.Go(events.Run)
 
-	log.Println("Starting Payment Service on :8084")
-	log.Fatal(http.ListenAndServe(":8084", mux))
+	payments := web.Methods{"GET": getPayments, "POST": createPayment}
+	reconciliations := web.Methods{"GET": getReconciliations, "POST": createReconciliation}
+	svc.Router.Handle("/payments", payments, svc.RequireUser(), web.Idempotency(web.IdempotencyTable{DB: db, Name: "payment_idempotency_keys"}))
+	// Lecture par le propriétaire ; capture, annulation et remboursement par les autres services et les administrateurs
+	svc.Router.HandleFunc("/payments/", paymentHandler, svc.RequireUserOrInternal())
+	svc.Router.HandleFunc("/ledger/", ledgerHandler, svc.RequireUser(), svc.RequireAdmin())
+	svc.Router.Handle("/admin/reconciliations", reconciliations, svc.RequireUser(), svc.RequireAdmin())
+	svc.Router.HandleFunc("/admin/reconciliations/", reconciliationHandler, svc.RequireUser(), svc.RequireAdmin())
+	svc.Router.HandleFunc("/webhooks/", webhookHandler)
+	svc.Run()
 }
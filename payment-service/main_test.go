@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"shared/web"
+)
+
+// testDB remplace la base du service par une base SQLite en mémoire, propre au test
+func testDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name())
+	database, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = database.AutoMigrate(&Payment{}, &PaymentAuthorization{}, &PaymentCapture{}, &PaymentRefund{}, &JournalEntry{}, &JournalLine{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := db
+	db = database
+	t.Cleanup(func() {
+		db = previous
+		if sqlDB, err := database.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return database
+}
+
+func TestGetPayments(t *testing.T) {
+	database := testDB(t)
+	cfg.AdminUserIDs = []string{"admin"}
+	t.Cleanup(func() { cfg.AdminUserIDs = nil })
+	for _, payment := range []Payment{{OrderID: "1", UserID: "alice"}, {OrderID: "2", UserID: "bob"}, {OrderID: "3", UserID: "alice"}} {
+		if err := database.Create(&payment).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		userID string
+		ctx    func(t *testing.T) context.Context
+		want   []string
+	}{
+		{userID: "alice", want: []string{"1", "3"}},
+		{userID: "bob", want: []string{"2"}},
+		{userID: "carol"},
+		{userID: "admin", want: []string{"1", "2", "3"}},
+		{userID: "internal", ctx: internalContext, want: []string{"1", "2", "3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.userID, func(t *testing.T) {
+			ctx := web.WithUserID(context.Background(), tt.userID)
+			if tt.ctx != nil {
+				ctx = tt.ctx(t)
+			}
+			rec := httptest.NewRecorder()
+			getPayments(rec, httptest.NewRequest("GET", "/payments", nil).WithContext(ctx))
+			var payments []Payment
+			if err := json.NewDecoder(rec.Body).Decode(&payments); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, payment := range payments {
+				got = append(got, payment.OrderID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("orders = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCanonicalOrderID(t *testing.T) {
+	tests := []struct {
+		orderID string
+		want    string
+		wantOK  bool
+	}{
+		{orderID: "7", want: "7", wantOK: true},
+		{orderID: "07", want: "7", wantOK: true},
+		{orderID: "0000000042", want: "42", wantOK: true},
+		{orderID: "0"},
+		{orderID: "-7"},
+		{orderID: "+7"},
+		{orderID: " 7"},
+		{orderID: "7a"},
+		{orderID: "0x7"},
+		{orderID: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.orderID, func(t *testing.T) {
+			got, ok := canonicalOrderID(tt.orderID)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("canonicalOrderID(%q) = %q, %v, want %q, %v", tt.orderID, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,13 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// paymentsByStatus compte les paiements à chaque statut atteint : un paiement capturé puis
+// remboursé est compté une fois comme "captured" et une fois comme "refunded"
+var paymentsByStatus = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "payments_total",
+	Help: "Paiements ayant atteint chaque statut.",
+}, []string{"status"})
@@ -0,0 +1,9 @@
+DROP TABLE IF EXISTS reconciliation_items;
+DROP TABLE IF EXISTS reconciliation_reports;
+DROP TABLE IF EXISTS payment_outbox;
+DROP TABLE IF EXISTS payment_idempotency_keys;
+DROP TABLE IF EXISTS webhook_events;
+DROP TABLE IF EXISTS payment_refunds;
+DROP TABLE IF EXISTS payment_captures;
+DROP TABLE IF EXISTS payment_authorizations;
+DROP TABLE IF EXISTS payments;
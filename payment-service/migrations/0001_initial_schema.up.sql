@@ -0,0 +1,109 @@
+-- Schéma créé jusqu'ici par AutoMigrate ; une base existante s'adopte avec
+-- "migrate baseline", qui vérifie qu'elle correspond à cette migration
+CREATE TABLE payments (
+    id bigserial PRIMARY KEY,
+    order_id text,
+    user_id text,
+    amount numeric,
+    currency text,
+    status text,
+    provider text,
+    provider_reference text,
+    captured_amount numeric,
+    refunded_amount numeric
+);
+
+CREATE TABLE payment_authorizations (
+    id bigserial PRIMARY KEY,
+    payment_id bigint CONSTRAINT fk_payments_authorization REFERENCES payments (id),
+    amount numeric,
+    status text,
+    provider_reference text,
+    created_at timestamptz
+);
+CREATE INDEX idx_payment_authorizations_payment_id ON payment_authorizations (payment_id);
+
+CREATE TABLE payment_captures (
+    id bigserial PRIMARY KEY,
+    payment_id bigint CONSTRAINT fk_payments_capture REFERENCES payments (id),
+    amount numeric,
+    fee numeric,
+    status text,
+    provider_reference text,
+    created_at timestamptz
+);
+CREATE INDEX idx_payment_captures_payment_id ON payment_captures (payment_id);
+
+CREATE TABLE payment_refunds (
+    id bigserial PRIMARY KEY,
+    payment_id bigint CONSTRAINT fk_payments_refunds REFERENCES payments (id),
+    amount numeric,
+    reason text,
+    status text,
+    provider_reference text,
+    created_at timestamptz
+);
+CREATE INDEX idx_payment_refunds_payment_id ON payment_refunds (payment_id);
+
+CREATE TABLE webhook_events (
+    id bigserial PRIMARY KEY,
+    provider text,
+    event_id text,
+    type text,
+    reference text,
+    payment_id bigint,
+    received_at timestamptz
+);
+CREATE UNIQUE INDEX idx_webhook_provider_event ON webhook_events (provider, event_id);
+
+CREATE TABLE payment_idempotency_keys (
+    idempotency_key text,
+    user_id text,
+    request_hash text,
+    status_code bigint,
+    content_type text,
+    body bytea,
+    created_at timestamptz,
+    PRIMARY KEY (idempotency_key, user_id)
+);
+
+CREATE TABLE payment_outbox (
+    id bigserial PRIMARY KEY,
+    event_id text,
+    event_type text,
+    aggregate_type text,
+    aggregate_id text,
+    payload bytea,
+    created_at timestamptz,
+    published_at timestamptz,
+    attempts bigint,
+    last_error text,
+    next_attempt_at timestamptz
+);
+CREATE UNIQUE INDEX idx_payment_outbox_event_id ON payment_outbox (event_id);
+CREATE INDEX idx_payment_outbox_published_at ON payment_outbox (published_at);
+CREATE INDEX idx_payment_outbox_aggregate ON payment_outbox (aggregate_type, aggregate_id);
+
+CREATE TABLE reconciliation_reports (
+    id bigserial PRIMARY KEY,
+    file_name text,
+    provider text,
+    matched bigint,
+    mismatched bigint,
+    missing bigint,
+    created_at timestamptz
+);
+
+CREATE TABLE reconciliation_items (
+    id bigserial PRIMARY KEY,
+    report_id bigint CONSTRAINT fk_reconciliation_reports_items REFERENCES reconciliation_reports (id),
+    line bigint,
+    kind text,
+    reference text,
+    payment_id bigint,
+    outcome text,
+    expected_amount numeric,
+    settled_amount numeric,
+    detail text
+);
+CREATE INDEX idx_reconciliation_items_report_id ON reconciliation_items (report_id);
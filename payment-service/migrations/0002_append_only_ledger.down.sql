@@ -0,0 +1,4 @@
+-- DROP TABLE n'est pas bloqué par les triggers : annuler cette migration efface le journal
+DROP TABLE IF EXISTS journal_lines;
+DROP TABLE IF EXISTS journal_entries;
+DROP FUNCTION IF EXISTS reject_ledger_change();
@@ -0,0 +1,41 @@
+-- Journal en partie double ; les triggers interdisent toute modification ou suppression
+-- des écritures au niveau de la base
+CREATE TABLE IF NOT EXISTS journal_entries (
+    id bigserial PRIMARY KEY,
+    payment_id bigint,
+    event_type text,
+    reference text,
+    currency text,
+    created_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_journal_entries_payment_id ON journal_entries (payment_id);
+
+CREATE TABLE IF NOT EXISTS journal_lines (
+    id bigserial PRIMARY KEY,
+    entry_id bigint CONSTRAINT fk_journal_entries_lines REFERENCES journal_entries (id),
+    account text,
+    debit bigint,
+    credit bigint
+);
+CREATE INDEX IF NOT EXISTS idx_journal_lines_entry_id ON journal_lines (entry_id);
+CREATE INDEX IF NOT EXISTS idx_journal_lines_account ON journal_lines (account);
+
+CREATE OR REPLACE FUNCTION reject_ledger_change() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'ledger is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS journal_entries_append_only ON journal_entries;
+CREATE TRIGGER journal_entries_append_only BEFORE UPDATE OR DELETE ON journal_entries
+    FOR EACH ROW EXECUTE FUNCTION reject_ledger_change();
+DROP TRIGGER IF EXISTS journal_entries_no_truncate ON journal_entries;
+CREATE TRIGGER journal_entries_no_truncate BEFORE TRUNCATE ON journal_entries
+    FOR EACH STATEMENT EXECUTE FUNCTION reject_ledger_change();
+
+DROP TRIGGER IF EXISTS journal_lines_append_only ON journal_lines;
+CREATE TRIGGER journal_lines_append_only BEFORE UPDATE OR DELETE ON journal_lines
+    FOR EACH ROW EXECUTE FUNCTION reject_ledger_change();
+DROP TRIGGER IF EXISTS journal_lines_no_truncate ON journal_lines;
+CREATE TRIGGER journal_lines_no_truncate BEFORE TRUNCATE ON journal_lines
+    FOR EACH STATEMENT EXECUTE FUNCTION reject_ledger_change();
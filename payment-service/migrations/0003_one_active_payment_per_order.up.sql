@@ -0,0 +1,5 @@
+-- Une commande n'a qu'un paiement en cours ou abouti ; un paiement annulé, refusé ou en
+-- échec n'empêche pas d'en créer un nouveau. Échoue si la base contient déjà des doublons,
+-- à résoudre à la main avant de relancer la migration.
+CREATE UNIQUE INDEX idx_payments_active_order ON payments (order_id)
+    WHERE status NOT IN ('voided', 'declined', 'failed');
@@ -0,0 +1,2 @@
+ALTER TABLE payments DROP COLUMN IF EXISTS pending_since;
+ALTER TABLE payments DROP COLUMN IF EXISTS pending_operation;
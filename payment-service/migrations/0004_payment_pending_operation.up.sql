@@ -0,0 +1,4 @@
+-- Opération en cours auprès du prestataire, enregistrée avant l'appel pour ne pas garder
+-- le paiement verrouillé pendant celui-ci
+ALTER TABLE payments ADD COLUMN pending_operation text;
+ALTER TABLE payments ADD COLUMN pending_since timestamptz;
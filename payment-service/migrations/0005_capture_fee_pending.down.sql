@@ -0,0 +1 @@
+ALTER TABLE payment_captures DROP COLUMN IF EXISTS fee_pending;
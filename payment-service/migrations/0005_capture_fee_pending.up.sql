@@ -0,0 +1,2 @@
+-- Encaissement appris par webhook sans les frais du prestataire, à rapprocher du relevé
+ALTER TABLE payment_captures ADD COLUMN fee_pending boolean NOT NULL DEFAULT false;
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+)
+
+const (
+	PaymentStatusPending    = "pending"
+	PaymentStatusAuthorized = "authorized"
+	PaymentStatusCaptured   = "captured"
+	PaymentStatusVoided     = "voided"
+	PaymentStatusRefunded   = "refunded"
+	PaymentStatusDeclined   = "declined"
+	PaymentStatusFailed     = "failed"
+)
+
+var (
+	ErrPaymentDeclined = errors.New("payment declined")
+	ErrUnknownPayment  = errors.New("unknown payment reference")
+	ErrInvalidAmount   = errors.New("invalid amount")
+	ErrInvalidState    = errors.New("operation not allowed in current payment state")
+	// ErrOperationInProgress : une autre capture, annulation ou remboursement est en cours
+	ErrOperationInProgress = errors.New("another operation is in progress on this payment")
+)
+
+// AuthorizeRequest décrit une demande d'autorisation auprès du prestataire
+type AuthorizeRequest struct {
+	OrderID        string
+	Amount         float64
+	Currency       string
+	PaymentMethod  string
+	IdempotencyKey string
+}
+
+// ProviderResult est la réponse normalisée d'un prestataire de paiement
+type ProviderResult struct {
+	Reference string
+	Status    string
+	// Frais prélevés par le prestataire lors de l'encaissement
+	Fee float64
+}
+
+// PaymentProvider est implémenté par chaque prestataire de paiement (fake, Stripe, ...).
+// Une opération renvoyée avec la même clé d'idempotence n'est exécutée qu'une fois.
+type PaymentProvider interface {
+	Name() string
+	Authorize(ctx context.Context, req AuthorizeRequest) (ProviderResult, error)
+	Capture(ctx context.Context, reference string, amount float64, idempotencyKey string) (ProviderResult, error)
+	Void(ctx context.Context, reference string, idempotencyKey string) (ProviderResult, error)
+	Refund(ctx context.Context, reference string, amount float64, idempotencyKey string) (ProviderResult, error)
+}
+
+func newPaymentProvider() (PaymentProvider, error) {
+	switch name := cfg.PaymentProvider; name {
+	case "fake":
+		return newFakeProvider(), nil
+	case "stripe":
+		return newStripeProvider(cfg.StripeAPIURL, cfg.StripeSecretKey), nil
+	default:
+		return nil, fmt.Errorf("unknown payment provider %q", name)
+	}
+}
+
+// toMinorUnits convertit un montant en centimes pour éviter les erreurs d'arrondi
+func toMinorUnits(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+)
+
+// Moyens de paiement reconnus par le prestataire factice pour simuler des refus
+const (
+	fakeDeclinedPaymentMethod = "fake_card_declined"
+	fakeFailingPaymentMethod  = "fake_card_error"
+)
+
+// Barème de frais simulé : 1,4 % + 0,25 par encaissement
+const (
+	fakeFeeRate       = 0.014
+	fakeFixedFeeCents = 25
+)
+
+type fakeCharge struct {
+	authorized int64
+	captured   int64
+	refunded   int64
+	status     string
+}
+
+type fakeResult struct {
+	result ProviderResult
+	err    error
+}
+
+// fakeProvider est un prestataire en mémoire pour le développement et les tests
+type fakeProvider struct {
+	mu          sync.Mutex
+	seq         int
+	charges     map[string]*fakeCharge
+	idempotency map[string]string
+	// Résultats des captures, annulations et remboursements par clé d'idempotence
+	results map[string]fakeResult
+}
+
+func newFakeProvider() *fakeProvider {
+	return &fakeProvider{
+		charges:     make(map[string]*fakeCharge),
+		idempotency: make(map[string]string),
+		results:     make(map[string]fakeResult),
+	}
+}
+
+// once exécute l'opération une seule fois par clé d'idempotence et rejoue ensuite son résultat
+func (p *fakeProvider) once(idempotencyKey string, operation func() (ProviderResult, error)) (ProviderResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if previous, ok := p.results[idempotencyKey]; ok {
+		return previous.result, previous.err
+	}
+	result, err := operation()
+	if idempotencyKey != "" {
+		p.results[idempotencyKey] = fakeResult{result: result, err: err}
+	}
+	return result, err
+}
+
+func (p *fakeProvider) Name() string {
+	return "fake"
+}
+
+func (p *fakeProvider) Authorize(ctx context.Context, req AuthorizeRequest) (ProviderResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if req.IdempotencyKey != "" {
+		if ref, ok := p.idempotency[req.IdempotencyKey]; ok {
+			return ProviderResult{Reference: ref, Status: p.charges[ref].status}, nil
+		}
+	}
+	if req.Amount <= 0 {
+		return ProviderResult{}, ErrInvalidAmount
+	}
+	switch req.PaymentMethod {
+	case fakeDeclinedPaymentMethod:
+		return ProviderResult{Status: PaymentStatusDeclined}, ErrPaymentDeclined
+	case fakeFailingPaymentMethod:
+		return ProviderResult{Status: PaymentStatusFailed}, fmt.Errorf("fake provider: processing error")
+	}
+
+	p.seq++
+	ref := fmt.Sprintf("fake_%d", p.seq)
+	p.charges[ref] = &fakeCharge{authorized: toMinorUnits(req.Amount), status: PaymentStatusAuthorized}
+	if req.IdempotencyKey != "" {
+		p.idempotency[req.IdempotencyKey] = ref
+	}
+	return ProviderResult{Reference: ref, Status: PaymentStatusAuthorized}, nil
+}
+
+func (p *fakeProvider) Capture(ctx context.Context, reference string, amount float64, idempotencyKey string) (ProviderResult, error) {
+	return p.once(idempotencyKey, func() (ProviderResult, error) { return p.capture(reference, amount) })
+}
+
+func (p *fakeProvider) capture(reference string, amount float64) (ProviderResult, error) {
+	charge, ok := p.charges[reference]
+	if !ok {
+		return ProviderResult{}, ErrUnknownPayment
+	}
+	if charge.status != PaymentStatusAuthorized {
+		return ProviderResult{}, ErrInvalidState
+	}
+	cents := toMinorUnits(amount)
+	if cents <= 0 || cents > charge.authorized {
+		return ProviderResult{}, ErrInvalidAmount
+	}
+	charge.captured = cents
+	charge.status = PaymentStatusCaptured
+	fee := int64(math.Round(float64(cents)*fakeFeeRate)) + fakeFixedFeeCents
+	return ProviderResult{Reference: reference, Status: charge.status, Fee: float64(fee) / 100}, nil
+}
+
+func (p *fakeProvider) Void(ctx context.Context, reference string, idempotencyKey string) (ProviderResult, error) {
+	return p.once(idempotencyKey, func() (ProviderResult, error) { return p.void(reference) })
+}
+
+func (p *fakeProvider) void(reference string) (ProviderResult, error) {
+	charge, ok := p.charges[reference]
+	if !ok {
+		return ProviderResult{}, ErrUnknownPayment
+	}
+	if charge.status != PaymentStatusAuthorized {
+		return ProviderResult{}, ErrInvalidState
+	}
+	charge.status = PaymentStatusVoided
+	return ProviderResult{Reference: reference, Status: charge.status}, nil
+}
+
+func (p *fakeProvider) Refund(ctx context.Context, reference string, amount float64, idempotencyKey string) (ProviderResult, error) {
+	return p.once(idempotencyKey, func() (ProviderResult, error) { return p.refund(reference, amount) })
+}
+
+func (p *fakeProvider) refund(reference string, amount float64) (ProviderResult, error) {
+	charge, ok := p.charges[reference]
+	if !ok {
+		return ProviderResult{}, ErrUnknownPayment
+	}
+	if charge.status != PaymentStatusCaptured && charge.status != PaymentStatusRefunded {
+		return ProviderResult{}, ErrInvalidState
+	}
+	cents := toMinorUnits(amount)
+	if cents <= 0 || charge.refunded+cents > charge.captured {
+		return ProviderResult{}, ErrInvalidAmount
+	}
+	charge.refunded += cents
+	if charge.refunded == charge.captured {
+		charge.status = PaymentStatusRefunded
+	}
+	p.seq++
+	return ProviderResult{Reference: fmt.Sprintf("fake_re_%d", p.seq), Status: PaymentStatusRefunded}, nil
+}
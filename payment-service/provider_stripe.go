@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"shared/tracing"
+)
+
+// stripeProvider pilote l'API PaymentIntents de Stripe (ou une API compatible)
+type stripeProvider struct {
+	apiURL    string
+	secretKey string
+	client    *http.Client
+}
+
+type stripeObject struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	// Objet ou simple identifiant selon que le champ a été étendu
+	LatestCharge json.RawMessage `json:"latest_charge"`
+	Error        *struct {
+		Type    string `json:"type"`
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func newStripeProvider(apiURL, secretKey string) *stripeProvider {
+	return &stripeProvider{
+		apiURL:    strings.TrimRight(apiURL, "/"),
+		secretKey: secretKey,
+		client:    tracing.Client(10 * time.Second),
+	}
+}
+
+func (p *stripeProvider) Name() string {
+	return "stripe"
+}
+
+func (p *stripeProvider) Authorize(ctx context.Context, req AuthorizeRequest) (ProviderResult, error) {
+	form := url.Values{}
+	form.Set("amount", strconv.FormatInt(toMinorUnits(req.Amount), 10))
+	form.Set("currency", strings.ToLower(req.Currency))
+	form.Set("payment_method", req.PaymentMethod)
+	form.Set("confirm", "true")
+	form.Set("capture_method", "manual")
+	form.Set("metadata[order_id]", req.OrderID)
+
+	obj, err := p.post(ctx, "/v1/payment_intents", form, req.IdempotencyKey)
+	return ProviderResult{Reference: obj.ID, Status: stripePaymentIntentStatus(obj.Status)}, err
+}
+
+func (p *stripeProvider) Capture(ctx context.Context, reference string, amount float64, idempotencyKey string) (ProviderResult, error) {
+	form := url.Values{}
+	form.Set("amount_to_capture", strconv.FormatInt(toMinorUnits(amount), 10))
+	form.Set("expand[]", "latest_charge.balance_transaction")
+
+	obj, err := p.post(ctx, "/v1/payment_intents/"+url.PathEscape(reference)+"/capture", form, idempotencyKey)
+	if err != nil {
+		return ProviderResult{}, err
+	}
+	var charge struct {
+		BalanceTransaction struct {
+			Fee int64 `json:"fee"`
+		} `json:"balance_transaction"`
+	}
+	if len(obj.LatestCharge) > 0 && obj.LatestCharge[0] == '{' {
+		if err := json.Unmarshal(obj.LatestCharge, &charge); err != nil {
+			return ProviderResult{}, fmt.Errorf("stripe: invalid charge: %w", err)
+		}
+	}
+	return ProviderResult{
+		Reference: obj.ID,
+		Status:    stripePaymentIntentStatus(obj.Status),
+		Fee:       float64(charge.BalanceTransaction.Fee) / 100,
+	}, nil
+}
+
+func (p *stripeProvider) Void(ctx context.Context, reference string, idempotencyKey string) (ProviderResult, error) {
+	obj, err := p.post(ctx, "/v1/payment_intents/"+url.PathEscape(reference)+"/cancel", url.Values{}, idempotencyKey)
+	if err != nil {
+		return ProviderResult{}, err
+	}
+	return ProviderResult{Reference: obj.ID, Status: stripePaymentIntentStatus(obj.Status)}, nil
+}
+
+func (p *stripeProvider) Refund(ctx context.Context, reference string, amount float64, idempotencyKey string) (ProviderResult, error) {
+	form := url.Values{}
+	form.Set("payment_intent", reference)
+	form.Set("amount", strconv.FormatInt(toMinorUnits(amount), 10))
+
+	obj, err := p.post(ctx, "/v1/refunds", form, idempotencyKey)
+	if err != nil {
+		return ProviderResult{}, err
+	}
+	status := PaymentStatusPending
+	switch obj.Status {
+	case "succeeded":
+		status = PaymentStatusRefunded
+	case "failed", "canceled":
+		status = PaymentStatusFailed
+	}
+	return ProviderResult{Reference: obj.ID, Status: status}, nil
+}
+
+func (p *stripeProvider) post(ctx context.Context, path string, form url.Values, idempotencyKey string) (stripeObject, error) {
+	var obj stripeObject
+	req, err := http.NewRequestWithContext(ctx, "POST", p.apiURL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return obj, err
+	}
+	req.Header.Set("Authorization", "Bearer "+p.secretKey)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return obj, fmt.Errorf("stripe: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(&obj); err != nil {
+		return obj, fmt.Errorf("stripe: invalid response (status %d): %w", resp.StatusCode, err)
+	}
+	if obj.Error != nil {
+		if obj.Error.Type == "card_error" {
+			return obj, fmt.Errorf("%w: %s", ErrPaymentDeclined, obj.Error.Message)
+		}
+		if obj.Error.Code == "payment_intent_unexpected_state" {
+			return obj, fmt.Errorf("%w: %s", ErrInvalidState, obj.Error.Message)
+		}
+		return obj, fmt.Errorf("stripe: %s", obj.Error.Message)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return obj, fmt.Errorf("stripe: unexpected status %d", resp.StatusCode)
+	}
+	return obj, nil
+}
+
+func stripePaymentIntentStatus(status string) string {
+	switch status {
+	case "requires_capture":
+		return PaymentStatusAuthorized
+	case "succeeded":
+		return PaymentStatusCaptured
+	case "canceled":
+		return PaymentStatusVoided
+	case "requires_payment_method":
+		return PaymentStatusDeclined
+	case "":
+		return PaymentStatusFailed
+	default:
+		return PaymentStatusPending
+	}
+}
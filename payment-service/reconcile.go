@@ -0,0 +1,350 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"shared/logging"
+	"shared/web"
+)
+
+// Résultats possibles du rapprochement d'une ligne
+const (
+	ReconciliationMatched             = "matched"
+	ReconciliationMismatched          = "mismatched"
+	ReconciliationMissingInSystem     = "missing_in_system"
+	ReconciliationMissingInSettlement = "missing_in_settlement"
+)
+
+// ReconciliationReport résume le rapprochement d'un relevé de règlement du prestataire
+type ReconciliationReport struct {
+	ID         uint                 `gorm:"primaryKey;autoIncrement" json:"id"`
+	FileName   string               `json:"file_name"`
+	Provider   string               `json:"provider"`
+	Matched    int                  `json:"matched"`
+	Mismatched int                  `json:"mismatched"`
+	Missing    int                  `json:"missing"`
+	CreatedAt  time.Time            `json:"created_at"`
+	Items      []ReconciliationItem `gorm:"foreignKey:ReportID" json:"items,omitempty"`
+}
+
+type ReconciliationItem struct {
+	ID             uint    `gorm:"primaryKey;autoIncrement" json:"id"`
+	ReportID       uint    `gorm:"index" json:"report_id"`
+	Line           int     `json:"line"`
+	Kind           string  `json:"kind"`
+	Reference      string  `json:"reference"`
+	PaymentID      *uint   `json:"payment_id"`
+	Outcome        string  `json:"outcome"`
+	ExpectedAmount float64 `json:"expected_amount"`
+	SettledAmount  float64 `json:"settled_amount"`
+	Detail         string  `json:"detail"`
+}
+
+type settlementLine struct {
+	line      int
+	kind      string
+	reference string
+	amount    float64
+	currency  string
+}
+
+// Noms de colonnes acceptés pour chaque champ du relevé
+var settlementColumns = map[string][]string{
+	"reference": {"reference", "payment_intent_id", "source_id", "id"},
+	"amount":    {"amount", "gross"},
+	"currency":  {"currency"},
+	"type":      {"type", "reporting_category"},
+}
+
+func parseSettlementFile(r io.Reader) ([]settlementLine, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read settlement header: %w", err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		for field, aliases := range settlementColumns {
+			for _, alias := range aliases {
+				if _, found := columns[field]; !found && name == alias {
+					columns[field] = i
+				}
+			}
+		}
+	}
+	for _, required := range []string{"reference", "amount"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("settlement file has no %s column", required)
+		}
+	}
+
+	var lines []settlementLine
+	for lineNumber := 2; ; lineNumber++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		amount, err := strconv.ParseFloat(strings.TrimSpace(record[columns["amount"]]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid amount: %w", lineNumber, err)
+		}
+		line := settlementLine{
+			line:      lineNumber,
+			kind:      "charge",
+			reference: strings.TrimSpace(record[columns["reference"]]),
+			amount:    math.Abs(amount),
+		}
+		if i, ok := columns["currency"]; ok {
+			line.currency = strings.ToUpper(strings.TrimSpace(record[i]))
+		}
+		if i, ok := columns["type"]; ok {
+			switch strings.ToLower(strings.TrimSpace(record[i])) {
+			case "charge", "payment", "capture":
+			case "refund":
+				line.kind = "refund"
+			default:
+				// Virements, frais et ajustements ne correspondent à aucun paiement
+				continue
+			}
+		}
+		lines = append(lines, line)
+	}
+	return lines, nil
+}
+
+// reconcileSettlement rapproche le relevé des paiements et remboursements enregistrés ;
+// si from et to sont fournis, les encaissements de la période absents du relevé sont signalés
+func reconcileSettlement(r io.Reader, fileName string, from, to *time.Time) (ReconciliationReport, error) {
+	report := ReconciliationReport{FileName: fileName, Provider: provider.Name()}
+	lines, err := parseSettlementFile(r)
+	if err != nil {
+		return report, web.NewError(http.StatusBadRequest, "invalid_settlement_file", err.Error())
+	}
+
+	seen := make(map[string]bool)
+	for _, line := range lines {
+		item := ReconciliationItem{Line: line.line, Kind: line.kind, Reference: line.reference, SettledAmount: line.amount}
+		key := line.kind + ":" + line.reference
+		if seen[key] {
+			item.Outcome = ReconciliationMismatched
+			item.Detail = "duplicate settlement line"
+			report.Items = append(report.Items, item)
+			continue
+		}
+		seen[key] = true
+
+		if line.kind == "refund" {
+			var refund PaymentRefund
+			err := db.Joins("JOIN payments ON payments.id = payment_refunds.payment_id").
+				Where("payments.provider = ? AND payment_refunds.provider_reference = ?", report.Provider, line.reference).
+				First(&refund).Error
+			if err != nil {
+				if !errors.Is(err, gorm.ErrRecordNotFound) {
+					return report, err
+				}
+				item.Outcome = ReconciliationMissingInSystem
+				report.Items = append(report.Items, item)
+				continue
+			}
+			item.PaymentID = &refund.PaymentID
+			item.ExpectedAmount = refund.Amount
+			matchAmount(&item, refund.Status == PaymentStatusFailed)
+			report.Items = append(report.Items, item)
+			continue
+		}
+
+		var payment Payment
+		if err := db.First(&payment, "provider = ? AND provider_reference = ?", report.Provider, line.reference).Error; err != nil {
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				return report, err
+			}
+			item.Outcome = ReconciliationMissingInSystem
+			report.Items = append(report.Items, item)
+			continue
+		}
+		item.PaymentID = &payment.ID
+		item.ExpectedAmount = payment.CapturedAmount
+		matchAmount(&item, toMinorUnits(payment.CapturedAmount) == 0)
+		if item.Outcome == ReconciliationMatched && line.currency != "" && line.currency != strings.ToUpper(payment.Currency) {
+			item.Outcome = ReconciliationMismatched
+			item.Detail = fmt.Sprintf("currency %s settled, %s expected", line.currency, payment.Currency)
+		}
+		if item.Outcome == ReconciliationMatched {
+			var pending int64
+			if err := db.Model(&PaymentCapture{}).Where("payment_id = ? AND fee_pending", payment.ID).Count(&pending).Error; err != nil {
+				return report, err
+			}
+			if pending > 0 {
+				item.Detail = "provider fee not recorded in the ledger"
+			}
+		}
+		report.Items = append(report.Items, item)
+	}
+
+	if from != nil && to != nil {
+		var captured []Payment
+		if err := db.Joins("JOIN payment_captures ON payment_captures.payment_id = payments.id").
+			Where("payments.provider = ? AND payment_captures.status = ? AND payment_captures.created_at BETWEEN ? AND ?",
+				report.Provider, PaymentStatusCaptured, *from, *to).
+			Find(&captured).Error; err != nil {
+			return report, err
+		}
+		for _, payment := range captured {
+			if seen["charge:"+payment.ProviderReference] {
+				continue
+			}
+			paymentID := payment.ID
+			report.Items = append(report.Items, ReconciliationItem{
+				Kind:           "charge",
+				Reference:      payment.ProviderReference,
+				PaymentID:      &paymentID,
+				Outcome:        ReconciliationMissingInSettlement,
+				ExpectedAmount: payment.CapturedAmount,
+			})
+		}
+	}
+
+	for _, item := range report.Items {
+		switch item.Outcome {
+		case ReconciliationMatched:
+			report.Matched++
+		case ReconciliationMismatched:
+			report.Mismatched++
+		default:
+			report.Missing++
+		}
+	}
+	if err := db.Create(&report).Error; err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+func matchAmount(item *ReconciliationItem, notSettleable bool) {
+	switch {
+	case notSettleable:
+		item.Outcome = ReconciliationMismatched
+		item.Detail = "settled but not captured or refunded in our records"
+	case toMinorUnits(item.SettledAmount) != toMinorUnits(item.ExpectedAmount):
+		item.Outcome = ReconciliationMismatched
+		item.Detail = fmt.Sprintf("amount %.2f settled, %.2f expected", item.SettledAmount, item.ExpectedAmount)
+	default:
+		item.Outcome = ReconciliationMatched
+	}
+}
+
+func getReconciliations(w http.ResponseWriter, r *http.Request) {
+	var reports []ReconciliationReport
+	if err := db.Order("id DESC").Find(&reports).Error; err != nil {
+		web.WriteError(w, err, "Reconciliation")
+		return
+	}
+	json.NewEncoder(w).Encode(reports)
+}
+
+func reconciliationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		web.Error(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+	id := r.URL.Path[len("/admin/reconciliations/"):]
+	outcome := r.URL.Query().Get("outcome")
+	items := func(tx *gorm.DB) *gorm.DB {
+		if outcome != "" {
+			tx = tx.Where("outcome = ?", outcome)
+		}
+		return tx.Order("id")
+	}
+	var report ReconciliationReport
+	if err := db.Preload("Items", items).First(&report, "id = ?", id).Error; err != nil {
+		web.Error(w, http.StatusNotFound, "not_found", "Reconciliation not found")
+		return
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+// createReconciliation lit le relevé CSV envoyé dans le corps de la requête
+func createReconciliation(w http.ResponseWriter, r *http.Request) {
+	from, to, err := parsePeriod(r.URL.Query().Get("from"), r.URL.Query().Get("to"))
+	if err != nil {
+		web.Error(w, http.StatusBadRequest, "invalid_period", err.Error())
+		return
+	}
+	fileName := r.URL.Query().Get("file_name")
+	if fileName == "" {
+		fileName = "upload.csv"
+	}
+	report, err := reconcileSettlement(io.LimitReader(r.Body, 50<<20), fileName, from, to)
+	if err != nil {
+		web.WriteError(w, err, "Reconciliation")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(report)
+}
+
+func parsePeriod(fromValue, toValue string) (*time.Time, *time.Time, error) {
+	if fromValue == "" && toValue == "" {
+		return nil, nil, nil
+	}
+	from, err := time.Parse("2006-01-02", fromValue)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid from date: %w", err)
+	}
+	to, err := time.Parse("2006-01-02", toValue)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid to date: %w", err)
+	}
+	// La date de fin est incluse
+	to = to.Add(24*time.Hour - time.Nanosecond)
+	return &from, &to, nil
+}
+
+// runReconcileCommand implémente `payment-service reconcile [-from date -to date] fichier.csv`
+func runReconcileCommand(args []string) {
+	flags := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	fromValue := flags.String("from", "", "start of the settlement period (YYYY-MM-DD)")
+	toValue := flags.String("to", "", "end of the settlement period (YYYY-MM-DD)")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		logging.Fatal("usage: payment-service reconcile [-from YYYY-MM-DD -to YYYY-MM-DD] settlement.csv")
+	}
+	from, to, err := parsePeriod(*fromValue, *toValue)
+	if err != nil {
+		logging.Fatal("invalid reconcile arguments", "error", err)
+	}
+
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		logging.Fatal("failed to open settlement file", "error", err)
+	}
+	defer file.Close()
+
+	report, err := reconcileSettlement(file, flags.Arg(0), from, to)
+	if err != nil {
+		logging.Fatal("reconciliation failed", "error", err)
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(report)
+	slog.Info("reconciliation finished", "report_id", report.ID, "matched", report.Matched, "mismatched", report.Mismatched, "missing", report.Missing)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseSettlementFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		want    []settlementLine
+		wantErr string
+	}{
+		{
+			name: "charges and refunds",
+			file: "reference,amount,currency,type\npi_1,12.50,eur,charge\nre_1,-2.5,EUR,refund\n",
+			want: []settlementLine{
+				{line: 2, kind: "charge", reference: "pi_1", amount: 12.5, currency: "EUR"},
+				{line: 3, kind: "refund", reference: "re_1", amount: 2.5, currency: "EUR"},
+			},
+		},
+		{
+			name: "stripe column names",
+			file: "id,Gross,reporting_category\npi_1,10,payment\n",
+			want: []settlementLine{{line: 2, kind: "charge", reference: "pi_1", amount: 10}},
+		},
+		{
+			name: "payouts and fees skipped",
+			file: "reference,amount,type\npo_1,100,payout\nfee_1,0.25,fee\npi_1,5,capture\n",
+			want: []settlementLine{{line: 4, kind: "charge", reference: "pi_1", amount: 5}},
+		},
+		{
+			name: "no type column",
+			file: "reference, amount\n pi_1 , 7.1 \n",
+			want: []settlementLine{{line: 2, kind: "charge", reference: "pi_1", amount: 7.1}},
+		},
+		{name: "empty file", file: "", wantErr: "failed to read settlement header"},
+		{name: "missing amount column", file: "reference,currency\npi_1,EUR\n", wantErr: "no amount column"},
+		{name: "invalid amount", file: "reference,amount\npi_1,abc\n", wantErr: "line 2: invalid amount"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSettlementFile(strings.NewReader(tt.file))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("lines = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchAmount(t *testing.T) {
+	tests := []struct {
+		name          string
+		settled       float64
+		expected      float64
+		notSettleable bool
+		want          string
+	}{
+		{name: "same amount", settled: 12.5, expected: 12.5, want: ReconciliationMatched},
+		{name: "rounding noise", settled: 0.1 + 0.2, expected: 0.3, want: ReconciliationMatched},
+		{name: "one cent off", settled: 12.51, expected: 12.5, want: ReconciliationMismatched},
+		{name: "not captured", settled: 12.5, expected: 12.5, notSettleable: true, want: ReconciliationMismatched},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			item := ReconciliationItem{SettledAmount: tt.settled, ExpectedAmount: tt.expected}
+			matchAmount(&item, tt.notSettleable)
+			if item.Outcome != tt.want {
+				t.Errorf("outcome = %q (%s), want %q", item.Outcome, item.Detail, tt.want)
+			}
+		})
+	}
+}
+
+// Un remboursement d'un autre prestataire portant la même référence n'est pas rapproché
+func TestReconcileSettlementRefundProvider(t *testing.T) {
+	database := testDB(t)
+	if err := database.AutoMigrate(&ReconciliationReport{}, &ReconciliationItem{}); err != nil {
+		t.Fatal(err)
+	}
+	previous := provider
+	provider = newFakeProvider()
+	t.Cleanup(func() { provider = previous })
+
+	stripe := Payment{OrderID: "1", Amount: 10, Currency: "EUR", Status: PaymentStatusRefunded, Provider: "stripe", ProviderReference: "pi_1", CapturedAmount: 10}
+	fake := Payment{OrderID: "2", Amount: 10, Currency: "EUR", Status: PaymentStatusRefunded, Provider: "fake", ProviderReference: "fake_pi_2", CapturedAmount: 10}
+	for _, payment := range []*Payment{&stripe, &fake} {
+		if err := database.Create(payment).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	database.Create(&PaymentRefund{PaymentID: stripe.ID, Amount: 10, Status: PaymentStatusRefunded, ProviderReference: "re_1"})
+	database.Create(&PaymentRefund{PaymentID: fake.ID, Amount: 10, Status: PaymentStatusRefunded, ProviderReference: "re_2"})
+
+	report, err := reconcileSettlement(strings.NewReader("reference,amount,type\nre_1,10,refund\nre_2,10,refund\n"), "settlement.csv", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{report.Items[0].Outcome, report.Items[1].Outcome}
+	want := []string{ReconciliationMissingInSystem, ReconciliationMatched}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("outcomes = %v, want %v", got, want)
+	}
+	if report.Items[1].PaymentID == nil || *report.Items[1].PaymentID != fake.ID {
+		t.Errorf("payment = %v, want %d", report.Items[1].PaymentID, fake.ID)
+	}
+}
+
+func TestReconciliationHandler(t *testing.T) {
+	database := testDB(t)
+	if err := database.AutoMigrate(&ReconciliationReport{}, &ReconciliationItem{}); err != nil {
+		t.Fatal(err)
+	}
+	report := ReconciliationReport{FileName: "settlement.csv", Provider: "fake"}
+	for line, outcome := range []string{ReconciliationMatched, ReconciliationMismatched, ReconciliationMatched, ReconciliationMissingInSystem, ReconciliationMatched} {
+		report.Items = append(report.Items, ReconciliationItem{Line: line + 2, Outcome: outcome})
+	}
+	if err := database.Create(&report).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		query string
+		want  []int
+	}{
+		{query: "", want: []int{2, 3, 4, 5, 6}},
+		{query: "?outcome=matched", want: []int{2, 4, 6}},
+		{query: "?outcome=mismatched", want: []int{3}},
+		{query: "?outcome=missing_in_settlement"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			reconciliationHandler(rec, httptest.NewRequest("GET", "/admin/reconciliations/1"+tt.query, nil))
+			var got ReconciliationReport
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			var lines []int
+			for _, item := range got.Items {
+				lines = append(lines, item.Line)
+			}
+			if !reflect.DeepEqual(lines, tt.want) {
+				t.Errorf("lines = %v, want %v", lines, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"shared/web"
+)
+
+const (
+	PaymentStatusDisputed    = "disputed"
+	PaymentStatusChargedBack = "charged_back"
+)
+
+// Types d'événements normalisés, indépendants du prestataire
+const (
+	EventPaymentAuthorized = "payment.authorized"
+	EventPaymentFailed     = "payment.failed"
+	EventPaymentCaptured   = "payment.captured"
+	EventPaymentVoided     = "payment.voided"
+	EventRefundSucceeded   = "refund.succeeded"
+	EventRefundFailed      = "refund.failed"
+	EventDisputeOpened     = "dispute.opened"
+	EventDisputeWon        = "dispute.won"
+	EventDisputeLost       = "dispute.lost"
+)
+
+const stripeSignatureTolerance = 5 * time.Minute
+
+var (
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+)
+
+// ProviderEvent est un événement de prestataire vérifié et normalisé
+type ProviderEvent struct {
+	ID              string  `json:"id"`
+	Type            string  `json:"type"`
+	Reference       string  `json:"reference"`
+	RefundReference string  `json:"refund_reference"`
+	Amount          float64 `json:"amount"`
+	// Frais de l'encaissement, nil si le prestataire ne les indique pas dans l'événement
+	Fee *float64 `json:"fee"`
+}
+
+// WebhookEvent mémorise les événements déjà traités pour ignorer les renvois
+type WebhookEvent struct {
+	ID         uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	Provider   string    `gorm:"uniqueIndex:idx_webhook_provider_event" json:"provider"`
+	EventID    string    `gorm:"uniqueIndex:idx_webhook_provider_event" json:"event_id"`
+	Type       string    `json:"type"`
+	Reference  string    `json:"reference"`
+	PaymentID  *uint     `json:"payment_id"`
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+type webhookParser func(r *http.Request, body []byte, secret string) (ProviderEvent, error)
+
+var webhookParsers = map[string]webhookParser{
+	"fake":   parseFakeWebhook,
+	"stripe": parseStripeWebhook,
+}
+
+func webhookHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		web.Error(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+	providerName := r.URL.Path[len("/webhooks/"):]
+	parse, ok := webhookParsers[providerName]
+	if !ok {
+		web.Error(w, http.StatusNotFound, "unknown_provider", "Unknown provider")
+		return
+	}
+	secret := cfg.webhookSecret(providerName)
+	if secret == "" {
+		slog.ErrorContext(r.Context(), "webhook secret is not configured", "provider", providerName)
+		web.Error(w, http.StatusInternalServerError, "webhook_not_configured", "Webhook not configured")
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		web.Error(w, http.StatusBadRequest, "invalid_body", "Could not read request body")
+		return
+	}
+	event, err := parse(r, body, secret)
+	if err != nil {
+		web.Error(w, http.StatusBadRequest, "invalid_webhook", err.Error())
+		return
+	}
+
+	duplicate := false
+	var updated *Payment
+	err = db.Transaction(func(tx *gorm.DB) error {
+		record := WebhookEvent{Provider: providerName, EventID: event.ID, Type: event.Type, Reference: event.Reference, ReceivedAt: time.Now()}
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&record)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			duplicate = true
+			return nil
+		}
+		payment, err := applyProviderEvent(tx, providerName, event)
+		if err != nil || payment == nil {
+			return err
+		}
+		if err := events.Enqueue(tx, paymentEvent(*payment)); err != nil {
+			return err
+		}
+		updated = payment
+		return tx.Model(&record).Update("payment_id", payment.ID).Error
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to process webhook", "provider", providerName, "event_id", event.ID, "error", err)
+		web.Error(w, http.StatusInternalServerError, "internal_error", "Failed to process event")
+		return
+	}
+	if duplicate {
+		slog.InfoContext(r.Context(), "ignoring duplicate webhook", "provider", providerName, "event_id", event.ID)
+	}
+	if updated != nil {
+		paymentsByStatus.WithLabelValues(updated.Status).Inc()
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// applyProviderEvent applique l'événement au paiement concerné, qui est renvoyé s'il a changé de statut
+func applyProviderEvent(tx *gorm.DB, providerName string, event ProviderEvent) (*Payment, error) {
+	var payment Payment
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		First(&payment, "provider = ? AND provider_reference = ?", providerName, event.Reference).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		slog.Warn("no payment for provider reference, ignoring event", "provider", providerName, "reference", event.Reference, "event_type", event.Type)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	previous := payment.Status
+	updates := map[string]interface{}{}
+	switch event.Type {
+	case EventPaymentAuthorized:
+		if payment.Status == PaymentStatusPending {
+			updates["status"] = PaymentStatusAuthorized
+		}
+	case EventPaymentFailed:
+		if payment.Status == PaymentStatusPending || payment.Status == PaymentStatusAuthorized {
+			updates["status"] = PaymentStatusFailed
+		}
+	case EventPaymentVoided:
+		if payment.Status == PaymentStatusPending || payment.Status == PaymentStatusAuthorized {
+			updates["status"] = PaymentStatusVoided
+		}
+	case EventPaymentCaptured:
+		if payment.Status == PaymentStatusPending || payment.Status == PaymentStatusAuthorized {
+			amount := event.Amount
+			if amount == 0 {
+				amount = payment.Amount
+			}
+			capture := PaymentCapture{PaymentID: payment.ID, Amount: amount, Status: PaymentStatusCaptured, ProviderReference: event.Reference}
+			if event.Fee != nil {
+				capture.Fee = *event.Fee
+			} else {
+				// L'écriture est passée sans frais ; le rapprochement signale la capture
+				capture.FeePending = true
+				slog.Warn("captured without provider fee, flagged for reconciliation", "provider", providerName, "payment_id", payment.ID)
+			}
+			if err := tx.Create(&capture).Error; err != nil {
+				return nil, err
+			}
+			if err := postCaptureEntry(tx, payment, event.Reference, amount, capture.Fee); err != nil {
+				return nil, err
+			}
+			updates["status"] = PaymentStatusCaptured
+			updates["captured_amount"] = amount
+		}
+	case EventRefundSucceeded, EventRefundFailed:
+		var refund PaymentRefund
+		if err := tx.First(&refund, "payment_id = ? AND provider_reference = ?", payment.ID, event.RefundReference).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				slog.Warn("no matching refund, ignoring event", "refund_reference", event.RefundReference, "payment_id", payment.ID, "event_type", event.Type)
+				return nil, nil
+			}
+			return nil, err
+		}
+		if refund.Status != PaymentStatusPending {
+			break
+		}
+		if event.Type == EventRefundSucceeded {
+			if err := tx.Model(&refund).Update("status", PaymentStatusRefunded).Error; err != nil {
+				return nil, err
+			}
+			break
+		}
+		if err := tx.Model(&refund).Update("status", PaymentStatusFailed).Error; err != nil {
+			return nil, err
+		}
+		if err := postRefundReversalEntry(tx, payment, refund.ProviderReference, refund.Amount); err != nil {
+			return nil, err
+		}
+		// Le montant réservé par ce remboursement redevient remboursable
+		refunded := payment.RefundedAmount - refund.Amount
+		updates["refunded_amount"] = refunded
+		if toMinorUnits(refunded) == 0 {
+			updates["status"] = PaymentStatusCaptured
+		} else {
+			updates["status"] = PaymentStatusPartiallyRefunded
+		}
+	case EventDisputeOpened:
+		// Seul un paiement encaissé peut être contesté
+		switch payment.Status {
+		case PaymentStatusCaptured, PaymentStatusPartiallyRefunded, PaymentStatusRefunded:
+			updates["status"] = PaymentStatusDisputed
+		default:
+			slog.Warn("dispute on a payment that was not captured, ignoring event", "payment_id", payment.ID, "status", payment.Status)
+		}
+	case EventDisputeWon:
+		if payment.Status == PaymentStatusDisputed {
+			updates["status"] = PaymentStatusCaptured
+			if toMinorUnits(payment.RefundedAmount) > 0 {
+				updates["status"] = PaymentStatusPartiallyRefunded
+			}
+		}
+	case EventDisputeLost:
+		if payment.Status == PaymentStatusChargedBack {
+			break
+		}
+		// Le prestataire reprend au marchand ce qui n'a pas déjà été remboursé
+		if err := postChargebackEntry(tx, payment, event.ID, payment.CapturedAmount-payment.RefundedAmount); err != nil {
+			return nil, err
+		}
+		updates["status"] = PaymentStatusChargedBack
+	default:
+		slog.Warn("ignoring unsupported event", "provider", providerName, "event_type", event.Type)
+		return nil, nil
+	}
+
+	if len(updates) == 0 {
+		return nil, nil
+	}
+	if err := tx.Model(&payment).Updates(updates).Error; err != nil {
+		return nil, err
+	}
+	status, ok := updates["status"].(string)
+	if !ok || status == previous {
+		return nil, nil
+	}
+	switch event.Type {
+	case EventPaymentAuthorized, EventPaymentFailed, EventPaymentVoided:
+		if err := tx.Model(&PaymentAuthorization{}).Where("payment_id = ?", payment.ID).Update("status", status).Error; err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.First(&payment, payment.ID).Error; err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
+
+func verifyHMAC(payload []byte, secret string, signature string) bool {
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// parseFakeWebhook lit les événements du prestataire factice, déjà au format normalisé
+func parseFakeWebhook(r *http.Request, body []byte, secret string) (ProviderEvent, error) {
+	var event ProviderEvent
+	if !verifyHMAC(body, secret, r.Header.Get("X-Fake-Signature")) {
+		return event, ErrInvalidSignature
+	}
+	if err := json.Unmarshal(body, &event); err != nil {
+		return event, err
+	}
+	if event.ID == "" || event.Reference == "" {
+		return event, errors.New("event id and reference are required")
+	}
+	return event, nil
+}
+
+// parseStripeWebhook vérifie l'en-tête Stripe-Signature puis traduit l'événement Stripe
+func parseStripeWebhook(r *http.Request, body []byte, secret string) (ProviderEvent, error) {
+	var event ProviderEvent
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(r.Header.Get("Stripe-Signature"), ",") {
+		key, value, _ := strings.Cut(part, "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return event, ErrInvalidSignature
+	}
+	if age := time.Since(time.Unix(ts, 0)); age > stripeSignatureTolerance || age < -stripeSignatureTolerance {
+		return event, ErrInvalidSignature
+	}
+	payload := append([]byte(timestamp+"."), body...)
+	valid := false
+	for _, signature := range signatures {
+		if verifyHMAC(payload, secret, signature) {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return event, ErrInvalidSignature
+	}
+
+	var stripeEvent struct {
+		ID   string `json:"id"`
+		Type string `json:"type"`
+		Data struct {
+			Object struct {
+				ID             string `json:"id"`
+				Status         string `json:"status"`
+				PaymentIntent  string `json:"payment_intent"`
+				AmountReceived int64  `json:"amount_received"`
+			} `json:"object"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &stripeEvent); err != nil {
+		return event, err
+	}
+	object := stripeEvent.Data.Object
+	event.ID = stripeEvent.ID
+	event.Reference = object.ID
+	switch stripeEvent.Type {
+	case "payment_intent.amount_capturable_updated":
+		event.Type = EventPaymentAuthorized
+	case "payment_intent.payment_failed":
+		event.Type = EventPaymentFailed
+	case "payment_intent.canceled":
+		event.Type = EventPaymentVoided
+	case "payment_intent.succeeded":
+		event.Type = EventPaymentCaptured
+		event.Amount = float64(object.AmountReceived) / 100
+	case "refund.updated", "charge.refund.updated":
+		event.Reference = object.PaymentIntent
+		event.RefundReference = object.ID
+		switch object.Status {
+		case "succeeded":
+			event.Type = EventRefundSucceeded
+		case "failed", "canceled":
+			event.Type = EventRefundFailed
+		}
+	case "charge.dispute.created":
+		event.Reference = object.PaymentIntent
+		event.Type = EventDisputeOpened
+	case "charge.dispute.closed":
+		event.Reference = object.PaymentIntent
+		switch object.Status {
+		case "won":
+			event.Type = EventDisputeWon
+		case "lost":
+			event.Type = EventDisputeLost
+		}
+	}
+	if event.Type == "" {
+		event.Type = stripeEvent.Type
+	}
+	return event, nil
+}
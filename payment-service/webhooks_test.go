@@ -0,0 +1,241 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testWebhookSecret = "whsec_test"
+
+func sign(secret, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyHMAC(t *testing.T) {
+	payload := []byte(`{"id":"evt_1"}`)
+	tests := []struct {
+		name      string
+		secret    string
+		signature string
+		want      bool
+	}{
+		{name: "valid signature", secret: testWebhookSecret, signature: sign(testWebhookSecret, string(payload)), want: true},
+		{name: "other secret", secret: "other", signature: sign(testWebhookSecret, string(payload)), want: false},
+		{name: "other payload", secret: testWebhookSecret, signature: sign(testWebhookSecret, `{"id":"evt_2"}`), want: false},
+		{name: "uppercase hex", secret: testWebhookSecret, signature: strings.ToUpper(sign(testWebhookSecret, string(payload))), want: true},
+		{name: "truncated signature", secret: testWebhookSecret, signature: sign(testWebhookSecret, string(payload))[:32], want: false},
+		{name: "not hex", secret: testWebhookSecret, signature: "not-a-signature", want: false},
+		{name: "missing signature", secret: testWebhookSecret, signature: "", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := verifyHMAC(payload, tt.secret, tt.signature); got != tt.want {
+				t.Errorf("verifyHMAC() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFakeWebhook(t *testing.T) {
+	body := `{"id":"evt_1","type":"payment.captured","reference":"fake_pi_1","amount":12.5}`
+	feeBody := `{"id":"evt_1","type":"payment.captured","reference":"fake_pi_1","amount":12.5,"fee":0.43}`
+	fee := 0.43
+	tests := []struct {
+		name      string
+		body      string
+		signature string
+		want      ProviderEvent
+		wantErr   error
+	}{
+		{
+			name:      "valid event",
+			body:      body,
+			signature: sign(testWebhookSecret, body),
+			want:      ProviderEvent{ID: "evt_1", Type: EventPaymentCaptured, Reference: "fake_pi_1", Amount: 12.5},
+		},
+		{
+			name:      "fee",
+			body:      feeBody,
+			signature: sign(testWebhookSecret, feeBody),
+			want:      ProviderEvent{ID: "evt_1", Type: EventPaymentCaptured, Reference: "fake_pi_1", Amount: 12.5, Fee: &fee},
+		},
+		{
+			name:      "invalid signature",
+			body:      body,
+			signature: sign("other", body),
+			wantErr:   ErrInvalidSignature,
+		},
+		{
+			name:      "body changed after signing",
+			body:      strings.Replace(body, "12.5", "1250", 1),
+			signature: sign(testWebhookSecret, body),
+			wantErr:   ErrInvalidSignature,
+		},
+		{
+			name:      "missing reference",
+			body:      `{"id":"evt_1","type":"payment.captured"}`,
+			signature: sign(testWebhookSecret, `{"id":"evt_1","type":"payment.captured"}`),
+			wantErr:   errors.New("event id and reference are required"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/webhooks/fake", strings.NewReader(tt.body))
+			req.Header.Set("X-Fake-Signature", tt.signature)
+			event, err := parseFakeWebhook(req, []byte(tt.body), testWebhookSecret)
+			checkWebhookResult(t, event, err, tt.want, tt.wantErr)
+		})
+	}
+}
+
+func TestParseStripeWebhook(t *testing.T) {
+	body := `{"id":"evt_1","type":"payment_intent.succeeded","data":{"object":{"id":"pi_1","amount_received":1250}}}`
+	now := time.Now().Unix()
+	header := func(timestamp int64, signatures ...string) string {
+		parts := []string{fmt.Sprintf("t=%d", timestamp)}
+		for _, signature := range signatures {
+			parts = append(parts, "v1="+signature)
+		}
+		return strings.Join(parts, ",")
+	}
+	signed := func(timestamp int64) string {
+		return sign(testWebhookSecret, fmt.Sprintf("%d.%s", timestamp, body))
+	}
+	captured := ProviderEvent{ID: "evt_1", Type: EventPaymentCaptured, Reference: "pi_1", Amount: 12.5}
+
+	tests := []struct {
+		name    string
+		header  string
+		want    ProviderEvent
+		wantErr error
+	}{
+		{name: "valid signature", header: header(now, signed(now)), want: captured},
+		{name: "one of several signatures", header: header(now, sign("old", body), signed(now)), want: captured},
+		{name: "within tolerance in the past", header: header(now-240, signed(now-240)), want: captured},
+		{name: "within tolerance in the future", header: header(now+240, signed(now+240)), want: captured},
+		{name: "too old", header: header(now-360, signed(now-360)), wantErr: ErrInvalidSignature},
+		{name: "too far in the future", header: header(now+360, signed(now+360)), wantErr: ErrInvalidSignature},
+		{name: "timestamp replaced", header: header(now, signed(now-60)), wantErr: ErrInvalidSignature},
+		{name: "other secret", header: header(now, sign("other", fmt.Sprintf("%d.%s", now, body))), wantErr: ErrInvalidSignature},
+		{name: "no signature", header: header(now), wantErr: ErrInvalidSignature},
+		{name: "no timestamp", header: "v1=" + signed(now), wantErr: ErrInvalidSignature},
+		{name: "empty header", header: "", wantErr: ErrInvalidSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/webhooks/stripe", strings.NewReader(body))
+			req.Header.Set("Stripe-Signature", tt.header)
+			event, err := parseStripeWebhook(req, []byte(body), testWebhookSecret)
+			checkWebhookResult(t, event, err, tt.want, tt.wantErr)
+		})
+	}
+}
+
+func checkWebhookResult(t *testing.T, event ProviderEvent, err error, want ProviderEvent, wantErr error) {
+	t.Helper()
+	if wantErr != nil {
+		if err == nil || (!errors.Is(err, wantErr) && err.Error() != wantErr.Error()) {
+			t.Fatalf("error = %v, want %v", err, wantErr)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("error = %v", err)
+	}
+	if !reflect.DeepEqual(event, want) {
+		t.Errorf("event = %+v, want %+v", event, want)
+	}
+}
+
+// Les transitions refusées laissent le paiement inchangé et ne le renvoient pas
+func TestApplyProviderEvent(t *testing.T) {
+	fee := 0.43
+	tests := []struct {
+		name       string
+		status     string
+		event      ProviderEvent
+		wantStatus string
+		wantFee    *float64
+	}{
+		{name: "authorized", status: PaymentStatusPending, event: ProviderEvent{Type: EventPaymentAuthorized}, wantStatus: PaymentStatusAuthorized},
+		{name: "authorized twice", status: PaymentStatusAuthorized, event: ProviderEvent{Type: EventPaymentAuthorized}},
+		{name: "failed", status: PaymentStatusAuthorized, event: ProviderEvent{Type: EventPaymentFailed}, wantStatus: PaymentStatusFailed},
+		{name: "failed after capture", status: PaymentStatusCaptured, event: ProviderEvent{Type: EventPaymentFailed}},
+		{name: "voided", status: PaymentStatusPending, event: ProviderEvent{Type: EventPaymentVoided}, wantStatus: PaymentStatusVoided},
+		{name: "captured with fee", status: PaymentStatusAuthorized, event: ProviderEvent{Type: EventPaymentCaptured, Amount: 20, Fee: &fee}, wantStatus: PaymentStatusCaptured, wantFee: &fee},
+		{name: "captured without fee", status: PaymentStatusAuthorized, event: ProviderEvent{Type: EventPaymentCaptured}, wantStatus: PaymentStatusCaptured},
+		{name: "captured after void", status: PaymentStatusVoided, event: ProviderEvent{Type: EventPaymentCaptured}},
+		{name: "dispute on captured", status: PaymentStatusCaptured, event: ProviderEvent{Type: EventDisputeOpened}, wantStatus: PaymentStatusDisputed},
+		{name: "dispute on partially refunded", status: PaymentStatusPartiallyRefunded, event: ProviderEvent{Type: EventDisputeOpened}, wantStatus: PaymentStatusDisputed},
+		{name: "dispute on refunded", status: PaymentStatusRefunded, event: ProviderEvent{Type: EventDisputeOpened}, wantStatus: PaymentStatusDisputed},
+		{name: "dispute on pending", status: PaymentStatusPending, event: ProviderEvent{Type: EventDisputeOpened}},
+		{name: "dispute on voided", status: PaymentStatusVoided, event: ProviderEvent{Type: EventDisputeOpened}},
+		{name: "dispute on failed", status: PaymentStatusFailed, event: ProviderEvent{Type: EventDisputeOpened}},
+		{name: "dispute won", status: PaymentStatusDisputed, event: ProviderEvent{Type: EventDisputeWon}, wantStatus: PaymentStatusCaptured},
+		{name: "dispute won without dispute", status: PaymentStatusRefunded, event: ProviderEvent{Type: EventDisputeWon}},
+		{name: "dispute lost", status: PaymentStatusDisputed, event: ProviderEvent{Type: EventDisputeLost}, wantStatus: PaymentStatusChargedBack},
+		{name: "unsupported event", status: PaymentStatusCaptured, event: ProviderEvent{Type: "charge.expired"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			database := testDB(t)
+			payment := Payment{OrderID: "1", Amount: 20, Currency: "EUR", Status: tt.status, Provider: "fake", ProviderReference: "fake_pi_1"}
+			if tt.status != PaymentStatusPending && tt.status != PaymentStatusAuthorized && tt.status != PaymentStatusVoided && tt.status != PaymentStatusFailed {
+				payment.CapturedAmount = 20
+			}
+			if err := database.Create(&payment).Error; err != nil {
+				t.Fatal(err)
+			}
+			tt.event.ID = "evt_1"
+			tt.event.Reference = "fake_pi_1"
+
+			updated, err := applyProviderEvent(database, "fake", tt.event)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantStatus == "" {
+				if updated != nil {
+					t.Fatalf("payment updated to %q, want unchanged", updated.Status)
+				}
+				var stored Payment
+				database.First(&stored, payment.ID)
+				if stored.Status != tt.status {
+					t.Errorf("stored status = %q, want %q", stored.Status, tt.status)
+				}
+				return
+			}
+			if updated == nil || updated.Status != tt.wantStatus {
+				t.Fatalf("payment = %+v, want status %q", updated, tt.wantStatus)
+			}
+			if tt.event.Type != EventPaymentCaptured {
+				return
+			}
+			var capture PaymentCapture
+			if err := database.First(&capture, "payment_id = ?", payment.ID).Error; err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantFee == nil {
+				if !capture.FeePending || capture.Fee != 0 {
+					t.Errorf("capture fee = %v, pending %v, want flagged for reconciliation", capture.Fee, capture.FeePending)
+				}
+			} else if capture.FeePending || capture.Fee != *tt.wantFee {
+				t.Errorf("capture fee = %v, pending %v, want %v", capture.Fee, capture.FeePending, *tt.wantFee)
+			}
+			var fees JournalLine
+			database.Where("account = ?", AccountFees).Find(&fees)
+			if want := toMinorUnits(capture.Fee); fees.Debit != want {
+				t.Errorf("fees debit = %d, want %d", fees.Debit, want)
+			}
+		})
+	}
+}
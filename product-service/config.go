@@ -0,0 +1,15 @@
+package main
+
+import "shared/config"
+
+// Config est lue au démarrage depuis l'environnement, voir le paquet shared/config
+type Config struct {
+	config.Server
+	config.Database
+	config.Auth
+	config.Internal
+	// Vide ou "memory://" : bus en mémoire, limité au processus
+	EventBusURL string `env:"EVENT_BUS_URL"`
+}
+
+var cfg = Config{Server: config.Server{Port: 8081}}
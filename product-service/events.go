@@ -0,0 +1,50 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"shared/bus"
+	"shared/logging"
+	"shared/outbox"
+)
+
+const (
+	EventProductCreated = "product.created"
+	EventProductUpdated = "product.updated"
+	EventProductDeleted = "product.deleted"
+)
+
+var eventBus bus.Bus
+
+// events est l'outbox du service, publiée sur eventBus
+var events outbox.Outbox
+
+func newEventID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func productEvent(eventType string, product Product) outbox.Event {
+	return outbox.Event{
+		ID:            newEventID(),
+		Type:          eventType,
+		AggregateType: "product",
+		AggregateID:   fmt.Sprint(product.ID),
+		OccurredAt:    time.Now(),
+		Data:          product,
+	}
+}
+
+// initEventBus ouvre le bus d'événements ; product-service déclare les sujets "product.*" qu'il publie
+func initEventBus() {
+	var err error
+	eventBus, err = bus.Open(cfg.EventBusURL, bus.Options{Subjects: []string{"product.>"}})
+	if err != nil {
+		logging.Fatal("failed to connect event bus", "error", err)
+	}
+	events = outbox.Outbox{DB: db, Table: "product_outbox", Publisher: eventBus}
+}
@@ -1,165 +1,69 @@
 package main
 
 import (
-	"context"
-	"encoding/json"
-	"fmt"
-	"log"
+	"embed"
 	"net/http"
-	"strings"
 
-	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"shared/service"
+	"shared/web"
 )
 
 type Product struct {
-	ID       uint `gorm:"primaryKey;autoIncrement" json:"id"`
-	Name     string
-	Category string
-	Price    float64
+	ID       uint    `gorm:"primaryKey;autoIncrement" json:"id"`
+	Name     string  `validate:"required,max=100"`
+	Category string  `validate:"max=100"`
+	Price    float64 `validate:"min=0"`
 }
 
-var db *gorm.DB
-
-func initDB() {
-	dsn := "host=db user=user password=password dbname=microservices port=5432 sslmode=disable TimeZone=Asia/Shanghai"
-	var err error
-	db, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
-	if err != nil {
-		log.Fatalf("failed to connect database: %v", err)
-	}
-}
-
-func migrate() {
-	db.AutoMigrate(&Product{})
-}
+// Migrations SQL du schéma, appliquées par la sous-commande migrate
+//
+//go:embed migrations/*.sql
+var migrations embed.FS
 
-func productsHandler(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case "GET":
-		getProducts(w)
-	case "POST":
-		createProduct(w, r)
-	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-	}
-}
-
-func productHandler(w http.ResponseWriter, r *http.Request) {
-	id := r.URL.Path[len("/products/"):]
-	switch r.Method {
-	case "GET":
-		getProduct(w, id)
-	case "PUT":
-		updateProduct(w, r, id)
-	case "DELETE":
-		deleteProduct(w, id)
-	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-	}
-}
+// Données de démonstration, chargées en développement avec DB_SEED_DEMO ou la sous-commande seed
+//
+//go:embed seeds/*.sql
+var seeds embed.FS
 
-func getProducts(w http.ResponseWriter) {
-	var products []Product
-	if err := db.Find(&products).Error; err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	json.NewEncoder(w).Encode(products)
-}
-
-func createProduct(w http.ResponseWriter, r *http.Request) {
-	var product Product
-	if err := json.NewDecoder(r.Body).Decode(&product); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
-	if err := db.Create(&product).Error; err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	w.WriteHeader(http.StatusCreated)
-}
+var db *gorm.DB
 
-func getProduct(w http.ResponseWriter, id string) {
-	var product Product
-	if err := db.First(&product, "id = ?", id).Error; err != nil {
-		http.Error(w, "Product not found", http.StatusNotFound)
-		return
-	}
-	json.NewEncoder(w).Encode(product)
+// products publie un événement dans l'outbox à chaque écriture du catalogue
+var products = web.Resource[Product]{
+	Name:   "Product",
+	Prefix: "/products/",
+	AfterCreate: func(tx *gorm.DB, product *Product) error {
+		return events.Enqueue(tx, productEvent(EventProductCreated, *product))
+	},
+	AfterUpdate: func(tx *gorm.DB, product *Product) error {
+		return events.Enqueue(tx, productEvent(EventProductUpdated, *product))
+	},
+	AfterDelete: func(tx *gorm.DB, product *Product) error {
+		return events.Enqueue(tx, productEvent(EventProductDeleted, *product))
+	},
 }
 
-func updateProduct(w http.ResponseWriter, r *http.Request, id string) {
+func internalProductHandler(w http.ResponseWriter, r *http.Request) {
 	var product Product
-	if err := json.NewDecoder(r.Body).Decode(&product); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
-	if err := db.Model(&Product{}).Where("id = ?", id).Updates(product).Error; err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := db.First(&product, "id = ?", web.PathID(r, "/internal/products/")).Error; err != nil {
+		web.WriteError(w, err, "Product")
 		return
 	}
-	w.WriteHeader(http.StatusOK)
-}
-
-func deleteProduct(w http.ResponseWriter, id string) {
-	if err := db.Delete(&Product{}, "id = ?", id).Error; err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	w.WriteHeader(http.StatusOK)
-}
-
-type contextKey string
-
-const userIDKey contextKey = "user_id"
-
-func jwtMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		token := r.Header.Get("Authorization")
-		if token == "" {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-
-		resp, err := http.Post("http://auth-service:8080/verify-token", "application/json", strings.NewReader(fmt.Sprintf(`{"token":"%s"}`, token)))
-		if err != nil {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-
-		if resp.StatusCode != http.StatusOK {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-
-		var result struct {
-			UserID string `json:"user_id"`
-		}
-		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-		ctx := context.WithValue(r.Context(), userIDKey, result.UserID)
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
-}
-
-func healthHandler(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("OK"))
+	web.WriteJSON(w, http.StatusOK, product)
 }
 
 func main() {
-	initDB()
-	migrate()
-
-	mux := http.NewServeMux()
-	mux.Handle("/products", jwtMiddleware(http.HandlerFunc(productsHandler)))
-	mux.Handle("/products/", jwtMiddleware(http.HandlerFunc(productHandler)))
-	mux.HandleFunc("/health", healthHandler)
-
-	log.Println("Starting Product Service on :8081")
-	log.Fatal(http.ListenAndServe(":8081", mux))
+	svc := service.New("Product Service", &cfg)
+	db = svc.DB
+	svc.Migrate(migrations)
+	svc.Seed(seeds)
+	initEventBus()
+	svc.OnStop(func() { eventBus.Close() })
+	svc.Go(events.Run)
+
+	products.DB = db
+	svc.Router.Handle("/products", products.Collection(), svc.RequireUser())
+	svc.Router.Handle("/products/", products.Item(), svc.RequireUser())
+	svc.Router.Handle("/internal/products/", web.Methods{"GET": internalProductHandler}, svc.RequireInternal())
+	svc.Run()
 }
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS product_outbox;
+DROP TABLE IF EXISTS products;
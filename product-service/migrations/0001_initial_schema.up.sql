@@ -0,0 +1,25 @@
+-- Schéma créé jusqu'ici par AutoMigrate et db/init_db.sql ; une base existante s'adopte avec
+-- "migrate baseline", qui vérifie qu'elle correspond à cette migration
+CREATE TABLE products (
+    id bigserial PRIMARY KEY,
+    name text,
+    category text,
+    price numeric
+);
+
+CREATE TABLE product_outbox (
+    id bigserial PRIMARY KEY,
+    event_id text,
+    event_type text,
+    aggregate_type text,
+    aggregate_id text,
+    payload bytea,
+    created_at timestamptz,
+    published_at timestamptz,
+    attempts bigint,
+    last_error text,
+    next_attempt_at timestamptz
+);
+CREATE UNIQUE INDEX idx_product_outbox_event_id ON product_outbox (event_id);
+CREATE INDEX idx_product_outbox_published_at ON product_outbox (published_at);
+CREATE INDEX idx_product_outbox_aggregate ON product_outbox (aggregate_type, aggregate_id);
@@ -0,0 +1,8 @@
+-- Produits de démonstration ; réservés au développement
+INSERT INTO products (name, category, price)
+SELECT seed.name, seed.category, seed.price
+FROM (VALUES
+    ('Product1', 'Category1', 100.0),
+    ('Product2', 'Category2', 200.0)
+) AS seed (name, category, price)
+WHERE NOT EXISTS (SELECT 1 FROM products WHERE products.name = seed.name);
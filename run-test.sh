@@ -138,28 +138,32 @@ payment_service_tests() {
     curl -s -H "Authorization: $jwt_token" $uri/payments
     echo
 
-    echo "2. Creating a payment"
-    curl -s -X POST -H "Content-Type: application/json" -H "Authorization: $jwt_token" -d '{"id":1,"order_id":"2","amount":100.0,"status":"pending"}' $uri/payments
+    echo "2. Authorizing a payment"
+    curl -s -X POST -H "Content-Type: application/json" -H "Authorization: $jwt_token" -d '{"id":1,"order_id":"2","amount":100.0,"currency":"EUR","payment_method":"fake_card_visa"}' $uri/payments
     echo
 
     echo "3. Reading all payments"
     curl -s -H "Authorization: $jwt_token" $uri/payments
     echo
 
-    echo "4. Updating a payment"
-    curl -s -X PUT -H "Content-Type: application/json" -H "Authorization: $jwt_token" -d '{"id":1,"order_id":"2","amount":150.0,"status":"completed"}' $uri/payments/1
+    echo "4. Capturing the payment"
+    curl -s -X POST -H "Authorization: $jwt_token" $uri/payments/1/capture
     echo
 
-    echo "5. Reading the updated payment"
+    echo "5. Refunding part of the payment"
+    curl -s -X POST -H "Content-Type: application/json" -H "Authorization: $jwt_token" -d '{"amount":30.0,"reason":"damaged item"}' $uri/payments/1/refunds
+    echo
+
+    echo "6. Reading the updated payment"
     curl -s -H "Authorization: $jwt_token" $uri/payments/1
     echo
 
-    echo "6. Deleting a payment"
-    curl -s -X DELETE -H "Authorization: $jwt_token" $uri/payments/1
+    echo "7. Reading the ledger balances"
+    curl -s -H "Authorization: $jwt_token" $uri/ledger/accounts
     echo
 
-    echo "7. Reading all payments"
-    curl -s -H "Authorization: $jwt_token" $uri/payments
+    echo "8. Checking the ledger consistency"
+    curl -s -H "Authorization: $jwt_token" $uri/ledger/check
     echo
 }
 
@@ -176,7 +180,7 @@ notification_service_tests() {
     echo
 
     echo "2. Creating a notification"
-    curl -s -X POST -H "Content-Type: application/json" -H "Authorization: $jwt_token" -d '{"id":1,"user_id":"1","message":"Your order has been shipped","status":"pending"}' $uri/notifications
+    curl -s -X POST -H "Content-Type: application/json" -H "Authorization: $jwt_token" -d '{"id":1,"user_id":"1","channel":"email","recipient":"user1@example.com","template":"order_shipped","locale":"fr","data":{"OrderID":1,"ProductName":"Product 1","CustomerName":"User 1"}}' $uri/notifications
     echo
 
     echo "3. Reading all notifications"
@@ -198,6 +202,27 @@ notification_service_tests() {
     echo "7. Reading all notifications"
     curl -s -H "Authorization: $jwt_token" $uri/notifications
     echo
+
+    echo "8. Setting notification preferences"
+    curl -s -X PUT -H "Content-Type: application/json" -H "Authorization: $jwt_token" -d '{"marketing_opt_out":true,"quiet_hours_start":"22:00","quiet_hours_end":"07:00","time_zone":"Europe/Paris","event_channels":[{"event_type":"order_shipped","channel":"email"},{"event_type":"order_shipped","channel":"in_app"}]}' $uri/users/me/preferences
+    echo
+
+    echo "9. Reading notification preferences"
+    curl -s -H "Authorization: $jwt_token" $uri/users/me/preferences
+    echo
+
+    echo "10. Creating an in-app notification"
+    curl -s -X POST -H "Content-Type: application/json" -H "Authorization: $jwt_token" -d '{"user_id":"1","channel":"in_app","template":"order_created","locale":"en","data":{"OrderID":1,"ProductName":"Product 1","Quantity":2}}' $uri/notifications
+    echo
+    sleep 1
+
+    echo "11. Reading the in-app inbox"
+    curl -s -H "Authorization: $jwt_token" $uri/users/me/notifications
+    echo
+
+    echo "12. Marking all in-app notifications as read"
+    curl -s -X POST -H "Authorization: $jwt_token" $uri/users/me/notifications/read-all
+    echo
 }
 
 # Obtenir un token JWT pour les tests
@@ -0,0 +1,100 @@
+// Package bus transporte les événements métier entre les services.
+//
+// Un message publié sur un sujet ("order.placed") est remis une fois à chaque groupe de
+// consommateurs abonné à ce sujet ; au sein d'un groupe, les instances se partagent les
+// messages. Un handler qui renvoie une erreur provoque une nouvelle remise après un délai,
+// jusqu'à MaxDeliver tentatives : la remise est donc "au moins une fois" et les handlers
+// doivent dédoublonner sur Message.ID.
+package bus
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Message est l'enveloppe d'un événement sur le bus
+type Message struct {
+	ID      string
+	Subject string
+	// Key regroupe les messages d'un même agrégat
+	Key  string
+	Data []byte
+	// Attempt vaut 1 à la première remise
+	Attempt int
+}
+
+// Handler traite un message ; nil l'acquitte, une erreur demande une nouvelle remise
+type Handler func(ctx context.Context, msg Message) error
+
+type Publisher interface {
+	Publish(ctx context.Context, msg Message) error
+}
+
+type Subscriber interface {
+	// Subscribe abonne le groupe au sujet, qui accepte les jokers "*" (un segment) et ">" (la fin)
+	Subscribe(ctx context.Context, subject, group string, handler Handler) error
+}
+
+type Bus interface {
+	Publisher
+	Subscriber
+	Close() error
+}
+
+type Options struct {
+	// Nombre maximal de remises d'un message avant abandon
+	MaxDeliver int
+	// Délai avant la première nouvelle remise, doublé à chaque échec
+	RetryDelay time.Duration
+	// Sujets retenus par le broker (flux JetStream pour NATS)
+	Subjects []string
+}
+
+func (o Options) withDefaults() Options {
+	if o.MaxDeliver <= 0 {
+		o.MaxDeliver = 10
+	}
+	if o.RetryDelay <= 0 {
+		o.RetryDelay = time.Second
+	}
+	return o
+}
+
+func (o Options) retryDelay(attempt int) time.Duration {
+	delay := o.RetryDelay
+	for i := 1; i < attempt && delay < time.Minute; i++ {
+		delay *= 2
+	}
+	return delay
+}
+
+// Open choisit l'implémentation d'après l'URL : "nats://..." pour NATS JetStream,
+// "memory://" ou vide pour le bus en mémoire, limité à un seul processus
+func Open(url string, options Options) (Bus, error) {
+	options = options.withDefaults()
+	switch {
+	case url == "" || url == "memory://":
+		return NewMemory(options), nil
+	case strings.HasPrefix(url, "nats://"):
+		return NewNATS(url, options)
+	default:
+		return nil, fmt.Errorf("unsupported event bus URL %q", url)
+	}
+}
+
+// matchSubject applique les jokers NATS : "*" remplace un segment, ">" tous les suivants
+func matchSubject(pattern, subject string) bool {
+	patternTokens := strings.Split(pattern, ".")
+	subjectTokens := strings.Split(subject, ".")
+	for i, token := range patternTokens {
+		if token == ">" {
+			return len(subjectTokens) > i
+		}
+		if i >= len(subjectTokens) || (token != "*" && token != subjectTokens[i]) {
+			return false
+		}
+	}
+	return len(patternTokens) == len(subjectTokens)
+}
@@ -0,0 +1,52 @@
+package bus
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMatchSubject(t *testing.T) {
+	tests := []struct {
+		pattern string
+		subject string
+		want    bool
+	}{
+		{pattern: "order.placed", subject: "order.placed", want: true},
+		{pattern: "order.placed", subject: "order.paid", want: false},
+		{pattern: "order.placed", subject: "order.placed.eu", want: false},
+		{pattern: "order.*", subject: "order.paid", want: true},
+		{pattern: "order.*", subject: "order", want: false},
+		{pattern: "order.*", subject: "order.paid.eu", want: false},
+		{pattern: "*.paid", subject: "order.paid", want: true},
+		{pattern: "order.>", subject: "order.paid", want: true},
+		{pattern: "order.>", subject: "order.paid.eu", want: true},
+		{pattern: "order.>", subject: "order", want: false},
+		{pattern: "order.>", subject: "payment.captured", want: false},
+		{pattern: ">", subject: "payment.captured", want: true},
+		{pattern: "*.>", subject: "order.paid", want: true},
+	}
+	for _, tt := range tests {
+		if got := matchSubject(tt.pattern, tt.subject); got != tt.want {
+			t.Errorf("matchSubject(%q, %q) = %v, want %v", tt.pattern, tt.subject, got, tt.want)
+		}
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	options := Options{RetryDelay: time.Second}
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{attempt: 1, want: time.Second},
+		{attempt: 2, want: 2 * time.Second},
+		{attempt: 4, want: 8 * time.Second},
+		{attempt: 7, want: 64 * time.Second},
+		{attempt: 20, want: 64 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := options.retryDelay(tt.attempt); got != tt.want {
+			t.Errorf("retryDelay(%d) = %s, want %s", tt.attempt, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package bus
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+var ErrClosed = errors.New("event bus closed")
+
+// Memory est un bus en mémoire pour les tests et le développement sur un seul processus
+type Memory struct {
+	options Options
+	mu      sync.Mutex
+	groups  map[string]*memoryGroup
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+// memoryGroup remet les messages un par un, dans l'ordre de publication, à tour de rôle
+// aux handlers du groupe ; un message en échec est retenté avant de passer au suivant
+type memoryGroup struct {
+	subject  string
+	handlers []Handler
+	next     int
+	queue    chan Message
+	ctx      context.Context
+	cancel   context.CancelFunc
+}
+
+func NewMemory(options Options) *Memory {
+	return &Memory{options: options.withDefaults(), groups: map[string]*memoryGroup{}}
+}
+
+func (m *Memory) Publish(ctx context.Context, msg Message) error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return ErrClosed
+	}
+	var queues []chan Message
+	for _, group := range m.groups {
+		if matchSubject(group.subject, msg.Subject) {
+			queues = append(queues, group.queue)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, queue := range queues {
+		select {
+		case queue <- msg:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (m *Memory) Subscribe(ctx context.Context, subject, group string, handler Handler) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return ErrClosed
+	}
+	key := group + "|" + subject
+	if existing, ok := m.groups[key]; ok {
+		existing.handlers = append(existing.handlers, handler)
+		return nil
+	}
+	groupCtx, cancel := context.WithCancel(context.Background())
+	g := &memoryGroup{
+		subject:  subject,
+		handlers: []Handler{handler},
+		queue:    make(chan Message, 1024),
+		ctx:      groupCtx,
+		cancel:   cancel,
+	}
+	m.groups[key] = g
+	m.wg.Add(1)
+	go m.deliver(g)
+	return nil
+}
+
+func (m *Memory) deliver(g *memoryGroup) {
+	defer m.wg.Done()
+	for {
+		select {
+		case <-g.ctx.Done():
+			return
+		case msg := <-g.queue:
+			for attempt := 1; attempt <= m.options.MaxDeliver; attempt++ {
+				msg.Attempt = attempt
+				m.mu.Lock()
+				handler := g.handlers[g.next%len(g.handlers)]
+				g.next++
+				m.mu.Unlock()
+				err := handler(g.ctx, msg)
+				if err == nil {
+					break
+				}
+				if attempt == m.options.MaxDeliver {
+					slog.ErrorContext(g.ctx, "giving up on message", "message_id", msg.ID, "subject", msg.Subject, "attempts", attempt, "error", err)
+					break
+				}
+				select {
+				case <-g.ctx.Done():
+					return
+				case <-time.After(m.options.retryDelay(attempt)):
+				}
+			}
+		}
+	}
+}
+
+func (m *Memory) Close() error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil
+	}
+	m.closed = true
+	for _, group := range m.groups {
+		group.cancel()
+	}
+	m.mu.Unlock()
+	m.wg.Wait()
+	return nil
+}
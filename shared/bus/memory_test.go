@@ -0,0 +1,135 @@
+package bus
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// received note les remises reçues par un handler de test
+type received struct {
+	mu       sync.Mutex
+	attempts []int
+	ids      []string
+	done     chan struct{}
+}
+
+func TestMemoryRetry(t *testing.T) {
+	tests := []struct {
+		name string
+		// failures est le nombre de remises refusées par le handler
+		failures     int
+		maxDeliver   int
+		wantAttempts []int
+	}{
+		{name: "acknowledged on first delivery", failures: 0, maxDeliver: 3, wantAttempts: []int{1}},
+		{name: "redelivered after a failure", failures: 2, maxDeliver: 3, wantAttempts: []int{1, 2, 3}},
+		{name: "dropped after MaxDeliver", failures: 5, maxDeliver: 3, wantAttempts: []int{1, 2, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			memory := NewMemory(Options{MaxDeliver: tt.maxDeliver, RetryDelay: time.Millisecond})
+			defer memory.Close()
+
+			got := &received{done: make(chan struct{})}
+			err := memory.Subscribe(context.Background(), "order.>", "test", func(ctx context.Context, msg Message) error {
+				got.mu.Lock()
+				defer got.mu.Unlock()
+				got.attempts = append(got.attempts, msg.Attempt)
+				if msg.Attempt <= tt.failures {
+					if msg.Attempt == tt.maxDeliver {
+						close(got.done)
+					}
+					return errors.New("temporary failure")
+				}
+				close(got.done)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := memory.Publish(context.Background(), Message{ID: "1", Subject: "order.placed"}); err != nil {
+				t.Fatal(err)
+			}
+			waitFor(t, got.done)
+			// Laisse le temps à une remise de trop de se produire
+			time.Sleep(10 * time.Millisecond)
+
+			got.mu.Lock()
+			defer got.mu.Unlock()
+			if !reflect.DeepEqual(got.attempts, tt.wantAttempts) {
+				t.Errorf("attempts = %v, want %v", got.attempts, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestMemoryDelivery(t *testing.T) {
+	memory := NewMemory(Options{RetryDelay: time.Millisecond})
+	defer memory.Close()
+
+	// Chaque groupe reçoit chaque message, dans l'ordre de publication ; le message en
+	// échec retient les suivants jusqu'à sa nouvelle remise
+	groups := map[string]*received{}
+	for _, group := range []string{"notifications", "analytics"} {
+		got := &received{done: make(chan struct{})}
+		groups[group] = got
+		err := memory.Subscribe(context.Background(), "order.*", group, func(ctx context.Context, msg Message) error {
+			got.mu.Lock()
+			defer got.mu.Unlock()
+			if msg.ID == "2" && msg.Attempt == 1 {
+				return errors.New("temporary failure")
+			}
+			got.ids = append(got.ids, msg.ID)
+			if len(got.ids) == 3 {
+				close(got.done)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, msg := range []Message{
+		{ID: "1", Subject: "order.placed"},
+		{ID: "2", Subject: "order.paid"},
+		{ID: "ignored", Subject: "payment.captured"},
+		{ID: "3", Subject: "order.shipped"},
+	} {
+		if err := memory.Publish(context.Background(), msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for group, got := range groups {
+		waitFor(t, got.done)
+		got.mu.Lock()
+		if want := []string{"1", "2", "3"}; !reflect.DeepEqual(got.ids, want) {
+			t.Errorf("group %s received %v, want %v", group, got.ids, want)
+		}
+		got.mu.Unlock()
+	}
+}
+
+func TestMemoryClosed(t *testing.T) {
+	memory := NewMemory(Options{})
+	memory.Close()
+	if err := memory.Publish(context.Background(), Message{Subject: "order.placed"}); !errors.Is(err, ErrClosed) {
+		t.Errorf("Publish() error = %v, want ErrClosed", err)
+	}
+	if err := memory.Subscribe(context.Background(), "order.>", "test", nil); !errors.Is(err, ErrClosed) {
+		t.Errorf("Subscribe() error = %v, want ErrClosed", err)
+	}
+}
+
+func waitFor(t *testing.T, done chan struct{}) {
+	t.Helper()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for delivery")
+	}
+}
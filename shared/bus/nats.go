@@ -0,0 +1,147 @@
+package bus
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+const natsStreamName = "EVENTS"
+
+// NATS s'appuie sur JetStream : les messages sont persistés dans un flux, chaque groupe
+// est un consommateur durable partagé par ses instances, et les acquittements sont explicites
+type NATS struct {
+	options Options
+	conn    *nats.Conn
+	js      nats.JetStreamContext
+}
+
+func NewNATS(url string, options Options) (*NATS, error) {
+	options = options.withDefaults()
+	conn, err := nats.Connect(url,
+		nats.Name("go-microservice"),
+		nats.MaxReconnects(-1),
+		nats.RetryOnFailedConnect(true),
+		nats.ReconnectWait(2*time.Second),
+	)
+	if err != nil {
+		return nil, err
+	}
+	js, err := conn.JetStream()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	b := &NATS{options: options, conn: conn, js: js}
+	if len(options.Subjects) > 0 {
+		if err := b.ensureStream(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// ensureStream crée le flux ou lui ajoute les sujets manquants ; chaque service déclare
+// les sujets qu'il publie
+func (b *NATS) ensureStream() error {
+	info, err := b.js.StreamInfo(natsStreamName)
+	if err != nil {
+		_, err = b.js.AddStream(&nats.StreamConfig{
+			Name:       natsStreamName,
+			Subjects:   b.options.Subjects,
+			Storage:    nats.FileStorage,
+			MaxAge:     7 * 24 * time.Hour,
+			Duplicates: 10 * time.Minute,
+		})
+		if err == nil || !strings.Contains(err.Error(), "already in use") {
+			return err
+		}
+		if info, err = b.js.StreamInfo(natsStreamName); err != nil {
+			return err
+		}
+	}
+	config := info.Config
+	changed := false
+	for _, subject := range b.options.Subjects {
+		if !containsString(config.Subjects, subject) {
+			config.Subjects = append(config.Subjects, subject)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	_, err = b.js.UpdateStream(&config)
+	return err
+}
+
+// Publish attend l'accusé de JetStream ; l'ID du message sert à dédoublonner les republications
+func (b *NATS) Publish(ctx context.Context, msg Message) error {
+	natsMsg := nats.NewMsg(msg.Subject)
+	natsMsg.Data = msg.Data
+	if msg.Key != "" {
+		natsMsg.Header.Set("Event-Key", msg.Key)
+	}
+	_, err := b.js.PublishMsg(natsMsg, nats.MsgId(msg.ID), nats.Context(ctx))
+	return err
+}
+
+func (b *NATS) Subscribe(ctx context.Context, subject, group string, handler Handler) error {
+	durable := durableName(group, subject)
+	_, err := b.js.QueueSubscribe(subject, durable, func(m *nats.Msg) {
+		msg := Message{
+			ID:      m.Header.Get(nats.MsgIdHdr),
+			Subject: m.Subject,
+			Key:     m.Header.Get("Event-Key"),
+			Data:    m.Data,
+			Attempt: 1,
+		}
+		if meta, err := m.Metadata(); err == nil {
+			msg.Attempt = int(meta.NumDelivered)
+		}
+		if err := handler(ctx, msg); err != nil {
+			if msg.Attempt >= b.options.MaxDeliver {
+				slog.ErrorContext(ctx, "giving up on message", "message_id", msg.ID, "subject", msg.Subject, "attempts", msg.Attempt, "error", err)
+				m.Term()
+				return
+			}
+			m.NakWithDelay(b.options.retryDelay(msg.Attempt))
+			return
+		}
+		m.Ack()
+	},
+		nats.Durable(durable),
+		nats.DeliverAll(),
+		nats.ManualAck(),
+		nats.AckExplicit(),
+		nats.AckWait(30*time.Second),
+		nats.MaxDeliver(b.options.MaxDeliver),
+	)
+	if err != nil {
+		return fmt.Errorf("subscribe %s for %s: %w", subject, group, err)
+	}
+	return nil
+}
+
+func (b *NATS) Close() error {
+	return b.conn.Drain()
+}
+
+// durableName : les noms de consommateurs JetStream n'acceptent ni "." ni jokers
+func durableName(group, subject string) string {
+	return strings.NewReplacer(".", "_", "*", "any", ">", "all").Replace(group + "_" + subject)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
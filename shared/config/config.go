@@ -0,0 +1,407 @@
+// Package config charge la configuration typée des services.
+//
+// Chaque champ d'une structure de configuration porte le nom de sa variable d'environnement
+// dans le tag `env`, et éventuellement `default`, `required:"true"` ou `secret:"true"`.
+// Les sources sont appliquées dans cet ordre, la dernière l'emportant :
+//
+//   - la valeur du tag `default` ;
+//   - le fichier YAML indiqué par --config ou CONFIG_FILE, dont les clés sont les noms des
+//     variables en minuscules (db_host, jwt_secret...) ;
+//   - la variable d'environnement ;
+//   - le fichier désigné par la variable suffixée de _FILE (JWT_SECRET_FILE), pour les
+//     secrets montés par Docker ou Kubernetes.
+//
+// Les structures imbriquées sans tag `env` (Server, Database) sont parcourues récursivement.
+// Une structure qui a une méthode Validate() error est vérifiée une fois chargée.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Server regroupe les réglages HTTP, de logs et de traçage communs à tous les services ;
+// chaque service fixe son port par défaut
+type Server struct {
+	Port              int           `env:"PORT" required:"true"`
+	ReadHeaderTimeout time.Duration `env:"HTTP_READ_HEADER_TIMEOUT" default:"5s"`
+	ReadTimeout       time.Duration `env:"HTTP_READ_TIMEOUT" default:"15s"`
+	WriteTimeout      time.Duration `env:"HTTP_WRITE_TIMEOUT" default:"30s"`
+	IdleTimeout       time.Duration `env:"HTTP_IDLE_TIMEOUT" default:"60s"`
+	// Délai pendant lequel le service se déclare indisponible avant de fermer ses connexions,
+	// le temps que les répartiteurs de charge cessent de lui envoyer des requêtes
+	DrainDelay time.Duration `env:"SHUTDOWN_DRAIN_DELAY" default:"3s"`
+	// Durée maximale laissée aux requêtes et aux tâches de fond pour se terminer
+	ShutdownTimeout time.Duration `env:"SHUTDOWN_TIMEOUT" default:"15s"`
+
+	Logging Logging
+	Tracing Tracing
+}
+
+// Addr renvoie l'adresse d'écoute au format attendu par net/http
+func (s Server) Addr() string {
+	return fmt.Sprintf(":%d", s.Port)
+}
+
+func (s Server) ServerSettings() Server {
+	return s
+}
+
+// Database décrit la connexion Postgres partagée par les services
+type Database struct {
+	Host     string `env:"DB_HOST" default:"db"`
+	Port     int    `env:"DB_PORT" default:"5432"`
+	User     string `env:"DB_USER" required:"true"`
+	Password string `env:"DB_PASSWORD" required:"true" secret:"true"`
+	Name     string `env:"DB_NAME" default:"microservices"`
+	SSLMode  string `env:"DB_SSLMODE" default:"disable"`
+	TimeZone string `env:"DB_TIMEZONE" default:"UTC"`
+	// Applique les migrations en attente au démarrage, plutôt que d'exiger un
+	// "<service> migrate up" préalable ; pratique en développement
+	MigrateOnStart bool `env:"DB_MIGRATE_ON_START" default:"false"`
+	// Charge les données de démonstration des services qui en ont, au démarrage ;
+	// réservé au développement, les comptes de démonstration ont des mots de passe connus
+	SeedDemo bool `env:"DB_SEED_DEMO" default:"false"`
+}
+
+// DSN renvoie la chaîne de connexion au format libpq
+func (d Database) DSN() string {
+	return fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%d sslmode=%s TimeZone=%s",
+		d.Host, d.User, d.Password, d.Name, d.Port, d.SSLMode, d.TimeZone)
+}
+
+func (d Database) DatabaseSettings() Database {
+	return d
+}
+
+// Auth désigne auth-service, qui vérifie les jetons des utilisateurs
+type Auth struct {
+	AuthServiceURL string `env:"AUTH_SERVICE_URL" required:"true"`
+}
+
+func (a Auth) AuthService() string {
+	return a.AuthServiceURL
+}
+
+// Internal est le jeton partagé qui protège les routes réservées aux autres services
+type Internal struct {
+	InternalAPIToken string `env:"INTERNAL_API_TOKEN" required:"true" secret:"true"`
+}
+
+func (i Internal) InternalToken() string {
+	return i.InternalAPIToken
+}
+
+// Admin liste les utilisateurs autorisés sur les routes d'administration. Un service qui
+// en a refuse de démarrer si JWT_SECRET ou INTERNAL_API_TOKEN ont gardé leur valeur d'exemple.
+type Admin struct {
+	// Identifiants séparés par des virgules ; vide, personne n'est administrateur
+	AdminUserIDs []string `env:"ADMIN_USER_IDS"`
+}
+
+func (a Admin) AdminIDs() []string {
+	return a.AdminUserIDs
+}
+
+// Logging règle les logs structurés
+type Logging struct {
+	// debug, info, warn ou error ; les requêtes des sondes et de /metrics ne sont journalisées qu'en debug
+	Level string `env:"LOG_LEVEL" default:"info"`
+}
+
+// SlogLevel convertit le niveau configuré pour log/slog
+func (l Logging) SlogLevel() (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(l.Level)); err != nil {
+		return level, fmt.Errorf("LOG_LEVEL: %v", err)
+	}
+	return level, nil
+}
+
+// Tracing configure l'export des traces OpenTelemetry, avec les noms de variables standards d'OpenTelemetry
+type Tracing struct {
+	// none, stdout pour un usage local, ou otlp
+	Exporter string `env:"OTEL_TRACES_EXPORTER" default:"none"`
+	// Adresse du collecteur OTLP/HTTP, par exemple http://otel-collector:4318
+	OTLPEndpoint string `env:"OTEL_EXPORTER_OTLP_ENDPOINT"`
+	// Part des traces conservées, entre 0 et 1, quand l'appelant n'a rien décidé
+	SampleRatio float64 `env:"OTEL_TRACES_SAMPLER_ARG" default:"1"`
+}
+
+func (t Tracing) Validate() error {
+	switch t.Exporter {
+	case "none", "stdout", "otlp":
+	default:
+		return fmt.Errorf("OTEL_TRACES_EXPORTER must be none, stdout or otlp, got %q", t.Exporter)
+	}
+	if t.SampleRatio < 0 || t.SampleRatio > 1 {
+		return errors.New("OTEL_TRACES_SAMPLER_ARG must be between 0 and 1")
+	}
+	return nil
+}
+
+// exampleSecrets sont les valeurs d'exemple de .env.example
+var exampleSecrets = map[string]string{
+	"JWT_SECRET":         "your_secret_key",
+	"INTERNAL_API_TOKEN": "your_internal_token",
+}
+
+// checkExampleSecrets signale les secrets restés à leur valeur d'exemple alors que des
+// administrateurs sont déclarés ; les secrets sont lus même si le service ne les utilise pas
+func checkExampleSecrets(target interface{}, fileValues map[string]string) []string {
+	admin, ok := target.(interface{ AdminIDs() []string })
+	if !ok || len(admin.AdminIDs()) == 0 {
+		return nil
+	}
+	var problems []string
+	for _, env := range []string{"JWT_SECRET", "INTERNAL_API_TOKEN"} {
+		value, source, _, err := lookup(field{env: env}, fileValues)
+		if err == nil && value == exampleSecrets[env] {
+			problems = append(problems, fmt.Sprintf("%s (from %s) still holds the example value; replace it before setting ADMIN_USER_IDS", env, source))
+		}
+	}
+	return problems
+}
+
+// field est un champ feuille de la configuration, repéré par sa variable d'environnement
+type field struct {
+	env      string
+	value    reflect.Value
+	def      string
+	hasDef   bool
+	required bool
+	secret   bool
+}
+
+// Load remplit target, un pointeur vers une structure, à partir des sources décrites dans
+// la documentation du paquet ; configFile peut être vide. Les valeurs déjà présentes dans
+// target servent de valeurs par défaut propres au service (le port, par exemple).
+func Load(target interface{}, configFile string) error {
+	fields, err := collect(target)
+	if err != nil {
+		return err
+	}
+	fileValues := map[string]string{}
+	if configFile != "" {
+		if fileValues, err = readYAML(configFile); err != nil {
+			return err
+		}
+	}
+
+	var problems []string
+	for _, f := range fields {
+		raw, source, ok, err := lookup(f, fileValues)
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		if !ok || raw == "" {
+			if f.required && f.value.IsZero() {
+				problems = append(problems, fmt.Sprintf("%s is required", f.env))
+			}
+			continue
+		}
+		if err := set(f.value, raw); err != nil {
+			problems = append(problems, fmt.Sprintf("%s (from %s): %v", f.env, source, err))
+		}
+	}
+	problems = append(problems, checkExampleSecrets(target, fileValues)...)
+	if validator, ok := target.(interface{ Validate() error }); ok && len(problems) == 0 {
+		if err := validator.Validate(); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func lookup(f field, fileValues map[string]string) (string, string, bool, error) {
+	if path := os.Getenv(f.env + "_FILE"); path != "" {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return "", "", false, fmt.Errorf("%s_FILE: %v", f.env, err)
+		}
+		return strings.TrimRight(string(content), "\r\n"), f.env + "_FILE", true, nil
+	}
+	if value, ok := os.LookupEnv(f.env); ok && value != "" {
+		return value, "environment", true, nil
+	}
+	if value, ok := fileValues[strings.ToLower(f.env)]; ok {
+		return value, "config file", true, nil
+	}
+	return f.def, "default", f.hasDef, nil
+}
+
+func readYAML(path string) (map[string]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var document map[string]interface{}
+	if err := yaml.Unmarshal(content, &document); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	values := map[string]string{}
+	for key, value := range document {
+		switch v := value.(type) {
+		case nil:
+		case []interface{}:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			values[strings.ToLower(key)] = strings.Join(items, ",")
+		default:
+			values[strings.ToLower(key)] = fmt.Sprint(v)
+		}
+	}
+	return values, nil
+}
+
+func collect(target interface{}) ([]field, error) {
+	value := reflect.ValueOf(target)
+	if value.Kind() != reflect.Ptr || value.Elem().Kind() != reflect.Struct {
+		return nil, errors.New("config target must be a pointer to a struct")
+	}
+	var fields []field
+	collectStruct(value.Elem(), &fields)
+	return fields, nil
+}
+
+func collectStruct(value reflect.Value, fields *[]field) {
+	for i := 0; i < value.NumField(); i++ {
+		structField := value.Type().Field(i)
+		if !structField.IsExported() {
+			continue
+		}
+		env := structField.Tag.Get("env")
+		if env == "" {
+			if value.Field(i).Kind() == reflect.Struct {
+				collectStruct(value.Field(i), fields)
+			}
+			continue
+		}
+		def, hasDef := structField.Tag.Lookup("default")
+		*fields = append(*fields, field{
+			env:      env,
+			value:    value.Field(i),
+			def:      def,
+			hasDef:   hasDef,
+			required: structField.Tag.Get("required") == "true",
+			secret:   structField.Tag.Get("secret") == "true",
+		})
+	}
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func set(value reflect.Value, raw string) error {
+	if value.Type() == durationType {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		value.SetInt(int64(d))
+		return nil
+	}
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(raw)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return fmt.Errorf("expected an integer, got %q", raw)
+		}
+		value.SetInt(n)
+	case reflect.Float64:
+		n, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("expected a number, got %q", raw)
+		}
+		value.SetFloat(n)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("expected a boolean, got %q", raw)
+		}
+		value.SetBool(b)
+	case reflect.Slice:
+		if value.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported type %s", value.Type())
+		}
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		value.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %s", value.Type())
+	}
+	return nil
+}
+
+// Print écrit la configuration effective au format "VARIABLE=valeur", triée, en masquant les secrets
+func Print(w io.Writer, target interface{}) error {
+	fields, err := collect(target)
+	if err != nil {
+		return err
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].env < fields[j].env })
+	for _, f := range fields {
+		fmt.Fprintf(w, "%s=%s\n", f.env, display(f))
+	}
+	return nil
+}
+
+func display(f field) string {
+	if f.secret {
+		if f.value.IsZero() {
+			return ""
+		}
+		return "<redacted>"
+	}
+	if f.value.Kind() == reflect.Slice {
+		return strings.Join(f.value.Interface().([]string), ",")
+	}
+	return fmt.Sprint(f.value.Interface())
+}
+
+// MustLoad charge la configuration au démarrage d'un service et lit ses options de ligne de
+// commande : --config=<fichier> et --print-config, qui affiche la configuration et quitte.
+// Une configuration invalide arrête le service avec la liste des problèmes.
+func MustLoad(target interface{}) {
+	configFile := os.Getenv("CONFIG_FILE")
+	printConfig := false
+	for i, arg := range os.Args[1:] {
+		switch {
+		case arg == "--print-config":
+			printConfig = true
+		case strings.HasPrefix(arg, "--config="):
+			configFile = strings.TrimPrefix(arg, "--config=")
+		case arg == "--config" && i+2 < len(os.Args):
+			configFile = os.Args[i+2]
+		}
+	}
+	if err := Load(target, configFile); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if printConfig {
+		Print(os.Stdout, target)
+		os.Exit(0)
+	}
+}
@@ -0,0 +1,56 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+type adminConfig struct {
+	Internal
+	Admin
+}
+
+func TestLoadRefusesExampleSecretsWithAdmins(t *testing.T) {
+	tests := []struct {
+		name      string
+		env       map[string]string
+		wantError string
+	}{
+		{
+			name:      "example internal token with admins",
+			env:       map[string]string{"ADMIN_USER_IDS": "42", "INTERNAL_API_TOKEN": "your_internal_token", "JWT_SECRET": "s3cret"},
+			wantError: "INTERNAL_API_TOKEN (from environment) still holds the example value",
+		},
+		{
+			name:      "example JWT secret with admins",
+			env:       map[string]string{"ADMIN_USER_IDS": "42", "INTERNAL_API_TOKEN": "t0ken", "JWT_SECRET": "your_secret_key"},
+			wantError: "JWT_SECRET (from environment) still holds the example value",
+		},
+		{
+			name: "example secrets without admins",
+			env:  map[string]string{"ADMIN_USER_IDS": "", "INTERNAL_API_TOKEN": "your_internal_token", "JWT_SECRET": "your_secret_key"},
+		},
+		{
+			name: "real secrets with admins",
+			env:  map[string]string{"ADMIN_USER_IDS": "42,43", "INTERNAL_API_TOKEN": "t0ken", "JWT_SECRET": "s3cret"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			var cfg adminConfig
+			err := Load(&cfg, "")
+			if tt.wantError == "" {
+				if err != nil {
+					t.Fatalf("Load() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantError) {
+				t.Fatalf("Load() error = %v, want %q", err, tt.wantError)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+// Package logging configure les logs structurés des services : une ligne JSON par entrée,
+// sur la sortie standard, avec le nom du service et, quand le contexte est transmis
+// (slog.InfoContext...), l'identifiant de la requête et celui de la trace.
+//
+// Les attributs dont le nom évoque un secret (password, token, secret, authorization...)
+// sont masqués. Le masquage porte sur les noms d'attributs : une structure journalisée
+// telle quelle n'est pas inspectée.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RequestIDHeader porte l'identifiant de requête entre les services
+const RequestIDHeader = "X-Request-ID"
+
+type contextKey struct{}
+
+// Setup remplace le logger par défaut ; les appels restants au paquet log passent aussi par lui
+func Setup(service string, level slog.Level) {
+	handler := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level, ReplaceAttr: redact})
+	slog.SetDefault(slog.New(contextHandler{handler}).With("service", service))
+}
+
+// Fatal journalise une erreur qui empêche le service de démarrer, puis arrête le processus
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// WithRequestID attache l'identifiant de requête au contexte
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// RequestID renvoie l'identifiant de la requête en cours, ou une chaîne vide
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// NewRequestID génère un identifiant de requête aléatoire
+func NewRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Transport transmet l'identifiant de la requête en cours aux services appelés
+func Transport(base http.RoundTripper) http.RoundTripper {
+	return roundTripper{base}
+}
+
+type roundTripper struct {
+	base http.RoundTripper
+}
+
+func (t roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	id := RequestID(req.Context())
+	if id == "" || req.Header.Get(RequestIDHeader) != "" {
+		return t.base.RoundTrip(req)
+	}
+	// Un RoundTripper ne doit pas modifier la requête reçue
+	req = req.Clone(req.Context())
+	req.Header.Set(RequestIDHeader, id)
+	return t.base.RoundTrip(req)
+}
+
+// contextHandler ajoute aux entrées les identifiants de requête et de trace du contexte
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		record.AddAttrs(slog.String("trace_id", span.TraceID().String()), slog.String("span_id", span.SpanID().String()))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+var sensitiveKeys = []string{"password", "secret", "token", "authorization", "api_key", "apikey", "cookie"}
+
+func redact(groups []string, attr slog.Attr) slog.Attr {
+	key := strings.ToLower(attr.Key)
+	for _, sensitive := range sensitiveKeys {
+		if strings.Contains(key, sensitive) {
+			return slog.String(attr.Key, "[REDACTED]")
+		}
+	}
+	return attr
+}
@@ -0,0 +1,77 @@
+// Package metrics expose les métriques Prometheus communes aux services : requêtes HTTP
+// reçues, mesurées par le routeur de shared/web, appels sortants vers les autres services
+// et pool de connexions à la base.
+// Les compteurs métier sont déclarés par chaque service avec promauto.
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "Requêtes HTTP reçues, par route, méthode et code de réponse.",
+	}, []string{"route", "method", "status"})
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Durée de traitement des requêtes HTTP reçues.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+	httpInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "http_requests_in_flight",
+		Help: "Requêtes HTTP en cours de traitement.",
+	})
+
+	outboundCalls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "outbound_calls_total",
+		Help: "Appels vers les autres services, par appel et résultat.",
+	}, []string{"call", "result"})
+	outboundDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "outbound_call_duration_seconds",
+		Help:    "Durée des appels vers les autres services, nouvelles tentatives comprises.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"call", "result"})
+)
+
+// Handler sert les métriques au format texte de Prometheus
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// RegisterDB publie les statistiques du pool de connexions (connexions ouvertes, en attente...)
+func RegisterDB(db *sql.DB, name string) {
+	prometheus.MustRegister(collectors.NewDBStatsCollector(db, name))
+}
+
+// RequestStarted compte une requête en cours de traitement ; la fonction renvoyée la retire
+func RequestStarted() (done func()) {
+	httpInFlight.Inc()
+	return httpInFlight.Dec
+}
+
+// ObserveRequest enregistre une requête traitée ; route est le motif enregistré ("/orders/"),
+// jamais le chemin complet, pour que les identifiants ne multiplient pas les séries
+func ObserveRequest(route, method string, status int, duration time.Duration) {
+	code := strconv.Itoa(status)
+	httpRequests.WithLabelValues(route, method, code).Inc()
+	httpDuration.WithLabelValues(route, method, code).Observe(duration.Seconds())
+}
+
+// ObserveCall enregistre un appel sortant commencé à start ; ok indique s'il a abouti
+func ObserveCall(call string, start time.Time, ok bool) {
+	result := "success"
+	if !ok {
+		result = "failure"
+	}
+	outboundCalls.WithLabelValues(call, result).Inc()
+	outboundDuration.WithLabelValues(call, result).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,103 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// baselineSchema est le schéma de travail dans lequel Baseline rejoue la première migration
+const baselineSchema = "migrate_baseline"
+
+// Baseline adopte une base créée avant les migrations : la première migration est
+// enregistrée comme appliquée, sans être exécutée, si le schéma existant contient déjà
+// ses tables, colonnes et index. Pour le vérifier, son script est rejoué dans un schéma de
+// travail, dans une transaction annulée ensuite, et le résultat comparé au schéma courant.
+func (m *Migrator) Baseline(ctx context.Context) (Migration, error) {
+	if len(m.migrations) == 0 {
+		return Migration{}, errors.New("no migration to baseline")
+	}
+	first := m.migrations[0]
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		done, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if len(done) > 0 {
+			return fmt.Errorf("%s already has applied migrations", m.service)
+		}
+		if err := m.checkBaseline(ctx, conn, first); err != nil {
+			return err
+		}
+		_, err = conn.ExecContext(ctx, "INSERT INTO schema_migrations (service, version, name) VALUES ($1, $2, $3)",
+			m.service, first.Version, first.Name)
+		return err
+	})
+	return first, err
+}
+
+// checkBaseline compare le schéma courant à celui que produit la migration
+func (m *Migrator) checkBaseline(ctx context.Context, conn *sql.Conn, migration Migration) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// Le schéma de travail disparaît avec la transaction
+	defer tx.Rollback()
+
+	var current string
+	if err := tx.QueryRowContext(ctx, "SELECT current_schema()").Scan(&current); err != nil {
+		return err
+	}
+	existing, err := schemaObjects(ctx, tx, current)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "CREATE SCHEMA "+baselineSchema+"; SET LOCAL search_path TO "+baselineSchema); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, migration.Up); err != nil {
+		return fmt.Errorf("migration %s up: %w", migration, err)
+	}
+	expected, err := schemaObjects(ctx, tx, baselineSchema)
+	if err != nil {
+		return err
+	}
+
+	var missing []string
+	for object := range expected {
+		if !existing[object] {
+			missing = append(missing, object)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("existing schema does not match migration %s, missing: %s", migration, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// schemaObjects liste les colonnes des tables de schema, sous la forme "table.colonne type",
+// et leurs index, sous la forme "index nom"
+func schemaObjects(ctx context.Context, tx *sql.Tx, schema string) (map[string]bool, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT table_name || '.' || column_name || ' ' || data_type
+FROM information_schema.columns WHERE table_schema = $1
+UNION ALL
+SELECT 'index ' || indexname FROM pg_indexes WHERE schemaname = $1`, schema)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	objects := map[string]bool{}
+	for rows.Next() {
+		var object string
+		if err := rows.Scan(&object); err != nil {
+			return nil, err
+		}
+		objects[object] = true
+	}
+	return objects, rows.Err()
+}
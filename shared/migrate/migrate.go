@@ -0,0 +1,293 @@
+// Package migrate applique les migrations SQL versionnées des services.
+//
+// Chaque service embarque ses migrations dans son binaire, par paires de fichiers
+// <version>_<nom>.up.sql et <version>_<nom>.down.sql (0001_create_orders.up.sql). Une
+// migration s'exécute dans une transaction ; la table schema_migrations, partagée par les
+// services de la base, garde les versions appliquées par chacun.
+//
+// Une base créée avant les migrations s'adopte avec Baseline, qui vérifie que le schéma
+// existant correspond à la première migration avant de l'enregistrer comme appliquée.
+//
+// Up et Down prennent un verrou consultatif Postgres : les instances qui démarrent en même
+// temps pendant un déploiement appliquent les migrations l'une après l'autre, et la seconde
+// n'a plus rien à faire.
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lockKey est commun à tous les services : la table schema_migrations est partagée
+const lockKey = "schema_migrations"
+
+// Migration est une version du schéma et les scripts pour l'appliquer et l'annuler
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+func (m Migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.Version, m.Name)
+}
+
+// Status indique si une migration est appliquée, et depuis quand
+type Status struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// Migrator applique les migrations d'un service
+type Migrator struct {
+	db         *sql.DB
+	service    string
+	migrations []Migration
+}
+
+// New lit les migrations des fichiers .sql de fsys, dans tous ses répertoires ; deux
+// versions identiques ou un script manquant sont des erreurs
+func New(db *sql.DB, service string, fsys fs.FS) (*Migrator, error) {
+	migrations, err := load(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, service: service, migrations: migrations}, nil
+}
+
+func load(fsys fs.FS) ([]Migration, error) {
+	byVersion := map[int]*Migration{}
+	scripts := map[string]bool{}
+	err := fs.WalkDir(fsys, ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || !strings.HasSuffix(name, ".sql") {
+			return err
+		}
+		base := path.Base(name)
+		stem, direction, ok := strings.Cut(strings.TrimSuffix(base, ".sql"), ".")
+		version, label, found := strings.Cut(stem, "_")
+		number, convErr := strconv.Atoi(version)
+		if !ok || !found || convErr != nil || number <= 0 || (direction != "up" && direction != "down") {
+			return fmt.Errorf("migration %s: expected <version>_<name>.up.sql or .down.sql", base)
+		}
+		script, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+
+		m := byVersion[number]
+		if m == nil {
+			m = &Migration{Version: number, Name: label}
+			byVersion[number] = m
+		}
+		if m.Name != label {
+			return fmt.Errorf("migration %d: conflicting names %q and %q", number, m.Name, label)
+		}
+		key := fmt.Sprintf("%d.%s", number, direction)
+		if scripts[key] {
+			return fmt.Errorf("migration %s: duplicate %s script", m, direction)
+		}
+		scripts[key] = true
+		if direction == "up" {
+			m.Up = string(script)
+		} else {
+			m.Down = string(script)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		// Une migration doit pouvoir être annulée ; un script down vide, ou fait d'un
+		// commentaire, reste possible pour une migration sans retour
+		if !scripts[fmt.Sprintf("%d.up", m.Version)] || !scripts[fmt.Sprintf("%d.down", m.Version)] {
+			return nil, fmt.Errorf("migration %s: both up and down scripts are required", m)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Up applique les migrations en attente, dans l'ordre des versions
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var applied []Migration
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		done, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, migration := range m.migrations {
+			if _, ok := done[migration.Version]; ok {
+				continue
+			}
+			if err := m.run(ctx, conn, migration, true); err != nil {
+				if len(done) == 0 && isDuplicateTable(err) {
+					return fmt.Errorf("%w; adopt an existing schema with \"migrate baseline\"", err)
+				}
+				return err
+			}
+			applied = append(applied, migration)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down annule les steps dernières migrations appliquées
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	var reverted []Migration
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		done, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(m.migrations) - 1; i >= 0 && len(reverted) < steps; i-- {
+			migration := m.migrations[i]
+			if _, ok := done[migration.Version]; !ok {
+				continue
+			}
+			if err := m.run(ctx, conn, migration, false); err != nil {
+				return err
+			}
+			reverted = append(reverted, migration)
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// Status liste les migrations connues du service ; une version appliquée par une version
+// plus récente du service, inconnue de celle-ci, n'y figure pas
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	done, err := m.applied(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]Status, len(m.migrations))
+	for i, migration := range m.migrations {
+		statuses[i] = Status{Migration: migration}
+		if appliedAt, ok := done[migration.Version]; ok {
+			statuses[i].AppliedAt = &appliedAt
+		}
+	}
+	return statuses, nil
+}
+
+// Pending renvoie les migrations qui ne sont pas encore appliquées
+func (m *Migrator) Pending(ctx context.Context) ([]Migration, error) {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var pending []Migration
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			pending = append(pending, status.Migration)
+		}
+	}
+	return pending, nil
+}
+
+// withLock exécute fn sur une connexion qui détient le verrou de migration ; un verrou
+// consultatif appartient à la session, d'où la connexion dédiée
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock(hashtext($1))", lockKey); err != nil {
+		return fmt.Errorf("acquire migration lock: %w", err)
+	}
+	// Le verrou est libéré même si ctx est annulé
+	defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock(hashtext($1))", lockKey)
+
+	if _, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+	service text NOT NULL,
+	version bigint NOT NULL,
+	name text NOT NULL,
+	applied_at timestamptz NOT NULL DEFAULT now(),
+	PRIMARY KEY (service, version)
+)`); err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+// applied renvoie la date d'application de chaque version appliquée
+func (m *Migrator) applied(ctx context.Context, conn *sql.Conn) (map[int]time.Time, error) {
+	done := map[int]time.Time{}
+	var exists bool
+	if err := conn.QueryRowContext(ctx, "SELECT to_regclass('schema_migrations') IS NOT NULL").Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return done, nil
+	}
+	rows, err := conn.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations WHERE service = $1", m.service)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		done[version] = appliedAt
+	}
+	return done, rows.Err()
+}
+
+// isDuplicateTable reconnaît l'erreur Postgres 42P07 d'une table qui existe déjà
+func isDuplicateTable(err error) bool {
+	var pgErr interface{ SQLState() string }
+	return errors.As(err, &pgErr) && pgErr.SQLState() == "42P07"
+}
+
+// run applique ou annule une migration et met à jour schema_migrations dans la même transaction
+func (m *Migrator) run(ctx context.Context, conn *sql.Conn, migration Migration, up bool) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	script, direction := migration.Up, "up"
+	if !up {
+		script, direction = migration.Down, "down"
+	}
+	// Sans paramètre, le script est envoyé tel quel et peut contenir plusieurs instructions
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return fmt.Errorf("migration %s %s: %w", migration, direction, err)
+	}
+	if up {
+		_, err = tx.ExecContext(ctx, "INSERT INTO schema_migrations (service, version, name) VALUES ($1, $2, $3)",
+			m.service, migration.Version, migration.Name)
+	} else {
+		_, err = tx.ExecContext(ctx, "DELETE FROM schema_migrations WHERE service = $1 AND version = $2",
+			m.service, migration.Version)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
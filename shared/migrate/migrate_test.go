@@ -0,0 +1,457 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name      string
+		files     fstest.MapFS
+		want      []string
+		wantError string
+	}{
+		{
+			name: "sorted by version, not by file name",
+			files: fstest.MapFS{
+				"migrations/0010_add_index.up.sql":    {Data: []byte("CREATE INDEX")},
+				"migrations/0010_add_index.down.sql":  {Data: []byte("DROP INDEX")},
+				"migrations/0002_add_column.up.sql":   {Data: []byte("ALTER TABLE")},
+				"migrations/0002_add_column.down.sql": {Data: []byte("ALTER TABLE")},
+				"migrations/0001_initial.up.sql":      {Data: []byte("CREATE TABLE")},
+				"migrations/0001_initial.down.sql":    {Data: []byte("DROP TABLE")},
+				"migrations/README.md":                {Data: []byte("ignored")},
+			},
+			want: []string{"0001_initial", "0002_add_column", "0010_add_index"},
+		},
+		{
+			name: "versions without leading zeros",
+			files: fstest.MapFS{
+				"9_ninth.up.sql":    {},
+				"9_ninth.down.sql":  {},
+				"10_tenth.up.sql":   {},
+				"10_tenth.down.sql": {},
+			},
+			want: []string{"0009_ninth", "0010_tenth"},
+		},
+		{
+			name: "missing down script",
+			files: fstest.MapFS{
+				"0001_initial.up.sql": {},
+			},
+			wantError: "both up and down scripts are required",
+		},
+		{
+			name: "duplicate version",
+			files: fstest.MapFS{
+				"0001_initial.up.sql":   {},
+				"0001_initial.down.sql": {},
+				"0001_other.up.sql":     {},
+				"0001_other.down.sql":   {},
+			},
+			wantError: "conflicting names",
+		},
+		{
+			name: "same script in two directories",
+			files: fstest.MapFS{
+				"a/0001_initial.up.sql":   {},
+				"b/0001_initial.up.sql":   {},
+				"a/0001_initial.down.sql": {},
+			},
+			wantError: "duplicate up script",
+		},
+		{
+			name:      "invalid file name",
+			files:     fstest.MapFS{"initial.up.sql": {}},
+			wantError: "expected <version>_<name>.up.sql",
+		},
+		{
+			name:      "invalid direction",
+			files:     fstest.MapFS{"0001_initial.sideways.sql": {}},
+			wantError: "expected <version>_<name>.up.sql",
+		},
+		{
+			name:      "version zero",
+			files:     fstest.MapFS{"0000_initial.up.sql": {}},
+			wantError: "expected <version>_<name>.up.sql",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			migrations, err := load(tt.files)
+			if tt.wantError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantError) {
+					t.Fatalf("load() error = %v, want %q", err, tt.wantError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("load() error = %v", err)
+			}
+			var names []string
+			for _, m := range migrations {
+				names = append(names, m.String())
+			}
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("load() = %v, want %v", names, tt.want)
+			}
+		})
+	}
+}
+
+var testMigrations = fstest.MapFS{
+	"0001_initial.up.sql":      {Data: []byte("CREATE TABLE orders")},
+	"0001_initial.down.sql":    {Data: []byte("DROP TABLE orders")},
+	"0002_add_status.up.sql":   {Data: []byte("ALTER TABLE orders ADD status")},
+	"0002_add_status.down.sql": {Data: []byte("ALTER TABLE orders DROP status")},
+	"0003_add_index.up.sql":    {Data: []byte("CREATE INDEX orders_status")},
+	"0003_add_index.down.sql":  {Data: []byte("DROP INDEX orders_status")},
+}
+
+func TestMigratorUpDown(t *testing.T) {
+	tests := []struct {
+		name        string
+		applied     []int
+		failOn      string
+		down        int
+		wantApplied []int
+		wantScripts []string
+		wantError   bool
+	}{
+		{
+			name:        "applies pending migrations in order",
+			wantApplied: []int{1, 2, 3},
+			wantScripts: []string{"CREATE TABLE orders", "ALTER TABLE orders ADD status", "CREATE INDEX orders_status"},
+		},
+		{
+			name:        "skips applied migrations",
+			applied:     []int{1, 2},
+			wantApplied: []int{1, 2, 3},
+			wantScripts: []string{"CREATE INDEX orders_status"},
+		},
+		{
+			name:        "nothing to apply",
+			applied:     []int{1, 2, 3},
+			wantApplied: []int{1, 2, 3},
+		},
+		{
+			name:        "stops at the failing migration",
+			failOn:      "ALTER TABLE orders ADD status",
+			wantApplied: []int{1},
+			// Le script en échec est annulé avec sa transaction
+			wantScripts: []string{"CREATE TABLE orders", "ALTER TABLE orders ADD status"},
+			wantError:   true,
+		},
+		{
+			name:        "reverts the last migrations",
+			applied:     []int{1, 2, 3},
+			down:        2,
+			wantApplied: []int{1},
+			wantScripts: []string{"DROP INDEX orders_status", "ALTER TABLE orders DROP status"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeServer(tt.applied...)
+			server.failOn = tt.failOn
+			migrator, err := New(server.open(), "order-service", testMigrations)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.down > 0 {
+				_, err = migrator.Down(context.Background(), tt.down)
+			} else {
+				_, err = migrator.Up(context.Background())
+			}
+			if (err != nil) != tt.wantError {
+				t.Fatalf("error = %v, want error %v", err, tt.wantError)
+			}
+			if got := server.appliedVersions(); !reflect.DeepEqual(got, tt.wantApplied) {
+				t.Errorf("applied versions = %v, want %v", got, tt.wantApplied)
+			}
+			if got := server.scripts(); !reflect.DeepEqual(got, tt.wantScripts) {
+				t.Errorf("scripts = %v, want %v", got, tt.wantScripts)
+			}
+			if server.locked {
+				t.Error("migration lock was not released")
+			}
+		})
+	}
+}
+
+// Les scripts s'exécutent sur la connexion qui détient le verrou, entre sa prise et sa libération
+func TestMigratorLock(t *testing.T) {
+	server := newFakeServer()
+	server.failOn = "CREATE INDEX orders_status"
+	migrator, err := New(server.open(), "order-service", testMigrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	migrator.Up(context.Background())
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	lockConn, locked := -1, false
+	for _, statement := range server.log {
+		switch {
+		case strings.HasPrefix(statement.query, "SELECT pg_advisory_lock"):
+			lockConn, locked = statement.conn, true
+		case strings.HasPrefix(statement.query, "SELECT pg_advisory_unlock"):
+			if statement.conn != lockConn {
+				t.Errorf("lock released on connection %d, taken on %d", statement.conn, lockConn)
+			}
+			locked = false
+		case !locked:
+			t.Errorf("%q executed without the migration lock", statement.query)
+		case statement.conn != lockConn:
+			t.Errorf("%q executed on connection %d, lock held by %d", statement.query, statement.conn, lockConn)
+		}
+	}
+	if lockConn == -1 || locked {
+		t.Error("migration lock was not taken and released")
+	}
+}
+
+// Deux instances qui démarrent ensemble appliquent les migrations une seule fois
+func TestMigratorConcurrentUp(t *testing.T) {
+	server := newFakeServer()
+	var wg sync.WaitGroup
+	applied := make([][]Migration, 4)
+	for i := range applied {
+		migrator, err := New(server.open(), "order-service", testMigrations)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var err error
+			if applied[i], err = migrator.Up(context.Background()); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	total := 0
+	for _, migrations := range applied {
+		total += len(migrations)
+	}
+	if total != len(testMigrations)/2 {
+		t.Errorf("%d migrations applied in total, want %d", total, len(testMigrations)/2)
+	}
+	if got, want := len(server.scripts()), len(testMigrations)/2; got != want {
+		t.Errorf("%d scripts executed, want %d", got, want)
+	}
+}
+
+// fakeServer simule les réponses de Postgres dont Migrator a besoin : le verrou
+// consultatif, la table schema_migrations et l'exécution des scripts
+type fakeServer struct {
+	mu      sync.Mutex
+	lock    sync.Mutex
+	locked  bool
+	applied map[int]time.Time
+	log     []statement
+	conns   int
+	failOn  string
+}
+
+type statement struct {
+	conn  int
+	query string
+}
+
+func newFakeServer(applied ...int) *fakeServer {
+	server := &fakeServer{applied: map[int]time.Time{}}
+	for _, version := range applied {
+		server.applied[version] = time.Now()
+	}
+	return server
+}
+
+func (s *fakeServer) open() *sql.DB {
+	return sql.OpenDB(fakeConnector{s})
+}
+
+func (s *fakeServer) appliedVersions() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var versions []int
+	for version := 1; version <= 3; version++ {
+		if _, ok := s.applied[version]; ok {
+			versions = append(versions, version)
+		}
+	}
+	return versions
+}
+
+// scripts renvoie les scripts de migration exécutés, sans les requêtes de Migrator
+func (s *fakeServer) scripts() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var scripts []string
+	for _, statement := range s.log {
+		if !strings.HasPrefix(statement.query, "SELECT") && !strings.Contains(statement.query, "schema_migrations") &&
+			statement.query != "BEGIN" && statement.query != "COMMIT" && statement.query != "ROLLBACK" {
+			scripts = append(scripts, statement.query)
+		}
+	}
+	return scripts
+}
+
+func (s *fakeServer) exec(conn int, query string, args []driver.NamedValue) error {
+	if strings.HasPrefix(query, "SELECT pg_advisory_lock") {
+		// Hors de s.mu, pour que les autres connexions continuent pendant l'attente
+		s.lock.Lock()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.log = append(s.log, statement{conn: conn, query: query})
+	switch {
+	case strings.HasPrefix(query, "SELECT pg_advisory_lock"):
+		s.locked = true
+	case strings.HasPrefix(query, "SELECT pg_advisory_unlock"):
+		s.locked = false
+		s.lock.Unlock()
+	case strings.HasPrefix(query, "INSERT INTO schema_migrations"):
+		s.applied[int(args[1].Value.(int64))] = time.Now()
+	case strings.HasPrefix(query, "DELETE FROM schema_migrations"):
+		delete(s.applied, int(args[1].Value.(int64)))
+	case query == s.failOn:
+		return errors.New("syntax error")
+	}
+	return nil
+}
+
+func (s *fakeServer) query(conn int, query string) (driver.Rows, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.log = append(s.log, statement{conn: conn, query: query})
+	switch {
+	case strings.HasPrefix(query, "SELECT to_regclass"):
+		return &fakeRows{columns: []string{"exists"}, values: [][]driver.Value{{true}}}, nil
+	case strings.HasPrefix(query, "SELECT version, applied_at FROM schema_migrations"):
+		rows := &fakeRows{columns: []string{"version", "applied_at"}}
+		for version, appliedAt := range s.applied {
+			rows.values = append(rows.values, []driver.Value{int64(version), appliedAt})
+		}
+		return rows, nil
+	}
+	return nil, fmt.Errorf("unexpected query %q", query)
+}
+
+type fakeConnector struct {
+	server *fakeServer
+}
+
+func (c fakeConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	c.server.mu.Lock()
+	defer c.server.mu.Unlock()
+	c.server.conns++
+	return &fakeConn{server: c.server, id: c.server.conns}, nil
+}
+
+func (c fakeConnector) Driver() driver.Driver {
+	return nil
+}
+
+type fakeConn struct {
+	server *fakeServer
+	id     int
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("prepared statements are not supported")
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *fakeConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	c.server.exec(c.id, "BEGIN", nil)
+	return fakeTx{c}, nil
+}
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if err := c.server.exec(c.id, query, args); err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return c.server.query(c.id, query)
+}
+
+type fakeTx struct {
+	conn *fakeConn
+}
+
+func (tx fakeTx) Commit() error {
+	return tx.conn.server.exec(tx.conn.id, "COMMIT", nil)
+}
+
+func (tx fakeTx) Rollback() error {
+	return tx.conn.server.exec(tx.conn.id, "ROLLBACK", nil)
+}
+
+type fakeRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+	return r.columns
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+type sqlStateError string
+
+func (e sqlStateError) Error() string    { return "postgres error " + string(e) }
+func (e sqlStateError) SQLState() string { return string(e) }
+
+func TestIsDuplicateTable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{err: sqlStateError("42P07"), want: true},
+		{err: fmt.Errorf("migration 0001_initial up: %w", sqlStateError("42P07")), want: true},
+		{err: sqlStateError("42601"), want: false},
+		{err: errors.New("relation already exists"), want: false},
+	}
+	for _, tt := range tests {
+		if got := isDuplicateTable(tt.err); got != tt.want {
+			t.Errorf("isDuplicateTable(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// Seed exécute les scripts .sql de fsys, des données de démonstration qui n'ont pas leur
+// place dans les migrations. Les scripts s'exécutent dans l'ordre de leur nom, chacun dans
+// une transaction, et doivent pouvoir être rejoués sans créer de doublon.
+func (m *Migrator) Seed(ctx context.Context, fsys fs.FS) ([]string, error) {
+	var names []string
+	err := fs.WalkDir(fsys, ".", func(name string, entry fs.DirEntry, err error) error {
+		if err == nil && !entry.IsDir() && strings.HasSuffix(name, ".sql") {
+			names = append(names, name)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var seeded []string
+	err = m.withLock(ctx, func(conn *sql.Conn) error {
+		for _, name := range names {
+			script, err := fs.ReadFile(fsys, name)
+			if err != nil {
+				return err
+			}
+			tx, err := conn.BeginTx(ctx, nil)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, string(script)); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("seed %s: %w", path.Base(name), err)
+			}
+			if err := tx.Commit(); err != nil {
+				return err
+			}
+			seeded = append(seeded, path.Base(name))
+		}
+		return nil
+	})
+	return seeded, err
+}
@@ -0,0 +1,162 @@
+// Package outbox publie les événements métier des services sans perte ni publication fantôme.
+//
+// Un événement est écrit dans la table d'outbox du service, dans la même transaction que la
+// modification qu'il décrit : il n'est publié que si la modification a été validée, et
+// jamais perdu. Le relais, lancé en tâche de fond, publie ensuite les messages sur le bus :
+//
+//	events = outbox.Outbox{DB: db, Table: "order_outbox", Publisher: eventBus}
+//	svc.Go(events.Run)
+//	...
+//	events.Enqueue(tx, event)
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+	"shared/bus"
+)
+
+const (
+	pollInterval = time.Second
+	batchSize    = 100
+	maxBackoff   = 5 * time.Minute
+)
+
+// Event décrit un changement d'état d'un agrégat pour les autres services ; il est publié
+// en JSON sous le sujet Type
+type Event struct {
+	ID            string      `json:"id"`
+	Type          string      `json:"type"`
+	AggregateType string      `json:"aggregate_type"`
+	AggregateID   string      `json:"aggregate_id"`
+	UserID        string      `json:"user_id"`
+	OccurredAt    time.Time   `json:"occurred_at"`
+	Data          interface{} `json:"data"`
+}
+
+// Message est une ligne de la table d'outbox
+type Message struct {
+	ID            uint `gorm:"primaryKey;autoIncrement"`
+	EventID       string
+	EventType     string
+	AggregateType string
+	AggregateID   string
+	Payload       []byte
+	CreatedAt     time.Time
+	PublishedAt   *time.Time
+	Attempts      int
+	LastError     string
+	NextAttemptAt time.Time
+}
+
+// Outbox est la table d'outbox d'un service et le bus sur lequel le relais la publie
+type Outbox struct {
+	DB *gorm.DB
+	// Table est propre au service ("order_outbox") et créée par ses migrations
+	Table     string
+	Publisher bus.Publisher
+}
+
+// Enqueue écrit l'événement dans l'outbox ; tx est la transaction de la modification
+func (o Outbox) Enqueue(tx *gorm.DB, event Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return tx.Table(o.Table).Create(&Message{
+		EventID:       event.ID,
+		EventType:     event.Type,
+		AggregateType: event.AggregateType,
+		AggregateID:   event.AggregateID,
+		Payload:       payload,
+		NextAttemptAt: time.Now(),
+	}).Error
+}
+
+// Run publie l'outbox à intervalle régulier jusqu'à l'annulation de ctx
+func (o Outbox) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		if err := o.relay(); err != nil {
+			slog.Error("outbox relay failed", "table", o.Table, "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// relay publie les messages en attente dans l'ordre d'écriture. Le verrou consultatif
+// réserve le relais à une seule instance, et un message en échec bloque les suivants du
+// même agrégat pour préserver leur ordre. Un arrêt entre la publication et la mise à jour
+// provoque une nouvelle publication : les abonnés dédoublonnent sur l'ID de l'événement.
+func (o Outbox) relay() error {
+	return o.DB.Transaction(func(tx *gorm.DB) error {
+		var locked bool
+		if err := tx.Raw("SELECT pg_try_advisory_xact_lock(hashtext(?))", o.Table).Scan(&locked).Error; err != nil || !locked {
+			return err
+		}
+		var messages []Message
+		if err := tx.Table(o.Table).Where("published_at IS NULL").Order("id").Limit(batchSize).Find(&messages).Error; err != nil {
+			return err
+		}
+
+		blocked := map[string]bool{}
+		for _, message := range messages {
+			aggregate := message.AggregateType + "/" + message.AggregateID
+			if blocked[aggregate] {
+				continue
+			}
+			now := time.Now()
+			if message.NextAttemptAt.After(now) {
+				blocked[aggregate] = true
+				continue
+			}
+			row := tx.Table(o.Table).Where("id = ?", message.ID)
+			if err := o.publish(message); err != nil {
+				blocked[aggregate] = true
+				attempts := message.Attempts + 1
+				slog.Warn("failed to publish event", "event_type", message.EventType, "event_id", message.EventID, "attempt", attempts, "error", err)
+				if err := row.Updates(map[string]interface{}{
+					"attempts":        attempts,
+					"last_error":      err.Error(),
+					"next_attempt_at": now.Add(backoff(attempts)),
+				}).Error; err != nil {
+					return err
+				}
+				continue
+			}
+			if err := row.Update("published_at", now).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (o Outbox) publish(message Message) error {
+	return o.Publisher.Publish(context.Background(), bus.Message{
+		ID:      message.EventID,
+		Subject: message.EventType,
+		Key:     message.AggregateType + "/" + message.AggregateID,
+		Data:    message.Payload,
+	})
+}
+
+// backoff double le délai à chaque échec, de deux secondes après le premier jusqu'à maxBackoff
+func backoff(attempts int) time.Duration {
+	if attempts > 8 {
+		return maxBackoff
+	}
+	if delay := time.Second << uint(attempts); delay < maxBackoff {
+		return delay
+	}
+	return maxBackoff
+}
@@ -0,0 +1,122 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"shared/web"
+)
+
+const checkTimeout = 2 * time.Second
+
+// Check vérifie une dépendance ; une erreur rend le service indisponible
+type Check func(ctx context.Context) error
+
+type checkResult struct {
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+type readiness struct {
+	Status string                 `json:"status"`
+	Checks map[string]checkResult `json:"checks"`
+}
+
+// AddCheck ajoute une vérification à /readyz
+func (s *Service) AddCheck(name string, check Check) {
+	s.checksMu.Lock()
+	defer s.checksMu.Unlock()
+	s.checks[name] = check
+}
+
+// DependsOn ajoute à /readyz un service en aval, interrogé sur son /livez : l'état de
+// ses propres dépendances ne se propage pas en cascade
+func (s *Service) DependsOn(name, baseURL string) {
+	client := &http.Client{Timeout: checkTimeout}
+	s.AddCheck(name, func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, "GET", baseURL+"/livez", nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("returned status %d", resp.StatusCode)
+		}
+		return nil
+	})
+}
+
+func (s *Service) addDatabaseChecks() {
+	s.AddCheck("database", func(ctx context.Context) error {
+		sqlDB, err := s.DB.DB()
+		if err != nil {
+			return err
+		}
+		return sqlDB.PingContext(ctx)
+	})
+	s.AddCheck("migrations", s.checkMigrations)
+}
+
+// livezHandler répond tant que le processus sert des requêtes, sans vérifier ses dépendances
+func (s *Service) livezHandler(w http.ResponseWriter, r *http.Request) {
+	web.WriteJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// readyzHandler vérifie les dépendances en parallèle ; il échoue aussi pendant l'arrêt
+func (s *Service) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	report := readiness{Status: "ok", Checks: s.runChecks(r.Context())}
+	for _, result := range report.Checks {
+		if result.Status != "ok" {
+			report.Status = "fail"
+		}
+	}
+	if s.Draining() {
+		report.Status = "draining"
+	}
+	status := http.StatusOK
+	if report.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+	web.WriteJSON(w, status, report)
+}
+
+func (s *Service) runChecks(ctx context.Context) map[string]checkResult {
+	s.checksMu.Lock()
+	checks := make(map[string]Check, len(s.checks))
+	for name, check := range s.checks {
+		checks[name] = check
+	}
+	s.checksMu.Unlock()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	results := make(map[string]checkResult, len(checks))
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check Check) {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, checkTimeout)
+			defer cancel()
+			start := time.Now()
+			err := check(checkCtx)
+			result := checkResult{Status: "ok", LatencyMS: float64(time.Since(start).Microseconds()) / 1000}
+			if err != nil {
+				result.Status = "fail"
+				result.Error = err.Error()
+			}
+			mu.Lock()
+			results[name] = result
+			mu.Unlock()
+		}(name, check)
+	}
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,178 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"shared/logging"
+	"shared/migrate"
+)
+
+// Migrate vérifie que le schéma de la base est à jour des migrations du service, les
+// fichiers <version>_<nom>.up.sql et .down.sql de fsys :
+//
+//	//go:embed migrations/*.sql
+//	var migrations embed.FS
+//
+//	svc.Migrate(migrations)
+//
+// Un schéma en retard arrête le service, sauf avec DB_MIGRATE_ON_START qui applique
+// d'abord les migrations en attente. Lancé avec la sous-commande migrate, le binaire
+// exécute la commande puis s'arrête :
+//
+//	order-service migrate up|down [n]|status|baseline
+//
+// baseline adopte une base créée avant les migrations, voir migrate.Migrator.Baseline.
+func (s *Service) Migrate(fsys fs.FS) {
+	sqlDB, err := s.DB.DB()
+	if err != nil {
+		logging.Fatal("failed to access database", "error", err)
+	}
+	migrator, err := migrate.New(sqlDB, s.id, fsys)
+	if err != nil {
+		logging.Fatal("invalid migrations", "error", err)
+	}
+	s.migrator = migrator
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		s.runMigrateCommand(os.Args[2:])
+		os.Exit(0)
+	}
+
+	if s.migrateOnStart {
+		s.migrateUp()
+	}
+	pending, err := migrator.Pending(s.ctx)
+	if err != nil {
+		logging.Fatal("failed to read migration status", "error", err)
+	}
+	if len(pending) > 0 {
+		logging.Fatal("database schema is behind, run \"migrate up\" first", "pending", migrationNames(pending))
+	}
+	s.migrated.Store(true)
+}
+
+func (s *Service) runMigrateCommand(args []string) {
+	usage := fmt.Sprintf("usage: %s migrate up|down [n]|status|baseline", s.id)
+	if len(args) == 0 {
+		logging.Fatal(usage)
+	}
+	switch args[0] {
+	case "up":
+		s.migrateUp()
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				logging.Fatal(usage)
+			}
+			steps = n
+		}
+		reverted, err := s.migrator.Down(s.ctx, steps)
+		for _, m := range reverted {
+			slog.Info("migration reverted", "migration", m.String())
+		}
+		if err != nil {
+			logging.Fatal("failed to revert migrations", "error", err)
+		}
+		if len(reverted) == 0 {
+			slog.Info("no migration to revert")
+		}
+	case "status":
+		statuses, err := s.migrator.Status(s.ctx)
+		if err != nil {
+			logging.Fatal("failed to read migration status", "error", err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.AppliedAt != nil {
+				appliedAt = status.AppliedAt.UTC().Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", status.Version, status.Name, appliedAt)
+		}
+		w.Flush()
+	case "baseline":
+		migration, err := s.migrator.Baseline(s.ctx)
+		if err != nil {
+			logging.Fatal("failed to baseline database", "error", err)
+		}
+		slog.Info("migration marked as applied", "migration", migration.String())
+	default:
+		logging.Fatal(usage)
+	}
+}
+
+// Seed charge les données de démonstration, les scripts .sql de fsys, avec DB_SEED_DEMO ou
+// la sous-commande seed ; à appeler après Migrate :
+//
+//	//go:embed seeds/*.sql
+//	var seeds embed.FS
+//
+//	svc.Seed(seeds)
+func (s *Service) Seed(fsys fs.FS) {
+	command := len(os.Args) > 1 && os.Args[1] == "seed"
+	if !command && !s.seedDemo {
+		return
+	}
+	seeded, err := s.migrator.Seed(s.ctx, fsys)
+	for _, name := range seeded {
+		slog.Info("demo data loaded", "seed", name)
+	}
+	if err != nil {
+		logging.Fatal("failed to load demo data", "error", err)
+	}
+	if command {
+		os.Exit(0)
+	}
+}
+
+// migrateUp applique les migrations en attente, en attendant au besoin qu'une autre
+// instance ait fini les siennes
+func (s *Service) migrateUp() {
+	applied, err := s.migrator.Up(s.ctx)
+	for _, m := range applied {
+		slog.Info("migration applied", "migration", m.String())
+	}
+	if err != nil {
+		logging.Fatal("failed to migrate database", "error", err)
+	}
+	if len(applied) == 0 {
+		slog.Info("database schema is up to date")
+	}
+}
+
+// checkMigrations échoue si une migration connue du service n'est pas appliquée, par
+// exemple après un "migrate down" ; une migration plus récente, appliquée par une nouvelle
+// version du service pendant un déploiement, ne rend pas celle-ci indisponible
+func (s *Service) checkMigrations(ctx context.Context) error {
+	if !s.migrated.Load() {
+		return errors.New("migrations have not run")
+	}
+	pending, err := s.migrator.Pending(ctx)
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("pending migrations: %s", migrationNames(pending))
+	}
+	return nil
+}
+
+func migrationNames(migrations []migrate.Migration) string {
+	names := make([]string, len(migrations))
+	for i, m := range migrations {
+		names[i] = m.String()
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,256 @@
+// Package service démarre un service : configuration, base de données, routeur et serveur HTTP.
+//
+//	svc := service.New("Product Service", &cfg)
+//	svc.Migrate(migrations)
+//	svc.Go(events.Run)
+//	svc.Router.Handle("/products", products.Collection(), svc.RequireUser())
+//	svc.Run()
+//
+// /livez indique que le processus répond, /readyz vérifie la base, les migrations et les
+// services en aval déclarés avec DependsOn, et /metrics expose les métriques Prometheus.
+//
+// Le schéma de la base est décrit par les migrations SQL versionnées du service, que
+// Migrate vérifie au démarrage et que la sous-commande migrate applique.
+//
+// Run s'arrête proprement sur SIGINT ou SIGTERM : /readyz passe en échec, les requêtes en
+// cours se terminent, puis les tâches de fond, les hooks OnStop et la base sont fermés.
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"shared/config"
+	"shared/logging"
+	"shared/metrics"
+	"shared/migrate"
+	"shared/tracing"
+	"shared/web"
+)
+
+type Service struct {
+	Name   string
+	DB     *gorm.DB
+	Router *web.Router
+
+	// id est le nom du service dans les logs, les traces, les métriques et schema_migrations
+	id             string
+	server         config.Server
+	authServiceURL string
+	internalToken  string
+	adminUserIDs   []string
+
+	// ctx est annulé à l'arrêt, pour interrompre les tâches de fond
+	ctx         context.Context
+	cancel      context.CancelFunc
+	workers     sync.WaitGroup
+	draining    atomic.Bool
+	shutdownFns []func()
+	stopFns     []func()
+
+	checksMu       sync.Mutex
+	checks         map[string]Check
+	migrator       *migrate.Migrator
+	migrateOnStart bool
+	seedDemo       bool
+	migrated       atomic.Bool
+}
+
+// New charge la configuration dans cfg, un pointeur vers une structure qui embarque
+// config.Server, puis ouvre la base si elle embarque aussi config.Database. config.Auth,
+// config.Internal et config.Admin, s'ils sont embarqués, configurent RequireUser,
+// RequireInternal et RequireAdmin.
+func New(name string, cfg interface{}) *Service {
+	config.MustLoad(cfg)
+	s := &Service{Name: name, Router: web.NewRouter(), checks: map[string]Check{}}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	// "Order Service" devient "order-service" dans les logs, les traces et les métriques
+	s.id = strings.ToLower(strings.ReplaceAll(name, " ", "-"))
+	if server, ok := cfg.(interface{ ServerSettings() config.Server }); ok {
+		s.server = server.ServerSettings()
+	}
+	level, err := s.server.Logging.SlogLevel()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	logging.Setup(s.id, level)
+	shutdownTracing, err := tracing.Setup(s.id, s.server.Tracing)
+	if err != nil {
+		logging.Fatal("failed to set up tracing", "error", err)
+	}
+	// Enregistré en premier, il est appelé en dernier, pour exporter les spans de l'arrêt
+	s.OnStop(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			slog.Error("failed to flush traces", "error", err)
+		}
+	})
+	if auth, ok := cfg.(interface{ AuthService() string }); ok {
+		s.authServiceURL = auth.AuthService()
+		s.DependsOn("auth-service", s.authServiceURL)
+	}
+	if internal, ok := cfg.(interface{ InternalToken() string }); ok {
+		s.internalToken = internal.InternalToken()
+	}
+	if admin, ok := cfg.(interface{ AdminIDs() []string }); ok {
+		s.adminUserIDs = admin.AdminIDs()
+	}
+	if database, ok := cfg.(interface{ DatabaseSettings() config.Database }); ok {
+		settings := database.DatabaseSettings()
+		s.migrateOnStart = settings.MigrateOnStart
+		s.seedDemo = settings.SeedDemo
+		db, err := gorm.Open(postgres.Open(settings.DSN()), &gorm.Config{Logger: gormLogger(), TranslateError: true})
+		if err != nil {
+			logging.Fatal("failed to connect database", "error", err)
+		}
+		if err := tracing.InstrumentDB(db); err != nil {
+			logging.Fatal("failed to trace database queries", "error", err)
+		}
+		if sqlDB, err := db.DB(); err == nil {
+			metrics.RegisterDB(sqlDB, s.id)
+		}
+		s.DB = db
+		s.addDatabaseChecks()
+	}
+	s.Router.Use(web.RequestID, web.AccessLog)
+	s.Router.Handle("/metrics", metrics.Handler())
+	s.Router.HandleFunc("/livez", s.livezHandler)
+	s.Router.HandleFunc("/readyz", s.readyzHandler)
+	// Ancienne route, conservée pour les sondes existantes
+	s.Router.HandleFunc("/health", s.readyzHandler)
+	return s
+}
+
+// RequireUser exige un jeton utilisateur valide, vérifié par auth-service
+func (s *Service) RequireUser() web.Middleware {
+	return web.RequireUser(s.authServiceURL)
+}
+
+// RequireInternal réserve une route aux autres services
+func (s *Service) RequireInternal() web.Middleware {
+	return web.RequireInternalToken(s.internalToken)
+}
+
+// RequireUserOrInternal accepte un utilisateur authentifié ou un autre service
+func (s *Service) RequireUserOrInternal() web.Middleware {
+	return web.RequireUserOrInternal(s.authServiceURL, s.internalToken)
+}
+
+// RequireAdmin réserve une route aux utilisateurs de ADMIN_USER_IDS ; il suit RequireUser
+func (s *Service) RequireAdmin() web.Middleware {
+	return web.RequireAdmin(s.adminUserIDs)
+}
+
+// Go lance une tâche de fond ; son contexte est annulé à l'arrêt et Run attend qu'elle rende la main
+func (s *Service) Go(task func(ctx context.Context)) {
+	s.workers.Add(1)
+	go func() {
+		defer s.workers.Done()
+		task(s.ctx)
+	}()
+}
+
+// OnShutdown enregistre une fonction appelée dès le début de la fermeture du serveur HTTP,
+// pour terminer les connexions longues (flux SSE) que Shutdown n'interrompt pas
+func (s *Service) OnShutdown(fn func()) {
+	s.shutdownFns = append(s.shutdownFns, fn)
+}
+
+// OnStop enregistre une fonction appelée une fois les requêtes et les tâches de fond
+// terminées, avant la fermeture de la base ; les fonctions sont appelées dans l'ordre inverse
+func (s *Service) OnStop(fn func()) {
+	s.stopFns = append(s.stopFns, fn)
+}
+
+// Draining indique que le service s'arrête et ne doit plus recevoir de trafic
+func (s *Service) Draining() bool {
+	return s.draining.Load()
+}
+
+func (s *Service) Run() {
+	srv := &http.Server{
+		Addr:              s.server.Addr(),
+		Handler:           s.Router,
+		ReadHeaderTimeout: s.server.ReadHeaderTimeout,
+		ReadTimeout:       s.server.ReadTimeout,
+		WriteTimeout:      s.server.WriteTimeout,
+		IdleTimeout:       s.server.IdleTimeout,
+	}
+	for _, fn := range s.shutdownFns {
+		srv.RegisterOnShutdown(fn)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	errs := make(chan error, 1)
+	go func() {
+		slog.Info("service started", "addr", srv.Addr)
+		errs <- srv.ListenAndServe()
+	}()
+	select {
+	case err := <-errs:
+		logging.Fatal("server stopped", "error", err)
+	case <-ctx.Done():
+	}
+	// Un second signal arrête le processus immédiatement
+	stop()
+
+	slog.Info("service is shutting down", "drain_delay", s.server.DrainDelay.String())
+	s.draining.Store(true)
+	time.Sleep(s.server.DrainDelay)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.server.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("failed to close HTTP connections", "error", err)
+	}
+	s.cancel()
+	s.waitWorkers(shutdownCtx)
+	for i := len(s.stopFns) - 1; i >= 0; i-- {
+		s.stopFns[i]()
+	}
+	if s.DB != nil {
+		if sqlDB, err := s.DB.DB(); err == nil {
+			sqlDB.Close()
+		}
+	}
+	slog.Info("service stopped")
+}
+
+func (s *Service) waitWorkers(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		s.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		slog.Warn("background tasks did not stop before the shutdown timeout")
+	}
+}
+
+// gormLogger fait passer les avertissements de GORM (requêtes lentes, erreurs) par slog
+func gormLogger() logger.Interface {
+	return logger.New(slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn), logger.Config{
+		SlowThreshold:             200 * time.Millisecond,
+		LogLevel:                  logger.Warn,
+		IgnoreRecordNotFoundError: true,
+	})
+}
@@ -0,0 +1,98 @@
+// Package tracing relie les services par des traces OpenTelemetry : les requêtes reçues,
+// les appels aux autres services et les requêtes GORM deviennent des spans, et le contexte
+// de trace circule entre services dans l'en-tête W3C traceparent.
+//
+// Les spans ne se rattachent à la requête en cours que si le contexte est transmis :
+// http.NewRequestWithContext(r.Context(), ...) pour les appels sortants, db.WithContext(r.Context())
+// pour GORM.
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"gorm.io/gorm"
+	gormtracing "gorm.io/plugin/opentelemetry/tracing"
+
+	"shared/config"
+	"shared/logging"
+)
+
+// Setup installe le fournisseur de traces global ; la fonction renvoyée exporte les spans
+// en attente et doit être appelée à l'arrêt. Avec l'exportateur "none", les traces ne sont
+// pas enregistrées mais le contexte reçu est tout de même transmis aux services appelés.
+func Setup(serviceName string, cfg config.Tracing) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch cfg.Exporter {
+	case "none":
+		return func(context.Context) error { return nil }, nil
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case "otlp":
+		var options []otlptracehttp.Option
+		if cfg.OTLPEndpoint != "" {
+			options = append(options, otlptracehttp.WithEndpointURL(strings.TrimSuffix(cfg.OTLPEndpoint, "/")+"/v1/traces"))
+		}
+		exporter, err = otlptracehttp.New(context.Background(), options...)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(serviceName))),
+		// Une requête déjà tracée par l'appelant garde sa décision d'échantillonnage
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Handler ouvre un span serveur par requête, nommé d'après la méthode et le motif de la route ;
+// les sondes et /metrics ne sont pas tracés
+func Handler(route string, next http.Handler) http.Handler {
+	return otelhttp.NewHandler(next, route,
+		otelhttp.WithFilter(func(r *http.Request) bool {
+			switch r.URL.Path {
+			case "/livez", "/readyz", "/health", "/metrics":
+				return false
+			}
+			return true
+		}),
+		otelhttp.WithSpanNameFormatter(func(route string, r *http.Request) string {
+			return r.Method + " " + route
+		}),
+	)
+}
+
+// Client renvoie un client HTTP qui trace ses appels et transmet le contexte de trace et
+// l'identifiant de requête
+func Client(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Transport: otelhttp.NewTransport(logging.Transport(http.DefaultTransport)),
+		Timeout:   timeout,
+	}
+}
+
+// InstrumentDB trace les requêtes GORM, sans la valeur de leurs paramètres
+func InstrumentDB(db *gorm.DB) error {
+	return db.Use(gormtracing.NewPlugin(gormtracing.WithoutMetrics(), gormtracing.WithoutQueryVariables()))
+}
@@ -0,0 +1,144 @@
+package web
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"shared/metrics"
+	"shared/tracing"
+)
+
+type contextKey string
+
+const (
+	userIDKey   contextKey = "user_id"
+	internalKey contextKey = "internal"
+)
+
+// UserID renvoie l'utilisateur authentifié par RequireUser, ou une chaîne vide
+func UserID(ctx context.Context) string {
+	userID, _ := ctx.Value(userIDKey).(string)
+	return userID
+}
+
+// WithUserID attache l'utilisateur authentifié au contexte et le signale au log d'accès
+func WithUserID(ctx context.Context, userID string) context.Context {
+	if entry, ok := ctx.Value(accessEntryKey).(*accessEntry); ok {
+		entry.userID = userID
+	}
+	return context.WithValue(ctx, userIDKey, userID)
+}
+
+// Internal indique que la requête vient d'un autre service, authentifié par RequireInternalToken
+func Internal(ctx context.Context) bool {
+	internal, _ := ctx.Value(internalKey).(bool)
+	return internal
+}
+
+var authClient = tracing.Client(5 * time.Second)
+
+// RequireUser vérifie le jeton de l'en-tête Authorization auprès d'auth-service
+func RequireUser(authServiceURL string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := r.Header.Get("Authorization")
+			if token == "" {
+				Error(w, http.StatusUnauthorized, "unauthorized", "Unauthorized")
+				return
+			}
+
+			start := time.Now()
+			req, err := http.NewRequestWithContext(r.Context(), "POST", authServiceURL+"/verify-token", strings.NewReader(fmt.Sprintf(`{"token":"%s"}`, token)))
+			if err != nil {
+				Error(w, http.StatusUnauthorized, "unauthorized", "Unauthorized")
+				return
+			}
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := authClient.Do(req)
+			if err != nil {
+				metrics.ObserveCall("verify_token", start, false)
+				Error(w, http.StatusUnauthorized, "unauthorized", "Unauthorized")
+				return
+			}
+			defer resp.Body.Close()
+			// Un jeton refusé est une réponse normale d'auth-service, pas un échec de l'appel
+			metrics.ObserveCall("verify_token", start, resp.StatusCode < http.StatusInternalServerError)
+
+			if resp.StatusCode != http.StatusOK {
+				Error(w, http.StatusUnauthorized, "unauthorized", "Unauthorized")
+				return
+			}
+
+			var result struct {
+				UserID string `json:"user_id"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+				Error(w, http.StatusUnauthorized, "unauthorized", "Unauthorized")
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(WithUserID(r.Context(), result.UserID)))
+		})
+	}
+}
+
+// RequireInternalToken réserve les routes internes aux autres services, qui présentent
+// le jeton partagé dans l'en-tête X-Internal-Token
+func RequireInternalToken(token string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			given := r.Header.Get("X-Internal-Token")
+			if token == "" || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+				Error(w, http.StatusUnauthorized, "unauthorized", "Unauthorized")
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), internalKey, true)))
+		})
+	}
+}
+
+// RequireUserOrInternal accepte les autres services, qui présentent le jeton interne, et
+// les utilisateurs authentifiés ; le handler les distingue avec Internal et UserID
+func RequireUserOrInternal(authServiceURL, token string) Middleware {
+	requireUser, requireInternal := RequireUser(authServiceURL), RequireInternalToken(token)
+	return func(next http.Handler) http.Handler {
+		user, internal := requireUser(next), requireInternal(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("X-Internal-Token") != "" {
+				internal.ServeHTTP(w, r)
+				return
+			}
+			user.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireAdmin réserve la route aux utilisateurs de adminUserIDs ; il se place après RequireUser
+func RequireAdmin(adminUserIDs []string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !IsAdmin(adminUserIDs, UserID(r.Context())) {
+				Error(w, http.StatusForbidden, "forbidden", "Forbidden")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// IsAdmin indique si userID figure dans adminUserIDs ; un utilisateur vide ne l'est jamais
+func IsAdmin(adminUserIDs []string, userID string) bool {
+	if userID == "" {
+		return false
+	}
+	for _, adminID := range adminUserIDs {
+		if adminID == userID {
+			return true
+		}
+	}
+	return false
+}
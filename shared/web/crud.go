@@ -0,0 +1,149 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"gorm.io/gorm"
+)
+
+// Resource expose un modèle GORM en CRUD : GET et POST sur la collection, GET, PUT et
+// DELETE sur un élément. Les corps sont validés selon les tags `validate` du modèle
+// (voir Validate). Les hooks, tous optionnels, s'exécutent dans la transaction de
+// l'écriture ; une StatusError y fixe le statut et le code de la réponse.
+type Resource[T any] struct {
+	DB *gorm.DB
+	// Name apparaît dans les messages d'erreur ("Product not found")
+	Name string
+	// Prefix est le chemin des éléments, par exemple "/products/"
+	Prefix string
+
+	BeforeCreate func(tx *gorm.DB, item *T) error
+	AfterCreate  func(tx *gorm.DB, item *T) error
+	// BeforeUpdate reçoit le corps partiel, avant son application
+	BeforeUpdate func(tx *gorm.DB, item *T) error
+	// AfterUpdate reçoit l'élément relu après la mise à jour
+	AfterUpdate func(tx *gorm.DB, item *T) error
+	AfterDelete func(tx *gorm.DB, item *T) error
+}
+
+// Collection sert la route de la collection ("/products")
+func (res Resource[T]) Collection() http.Handler {
+	return Methods{"GET": res.list, "POST": res.create}
+}
+
+// Item sert la route des éléments ("/products/")
+func (res Resource[T]) Item() http.Handler {
+	return Methods{"GET": res.get, "PUT": res.update, "DELETE": res.delete}
+}
+
+func (res Resource[T]) list(w http.ResponseWriter, r *http.Request) {
+	var items []T
+	if err := res.DB.WithContext(r.Context()).Find(&items).Error; err != nil {
+		res.fail(w, err)
+		return
+	}
+	WriteJSON(w, http.StatusOK, items)
+}
+
+func (res Resource[T]) create(w http.ResponseWriter, r *http.Request) {
+	var item T
+	if err := DecodeJSON(w, r, &item); err != nil {
+		res.fail(w, err)
+		return
+	}
+	err := res.DB.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		if err := res.hook(res.BeforeCreate, tx, &item); err != nil {
+			return err
+		}
+		if err := tx.Create(&item).Error; err != nil {
+			return err
+		}
+		return res.hook(res.AfterCreate, tx, &item)
+	})
+	if err != nil {
+		res.fail(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (res Resource[T]) get(w http.ResponseWriter, r *http.Request) {
+	var item T
+	if err := res.DB.WithContext(r.Context()).First(&item, "id = ?", PathID(r, res.Prefix)).Error; err != nil {
+		res.fail(w, err)
+		return
+	}
+	WriteJSON(w, http.StatusOK, item)
+}
+
+// update applique les champs non nuls du corps ; un élément absent est ignoré
+func (res Resource[T]) update(w http.ResponseWriter, r *http.Request) {
+	id := PathID(r, res.Prefix)
+	var item T
+	if err := DecodeJSONPartial(w, r, &item); err != nil {
+		res.fail(w, err)
+		return
+	}
+	err := res.DB.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		if err := res.hook(res.BeforeUpdate, tx, &item); err != nil {
+			return err
+		}
+		result := tx.Model(new(T)).Where("id = ?", id).Updates(&item)
+		if result.Error != nil || result.RowsAffected == 0 || res.AfterUpdate == nil {
+			return result.Error
+		}
+		var updated T
+		if err := tx.First(&updated, "id = ?", id).Error; err != nil {
+			return err
+		}
+		return res.AfterUpdate(tx, &updated)
+	})
+	if err != nil {
+		res.fail(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// delete est idempotent : supprimer un élément absent reste sans effet
+func (res Resource[T]) delete(w http.ResponseWriter, r *http.Request) {
+	id := PathID(r, res.Prefix)
+	err := res.DB.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		var item T
+		if err := tx.First(&item, "id = ?", id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			}
+			return err
+		}
+		if err := tx.Delete(&item).Error; err != nil {
+			return err
+		}
+		return res.hook(res.AfterDelete, tx, &item)
+	})
+	if err != nil {
+		res.fail(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (res Resource[T]) hook(hook func(*gorm.DB, *T) error, tx *gorm.DB, item *T) error {
+	if hook == nil {
+		return nil
+	}
+	return hook(tx, item)
+}
+
+func (res Resource[T]) fail(w http.ResponseWriter, err error) {
+	WriteError(w, err, res.Name)
+}
+
+// WriteJSON écrit une réponse JSON avec son code HTTP
+func WriteJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
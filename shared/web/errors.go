@@ -0,0 +1,135 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"gorm.io/gorm"
+
+	"shared/logging"
+)
+
+// Problem est le corps des réponses d'erreur de tous les services, au format RFC 7807
+// (application/problem+json). Code est stable et destiné aux clients, qui n'ont pas à
+// analyser Detail ; RequestID permet de retrouver la requête dans les logs.
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Code      string       `json:"code"`
+	Detail    string       `json:"detail,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError signale un champ invalide du corps de la requête
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// StatusError est une erreur métier renvoyée au client avec son code HTTP
+type StatusError struct {
+	Status  int
+	Code    string
+	Message string
+	Fields  []FieldError
+}
+
+func (e *StatusError) Error() string {
+	return e.Message
+}
+
+// Errorf crée une StatusError dont le code est déduit du statut ("conflict" pour un 409)
+func Errorf(status int, format string, args ...interface{}) error {
+	return &StatusError{Status: status, Code: defaultCode(status), Message: fmt.Sprintf(format, args...)}
+}
+
+// NewError crée une StatusError avec un code propre au service ("email_taken")
+func NewError(status int, code, message string) error {
+	return &StatusError{Status: status, Code: code, Message: message}
+}
+
+// Error écrit une réponse d'erreur ; detail est montré au client, il ne doit pas contenir
+// d'erreur interne (message de la base, d'un autre service...)
+func Error(w http.ResponseWriter, status int, code, detail string) {
+	WriteProblem(w, Problem{Status: status, Code: code, Detail: detail})
+}
+
+// WriteProblem complète et écrit une réponse d'erreur
+func WriteProblem(w http.ResponseWriter, problem Problem) {
+	if problem.Type == "" {
+		problem.Type = "about:blank"
+	}
+	if problem.Title == "" {
+		problem.Title = http.StatusText(problem.Status)
+	}
+	if problem.Code == "" {
+		problem.Code = defaultCode(problem.Status)
+	}
+	// RequestID a déjà placé l'identifiant dans les en-têtes de la réponse
+	problem.RequestID = w.Header().Get(logging.RequestIDHeader)
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Del("Content-Length")
+	w.WriteHeader(problem.Status)
+	json.NewEncoder(w).Encode(problem)
+}
+
+// WriteError écrit err en réponse : une StatusError avec son statut et son code, une erreur
+// GORM connue avec le statut correspondant, et toute autre erreur en 500 sans son message,
+// qui n'est que journalisé. name désigne la ressource dans les messages ("Order not found").
+func WriteError(w http.ResponseWriter, err error, name string) {
+	var statusErr *StatusError
+	switch {
+	case errors.As(err, &statusErr):
+		WriteProblem(w, Problem{Status: statusErr.Status, Code: statusErr.Code, Detail: statusErr.Message, Errors: statusErr.Fields})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		Error(w, http.StatusNotFound, "not_found", name+" not found")
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		Error(w, http.StatusConflict, "already_exists", name+" already exists")
+	case errors.Is(err, gorm.ErrForeignKeyViolated):
+		Error(w, http.StatusConflict, "referenced", name+" is referenced by or refers to a missing record")
+	case errors.Is(err, gorm.ErrCheckConstraintViolated):
+		Error(w, http.StatusUnprocessableEntity, "constraint_violated", name+" violates a data constraint")
+	default:
+		slog.Error("request failed", "error", err, "request_id", w.Header().Get(logging.RequestIDHeader))
+		Error(w, http.StatusInternalServerError, "internal_error", "An unexpected error occurred")
+	}
+}
+
+func defaultCode(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return "bad_request"
+	case http.StatusUnauthorized:
+		return "unauthorized"
+	case http.StatusPaymentRequired:
+		return "payment_required"
+	case http.StatusForbidden:
+		return "forbidden"
+	case http.StatusNotFound:
+		return "not_found"
+	case http.StatusMethodNotAllowed:
+		return "method_not_allowed"
+	case http.StatusConflict:
+		return "conflict"
+	case http.StatusRequestEntityTooLarge:
+		return "payload_too_large"
+	case http.StatusUnsupportedMediaType:
+		return "unsupported_media_type"
+	case http.StatusUnprocessableEntity:
+		return "unprocessable_entity"
+	case http.StatusBadGateway:
+		return "bad_gateway"
+	case http.StatusServiceUnavailable:
+		return "service_unavailable"
+	}
+	if status >= 500 {
+		return "internal_error"
+	}
+	return "error"
+}
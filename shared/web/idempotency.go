@@ -0,0 +1,207 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// IdempotencyTTL est la durée pendant laquelle une réponse reste rejouée
+const IdempotencyTTL = 24 * time.Hour
+
+// IdempotencyLease borne la réservation d'une requête en cours : si le service s'arrête
+// avant d'avoir mémorisé la réponse, la clé redevient utilisable après ce délai
+const IdempotencyLease = 2 * time.Minute
+
+// ErrIdempotencyLeaseLost : la réservation a expiré et la clé a été reprise par une autre requête
+var ErrIdempotencyLeaseLost = errors.New("idempotency key reservation expired")
+
+// IdempotencyRecord est une clé réservée par un utilisateur ; StatusCode reste nul tant
+// que la requête est en cours
+type IdempotencyRecord struct {
+	Key         string `gorm:"column:idempotency_key;primaryKey"`
+	UserID      string `gorm:"primaryKey"`
+	RequestHash string
+	StatusCode  int
+	ContentType string
+	Body        []byte
+	CreatedAt   time.Time
+}
+
+// IdempotencyStore conserve les clés d'idempotence ; IdempotencyTable l'implémente avec GORM
+type IdempotencyStore interface {
+	// Reserve enregistre la clé si elle est libre, expirée ou si sa réservation en cours a
+	// dépassé IdempotencyLease, et renvoie nil ; sinon elle renvoie l'enregistrement existant
+	Reserve(ctx context.Context, record IdempotencyRecord) (*IdempotencyRecord, error)
+	// Complete mémorise la réponse de la requête, ou renvoie ErrIdempotencyLeaseLost si la
+	// réservation a été reprise entre-temps
+	Complete(ctx context.Context, record IdempotencyRecord) error
+	// Release libère la clé encore en cours, pour une requête qui n'a rien modifié
+	Release(ctx context.Context, userID, key string) error
+}
+
+// IdempotencyTable range les clés dans la table Name, de la forme de IdempotencyRecord
+type IdempotencyTable struct {
+	DB   *gorm.DB
+	Name string
+}
+
+func (t IdempotencyTable) Reserve(ctx context.Context, record IdempotencyRecord) (*IdempotencyRecord, error) {
+	db := t.DB.WithContext(ctx)
+	// Les clés expirées, et celles dont la requête ne s'est jamais terminée, peuvent être réutilisées
+	now := time.Now()
+	err := db.Table(t.Name).
+		Where("idempotency_key = ? AND user_id = ?", record.Key, record.UserID).
+		Where("created_at < ? OR (status_code = 0 AND created_at < ?)", now.Add(-IdempotencyTTL), now.Add(-IdempotencyLease)).
+		Delete(&IdempotencyRecord{}).Error
+	if err != nil {
+		return nil, err
+	}
+	result := db.Table(t.Name).Clauses(clause.OnConflict{DoNothing: true}).Create(&record)
+	if result.Error != nil || result.RowsAffected > 0 {
+		return nil, result.Error
+	}
+	var existing IdempotencyRecord
+	if err := db.Table(t.Name).First(&existing, "idempotency_key = ? AND user_id = ?", record.Key, record.UserID).Error; err != nil {
+		return nil, err
+	}
+	return &existing, nil
+}
+
+func (t IdempotencyTable) Complete(ctx context.Context, record IdempotencyRecord) error {
+	result := t.DB.WithContext(ctx).Table(t.Name).
+		Where("idempotency_key = ? AND user_id = ? AND request_hash = ? AND status_code = 0", record.Key, record.UserID, record.RequestHash).
+		Updates(map[string]interface{}{
+			"status_code":  record.StatusCode,
+			"content_type": record.ContentType,
+			"body":         record.Body,
+		})
+	if result.Error == nil && result.RowsAffected == 0 {
+		return ErrIdempotencyLeaseLost
+	}
+	return result.Error
+}
+
+func (t IdempotencyTable) Release(ctx context.Context, userID, key string) error {
+	return t.DB.WithContext(ctx).Table(t.Name).
+		Delete(&IdempotencyRecord{}, "idempotency_key = ? AND user_id = ? AND status_code = 0", key, userID).Error
+}
+
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// Idempotency rejoue la réponse d'un POST envoyé de nouveau avec le même en-tête
+// Idempotency-Key. Les clés sont propres à chaque utilisateur : le middleware se place après
+// RequireUser, et une clé présentée sans utilisateur authentifié est refusée.
+//
+// Une même clé avec un autre corps est refusée en 422, et en 409 tant que la première
+// requête est en cours, au plus IdempotencyLease. Une réponse 4xx libère la clé, la requête refusée n'ayant rien
+// modifié ; toute autre réponse est rejouée, y compris une 5xx, car l'opération a pu
+// aboutir en partie : le client réessaie alors avec une nouvelle clé.
+func Idempotency(store IdempotencyStore) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get("Idempotency-Key")
+			if r.Method != "POST" || key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			userID := UserID(r.Context())
+			if userID == "" {
+				Error(w, http.StatusUnauthorized, "unauthorized", "Idempotency-Key requires an authenticated user")
+				return
+			}
+
+			// Le corps est lu en entier avant le handler : sa taille est bornée ici aussi
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxBodyBytes))
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				Error(w, http.StatusRequestEntityTooLarge, "payload_too_large", fmt.Sprintf("Request body must not exceed %d bytes", tooLarge.Limit))
+				return
+			}
+			if err != nil {
+				Error(w, http.StatusBadRequest, "invalid_body", "Could not read request body")
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			sum := sha256.Sum256(append([]byte(r.Method+" "+r.URL.Path+"\n"), body...))
+			hash := hex.EncodeToString(sum[:])
+
+			// Réserver la clé avant d'exécuter la requête bloque les doublons concurrents
+			existing, err := store.Reserve(r.Context(), IdempotencyRecord{Key: key, UserID: userID, RequestHash: hash})
+			if err != nil {
+				WriteError(w, err, "Idempotency key")
+				return
+			}
+			if existing != nil {
+				switch {
+				case existing.RequestHash != hash:
+					Error(w, http.StatusUnprocessableEntity, "idempotency_key_reused", "Idempotency-Key already used with a different request")
+				case existing.StatusCode == 0:
+					Error(w, http.StatusConflict, "idempotency_key_in_progress", "A request with this Idempotency-Key is already in progress")
+				default:
+					if existing.ContentType != "" {
+						w.Header().Set("Content-Type", existing.ContentType)
+					}
+					w.Header().Set("Idempotent-Replayed", "true")
+					w.WriteHeader(existing.StatusCode)
+					w.Write(existing.Body)
+				}
+				return
+			}
+
+			rec := &responseRecorder{ResponseWriter: w}
+			next.ServeHTTP(rec, r)
+			if rec.status == 0 {
+				rec.status = http.StatusOK
+			}
+
+			// La réponse est déjà envoyée : la requête n'est plus liée au client
+			ctx := context.WithoutCancel(r.Context())
+			if rec.status >= http.StatusBadRequest && rec.status < http.StatusInternalServerError {
+				if err := store.Release(ctx, userID, key); err != nil {
+					slog.ErrorContext(ctx, "failed to release idempotency key", "idempotency_key", key, "error", err)
+				}
+				return
+			}
+			err = store.Complete(ctx, IdempotencyRecord{
+				Key:         key,
+				UserID:      userID,
+				RequestHash: hash,
+				StatusCode:  rec.status,
+				ContentType: w.Header().Get("Content-Type"),
+				Body:        rec.body.Bytes(),
+			})
+			if err != nil {
+				// La clé reste réservée jusqu'à IdempotencyLease, puis une nouvelle tentative réexécute la requête
+				slog.ErrorContext(ctx, "failed to store idempotent response", "idempotency_key", key, "status", rec.status, "error", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func testIdempotencyTable(t *testing.T) IdempotencyTable {
+	t.Helper()
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name())
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	table := IdempotencyTable{DB: db, Name: "idempotency_keys"}
+	if err := db.Table(table.Name).AutoMigrate(&IdempotencyRecord{}); err != nil {
+		t.Fatal(err)
+	}
+	return table
+}
+
+func TestIdempotencyTableReserve(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		age       time.Duration
+		wantTaken bool
+	}{
+		{name: "request in progress", age: time.Second, wantTaken: true},
+		{name: "request in progress past its lease", age: IdempotencyLease + time.Second},
+		{name: "completed response", status: 201, age: IdempotencyLease + time.Second, wantTaken: true},
+		{name: "completed response past the TTL", status: 201, age: IdempotencyTTL + time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table := testIdempotencyTable(t)
+			ctx := context.Background()
+			previous := IdempotencyRecord{Key: "k", UserID: "u1", RequestHash: "h", StatusCode: tt.status, CreatedAt: time.Now().Add(-tt.age)}
+			if err := table.DB.Table(table.Name).Create(&previous).Error; err != nil {
+				t.Fatal(err)
+			}
+
+			existing, err := table.Reserve(ctx, IdempotencyRecord{Key: "k", UserID: "u1", RequestHash: "h"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if taken := existing != nil; taken != tt.wantTaken {
+				t.Fatalf("key taken = %v, want %v", taken, tt.wantTaken)
+			}
+			if existing != nil && existing.StatusCode != tt.status {
+				t.Errorf("existing status = %d, want %d", existing.StatusCode, tt.status)
+			}
+		})
+	}
+}
+
+func TestIdempotencyTableComplete(t *testing.T) {
+	table := testIdempotencyTable(t)
+	ctx := context.Background()
+	record := IdempotencyRecord{Key: "k", UserID: "u1", RequestHash: "h"}
+	if existing, err := table.Reserve(ctx, record); existing != nil || err != nil {
+		t.Fatalf("Reserve() = %v, %v", existing, err)
+	}
+	// Une autre clé ou un autre utilisateur ne sont pas concernés
+	if existing, err := table.Reserve(ctx, IdempotencyRecord{Key: "k", UserID: "u2", RequestHash: "h"}); existing != nil || err != nil {
+		t.Fatalf("Reserve() for another user = %v, %v", existing, err)
+	}
+
+	record.StatusCode, record.Body = 201, []byte(`{"id":1}`)
+	if err := table.Complete(ctx, record); err != nil {
+		t.Fatalf("Complete() error = %v", err)
+	}
+	existing, err := table.Reserve(ctx, IdempotencyRecord{Key: "k", UserID: "u1", RequestHash: "h"})
+	if err != nil || existing == nil || existing.StatusCode != 201 || string(existing.Body) != `{"id":1}` {
+		t.Fatalf("Reserve() after Complete = %+v, %v", existing, err)
+	}
+
+	// Une réponse déjà mémorisée n'est ni remplacée ni libérée
+	if err := table.Complete(ctx, record); !errors.Is(err, ErrIdempotencyLeaseLost) {
+		t.Errorf("second Complete() error = %v, want %v", err, ErrIdempotencyLeaseLost)
+	}
+	if err := table.Release(ctx, "u1", "k"); err != nil {
+		t.Fatal(err)
+	}
+	if existing, _ := table.Reserve(ctx, IdempotencyRecord{Key: "k", UserID: "u1", RequestHash: "h"}); existing == nil {
+		t.Error("Release() removed a completed response")
+	}
+}
+
+func TestIdempotencyTableRelease(t *testing.T) {
+	table := testIdempotencyTable(t)
+	ctx := context.Background()
+	record := IdempotencyRecord{Key: "k", UserID: "u1", RequestHash: "h"}
+	table.Reserve(ctx, record)
+	if err := table.Release(ctx, "u1", "k"); err != nil {
+		t.Fatal(err)
+	}
+	if existing, err := table.Reserve(ctx, record); existing != nil || err != nil {
+		t.Errorf("Reserve() after Release = %v, %v, want the key free", existing, err)
+	}
+}
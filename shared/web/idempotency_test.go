@@ -0,0 +1,207 @@
+package web
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// memoryIdempotencyStore est un IdempotencyStore en mémoire, sans expiration
+type memoryIdempotencyStore struct {
+	mu      sync.Mutex
+	records map[[2]string]IdempotencyRecord
+}
+
+func newMemoryIdempotencyStore() *memoryIdempotencyStore {
+	return &memoryIdempotencyStore{records: map[[2]string]IdempotencyRecord{}}
+}
+
+func (s *memoryIdempotencyStore) Reserve(ctx context.Context, record IdempotencyRecord) (*IdempotencyRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := [2]string{record.UserID, record.Key}
+	if existing, ok := s.records[id]; ok {
+		return &existing, nil
+	}
+	s.records[id] = record
+	return nil, nil
+}
+
+func (s *memoryIdempotencyStore) Complete(ctx context.Context, record IdempotencyRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := [2]string{record.UserID, record.Key}
+	existing := s.records[id]
+	existing.StatusCode, existing.ContentType, existing.Body = record.StatusCode, record.ContentType, record.Body
+	s.records[id] = existing
+	return nil
+}
+
+func (s *memoryIdempotencyStore) Release(ctx context.Context, userID, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, [2]string{userID, key})
+	return nil
+}
+
+type idempotentCall struct {
+	user   string
+	key    string
+	body   string
+	status int
+}
+
+func TestIdempotency(t *testing.T) {
+	tests := []struct {
+		name string
+		// status renvoyé par le handler pour chaque appel
+		calls        []idempotentCall
+		wantStatus   []int
+		wantReplayed []bool
+		wantHandled  int
+	}{
+		{
+			name: "replays the stored response",
+			calls: []idempotentCall{
+				{user: "u1", key: "k", body: `{"a":1}`, status: http.StatusCreated},
+				{user: "u1", key: "k", body: `{"a":1}`, status: http.StatusCreated},
+			},
+			wantStatus:   []int{http.StatusCreated, http.StatusCreated},
+			wantReplayed: []bool{false, true},
+			wantHandled:  1,
+		},
+		{
+			name: "rejects a reused key with another body",
+			calls: []idempotentCall{
+				{user: "u1", key: "k", body: `{"a":1}`, status: http.StatusCreated},
+				{user: "u1", key: "k", body: `{"a":2}`, status: http.StatusCreated},
+			},
+			wantStatus:   []int{http.StatusCreated, http.StatusUnprocessableEntity},
+			wantReplayed: []bool{false, false},
+			wantHandled:  1,
+		},
+		{
+			name: "scopes keys by user",
+			calls: []idempotentCall{
+				{user: "u1", key: "k", body: `{"a":1}`, status: http.StatusCreated},
+				{user: "u2", key: "k", body: `{"a":1}`, status: http.StatusCreated},
+			},
+			wantStatus:   []int{http.StatusCreated, http.StatusCreated},
+			wantReplayed: []bool{false, false},
+			wantHandled:  2,
+		},
+		{
+			name: "releases the key after a client error",
+			calls: []idempotentCall{
+				{user: "u1", key: "k", body: `{"a":1}`, status: http.StatusUnprocessableEntity},
+				{user: "u1", key: "k", body: `{"a":1}`, status: http.StatusCreated},
+			},
+			wantStatus:   []int{http.StatusUnprocessableEntity, http.StatusCreated},
+			wantReplayed: []bool{false, false},
+			wantHandled:  2,
+		},
+		{
+			name: "keeps the response of a server error",
+			calls: []idempotentCall{
+				{user: "u1", key: "k", body: `{"a":1}`, status: http.StatusBadGateway},
+				{user: "u1", key: "k", body: `{"a":1}`, status: http.StatusCreated},
+			},
+			wantStatus:   []int{http.StatusBadGateway, http.StatusBadGateway},
+			wantReplayed: []bool{false, true},
+			wantHandled:  1,
+		},
+		{
+			name: "passes requests without a key",
+			calls: []idempotentCall{
+				{user: "u1", body: `{"a":1}`, status: http.StatusCreated},
+				{user: "u1", body: `{"a":1}`, status: http.StatusCreated},
+			},
+			wantStatus:   []int{http.StatusCreated, http.StatusCreated},
+			wantReplayed: []bool{false, false},
+			wantHandled:  2,
+		},
+		{
+			name: "refuses a key without a user",
+			calls: []idempotentCall{
+				{key: "k", body: `{"a":1}`, status: http.StatusCreated},
+			},
+			wantStatus:   []int{http.StatusUnauthorized},
+			wantReplayed: []bool{false},
+			wantHandled:  0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handled := 0
+			var status int
+			handler := Idempotency(newMemoryIdempotencyStore())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				handled++
+				body, _ := io.ReadAll(r.Body)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(status)
+				w.Write(body)
+			}))
+			for i, call := range tt.calls {
+				status = call.status
+				req := httptest.NewRequest("POST", "/orders", strings.NewReader(call.body))
+				if call.key != "" {
+					req.Header.Set("Idempotency-Key", call.key)
+				}
+				if call.user != "" {
+					req = req.WithContext(WithUserID(req.Context(), call.user))
+				}
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, req)
+
+				if rec.Code != tt.wantStatus[i] {
+					t.Errorf("call %d: status = %d, want %d", i, rec.Code, tt.wantStatus[i])
+				}
+				if replayed := rec.Header().Get("Idempotent-Replayed") == "true"; replayed != tt.wantReplayed[i] {
+					t.Errorf("call %d: replayed = %v, want %v", i, replayed, tt.wantReplayed[i])
+				}
+				if tt.wantReplayed[i] && rec.Body.String() != call.body {
+					t.Errorf("call %d: replayed body = %q, want %q", i, rec.Body.String(), call.body)
+				}
+			}
+			if handled != tt.wantHandled {
+				t.Errorf("handler called %d times, want %d", handled, tt.wantHandled)
+			}
+		})
+	}
+}
+
+func TestIdempotencyInProgress(t *testing.T) {
+	store := newMemoryIdempotencyStore()
+	store.Reserve(context.Background(), IdempotencyRecord{Key: "k", UserID: "u1", RequestHash: requestHash(t, `{"a":1}`)})
+	handler := Idempotency(store)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("handler called while the key is in progress")
+	}))
+
+	req := httptest.NewRequest("POST", "/orders", strings.NewReader(`{"a":1}`))
+	req.Header.Set("Idempotency-Key", "k")
+	req = req.WithContext(WithUserID(req.Context(), "u1"))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusConflict {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusConflict)
+	}
+}
+
+// requestHash relève l'empreinte calculée par le middleware pour ce corps
+func requestHash(t *testing.T, body string) string {
+	store := newMemoryIdempotencyStore()
+	handler := Idempotency(store)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	req := httptest.NewRequest("POST", "/orders", strings.NewReader(body))
+	req.Header.Set("Idempotency-Key", "k")
+	req = req.WithContext(WithUserID(req.Context(), "u1"))
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	record, ok := store.records[[2]string{"u1", "k"}]
+	if !ok {
+		t.Fatal("key was not stored")
+	}
+	return record.RequestHash
+}
@@ -0,0 +1,119 @@
+package web
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"shared/logging"
+	"shared/metrics"
+	"shared/tracing"
+)
+
+// accessEntry recueille pendant la requête les informations du log d'accès connues plus bas
+// dans la chaîne, comme l'utilisateur authentifié par RequireUser
+type accessEntry struct {
+	userID string
+}
+
+const accessEntryKey contextKey = "access_entry"
+
+// RequestID reprend l'identifiant de requête reçu dans l'en-tête X-Request-ID, ou en génère
+// un, le renvoie dans la réponse et l'attache au contexte pour les logs et les appels sortants
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(logging.RequestIDHeader)
+		if !validRequestID(id) {
+			id = logging.NewRequestID()
+		}
+		w.Header().Set(logging.RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(logging.WithRequestID(r.Context(), id)))
+	})
+}
+
+// validRequestID écarte les identifiants trop longs ou qui pourraient fausser les logs
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// AccessLog journalise chaque requête une fois traitée ; à placer après RequestID
+func AccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		entry := &accessEntry{}
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), accessEntryKey, entry)))
+
+		level := slog.LevelInfo
+		switch r.URL.Path {
+		case "/livez", "/readyz", "/health", "/metrics":
+			level = slog.LevelDebug
+		}
+		slog.Log(r.Context(), level, "request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", sw.code(),
+			"duration_ms", float64(time.Since(start).Microseconds())/1000,
+			"user_id", entry.userID,
+		)
+	})
+}
+
+// instrument mesure et trace les requêtes d'une route sous le nom de son motif
+func instrument(route string, next http.Handler) http.Handler {
+	next = tracing.Handler(route, next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		done := metrics.RequestStarted()
+		defer done()
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+		metrics.ObserveRequest(route, r.Method, sw.code(), time.Since(start))
+	})
+}
+
+// statusWriter retient le code de la réponse ; Flush et Unwrap laissent passer les flux SSE
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	if sw.status == 0 {
+		sw.status = status
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	return sw.ResponseWriter.Write(b)
+}
+
+func (sw *statusWriter) Flush() {
+	if flusher, ok := sw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+func (sw *statusWriter) code() int {
+	if sw.status == 0 {
+		return http.StatusOK
+	}
+	return sw.status
+}
@@ -0,0 +1,77 @@
+// Package web regroupe les briques HTTP communes aux services : routeur, chaîne de
+// middlewares, authentification et ressources CRUD.
+package web
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Middleware enveloppe un handler, par exemple pour exiger une authentification
+type Middleware func(http.Handler) http.Handler
+
+// Chain applique les middlewares dans l'ordre : le premier est le plus externe
+func Chain(handler http.Handler, middlewares ...Middleware) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// Router est un http.ServeMux dont chaque route peut avoir ses propres middlewares,
+// et dont les middlewares déclarés avec Use s'appliquent à toutes les routes
+type Router struct {
+	mux         *http.ServeMux
+	middlewares []Middleware
+	handler     http.Handler
+}
+
+func NewRouter() *Router {
+	mux := http.NewServeMux()
+	return &Router{mux: mux, handler: mux}
+}
+
+// Use ajoute des middlewares globaux ; à appeler avant de servir la première requête, la
+// chaîne étant construite ici et seulement lue ensuite par les requêtes concurrentes
+func (r *Router) Use(middlewares ...Middleware) {
+	r.middlewares = append(r.middlewares, middlewares...)
+	r.handler = Chain(r.mux, r.middlewares...)
+}
+
+// Handle enregistre une route ; ses requêtes sont mesurées et tracées sous le nom de son motif
+func (r *Router) Handle(pattern string, handler http.Handler, middlewares ...Middleware) {
+	r.mux.Handle(pattern, instrument(pattern, Chain(handler, middlewares...)))
+}
+
+func (r *Router) HandleFunc(pattern string, handler http.HandlerFunc, middlewares ...Middleware) {
+	r.Handle(pattern, handler, middlewares...)
+}
+
+func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.handler.ServeHTTP(w, req)
+}
+
+// Methods associe un handler à chaque méthode HTTP acceptée par une route ;
+// les autres méthodes reçoivent un 405 avec l'en-tête Allow
+type Methods map[string]http.HandlerFunc
+
+func (m Methods) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if handler, ok := m[r.Method]; ok {
+		handler(w, r)
+		return
+	}
+	allowed := make([]string, 0, len(m))
+	for method := range m {
+		allowed = append(allowed, method)
+	}
+	sort.Strings(allowed)
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	Error(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+}
+
+// PathID renvoie la partie du chemin qui suit le préfixe de la route, par exemple
+// "42" pour "/products/42" et le préfixe "/products/"
+func PathID(r *http.Request, prefix string) string {
+	return strings.TrimPrefix(r.URL.Path, prefix)
+}
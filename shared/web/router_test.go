@@ -0,0 +1,84 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// tag ajoute son nom à l'en-tête X-Trace pour relever l'ordre des middlewares
+func tag(name string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("X-Trace", name)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func TestRouter(t *testing.T) {
+	router := NewRouter()
+	router.Use(tag("outer"), tag("inner"))
+	router.HandleFunc("/items", func(w http.ResponseWriter, r *http.Request) {}, tag("route"))
+	router.Handle("/items/", Methods{
+		"GET":    func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(PathID(r, "/items/"))) },
+		"DELETE": func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) },
+	})
+
+	tests := []struct {
+		name      string
+		method    string
+		path      string
+		wantCode  int
+		wantTrace string
+		wantBody  string
+		wantAllow string
+	}{
+		{name: "global then route middlewares", method: "GET", path: "/items", wantCode: 200, wantTrace: "outer,inner,route"},
+		{name: "path id", method: "GET", path: "/items/42", wantCode: 200, wantTrace: "outer,inner", wantBody: "42"},
+		{name: "other method", method: "DELETE", path: "/items/42", wantCode: 204, wantTrace: "outer,inner"},
+		{name: "method not allowed", method: "PUT", path: "/items/42", wantCode: 405, wantTrace: "outer,inner", wantAllow: "DELETE, GET"},
+		{name: "unknown route still goes through global middlewares", method: "GET", path: "/other", wantCode: 404, wantTrace: "outer,inner"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+			if rec.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if got := strings.Join(rec.Header().Values("X-Trace"), ","); got != tt.wantTrace {
+				t.Errorf("middlewares = %q, want %q", got, tt.wantTrace)
+			}
+			if tt.wantBody != "" && rec.Body.String() != tt.wantBody {
+				t.Errorf("body = %q, want %q", rec.Body, tt.wantBody)
+			}
+			if got := rec.Header().Get("Allow"); got != tt.wantAllow {
+				t.Errorf("Allow = %q, want %q", got, tt.wantAllow)
+			}
+		})
+	}
+}
+
+// Les premières requêtes servies en parallèle ne modifient pas le routeur (go test -race)
+func TestRouterConcurrentRequests(t *testing.T) {
+	router := NewRouter()
+	router.Use(tag("outer"))
+	router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+			if rec.Header().Get("X-Trace") != "outer" {
+				t.Errorf("global middleware not applied")
+			}
+		}()
+	}
+	wg.Wait()
+}
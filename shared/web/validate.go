@@ -0,0 +1,269 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// MaxBodyBytes borne la taille des corps JSON lus par DecodeJSON
+var MaxBodyBytes int64 = 1 << 20
+
+// ErrEmptyBody est renvoyée par DecodeJSON quand la requête n'a pas de corps ; les routes
+// dont le corps est facultatif la comparent avec errors.Is
+var ErrEmptyBody error = &StatusError{Status: http.StatusBadRequest, Code: "empty_body", Message: "Request body is empty"}
+
+// DecodeJSON lit le corps de la requête dans v puis le valide avec Validate. Les champs
+// inconnus, un corps trop gros ou suivi d'autres données sont refusés ; l'erreur renvoyée
+// est une StatusError à écrire avec WriteError.
+func DecodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	if err := decode(w, r, v); err != nil {
+		return err
+	}
+	return Validate(v)
+}
+
+// DecodeJSONPartial est DecodeJSON pour les mises à jour partielles, où seuls les champs
+// renseignés sont modifiés : la règle required n'y est pas vérifiée
+func DecodeJSONPartial(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	if err := decode(w, r, v); err != nil {
+		return err
+	}
+	return ValidatePartial(v)
+}
+
+func decode(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, MaxBodyBytes))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(v)
+	if err == nil {
+		if decoder.Decode(&struct{}{}) != io.EOF {
+			return NewError(http.StatusBadRequest, "invalid_json", "Request body must contain a single JSON value")
+		}
+		return nil
+	}
+
+	var maxBytesErr *http.MaxBytesError
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.Is(err, io.EOF):
+		return ErrEmptyBody
+	case errors.As(err, &maxBytesErr):
+		return NewError(http.StatusRequestEntityTooLarge, "payload_too_large",
+			fmt.Sprintf("Request body must not exceed %d bytes", maxBytesErr.Limit))
+	case errors.As(err, &syntaxErr):
+		return NewError(http.StatusBadRequest, "invalid_json", fmt.Sprintf("Malformed JSON at offset %d", syntaxErr.Offset))
+	case errors.As(err, &typeErr):
+		if typeErr.Field == "" {
+			return NewError(http.StatusBadRequest, "invalid_json", "Request body must be "+jsonType(typeErr.Type))
+		}
+		return ValidationError(FieldError{Field: typeErr.Field, Code: "invalid_type", Message: "must be " + jsonType(typeErr.Type)})
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// encoding/json n'a pas de type d'erreur dédié aux champs inconnus
+		field, _ := strconv.Unquote(strings.TrimPrefix(err.Error(), "json: unknown field "))
+		return ValidationError(FieldError{Field: field, Code: "unknown_field", Message: "is not a known field"})
+	}
+	return NewError(http.StatusBadRequest, "invalid_json", "Malformed JSON")
+}
+
+// ValidationError signale des champs invalides, en 422 avec le code validation_failed
+func ValidationError(fields ...FieldError) error {
+	return &StatusError{
+		Status:  http.StatusUnprocessableEntity,
+		Code:    "validation_failed",
+		Message: "Request body is invalid",
+		Fields:  fields,
+	}
+}
+
+// Validate vérifie les règles déclarées dans le tag `validate` des champs de v, séparées
+// par des virgules :
+//
+//	required      le champ doit être renseigné (valeur non nulle)
+//	min=N, max=N  bornes d'un nombre, ou de la longueur d'une chaîne ou d'une liste
+//	len=N         longueur exacte d'une chaîne
+//	oneof=a b c   valeurs autorisées d'une chaîne
+//	email         adresse e-mail
+//	e164          numéro de téléphone international, "+33612345678"
+//
+// Les règles autres que required ne s'appliquent pas à un champ vide (valeur nulle du
+// type : "", 0, nil) : un champ facultatif n'est vérifié que s'il est fourni, et une mise
+// à jour partielle peut omettre les autres. Un champ obligatoire doit donc porter required
+// en plus de ses autres règles : "min=8" seul accepte une chaîne vide. Les structures et
+// les listes de structures sont parcourues ; les champs sont désignés par leur nom JSON
+// ("billing_address.country", "event_channels[0].channel").
+func Validate(v interface{}) error {
+	return validate(v, false)
+}
+
+// ValidatePartial est Validate sans la règle required
+func ValidatePartial(v interface{}) error {
+	return validate(v, true)
+}
+
+func validate(v interface{}, partial bool) error {
+	value := reflect.Indirect(reflect.ValueOf(v))
+	if value.Kind() != reflect.Struct {
+		return nil
+	}
+	var fields []FieldError
+	validateStruct(value, "", partial, &fields)
+	if len(fields) > 0 {
+		return ValidationError(fields...)
+	}
+	return nil
+}
+
+func validateStruct(value reflect.Value, prefix string, partial bool, fields *[]FieldError) {
+	for i := 0; i < value.NumField(); i++ {
+		structField := value.Type().Field(i)
+		name, ok := jsonName(structField)
+		if !ok {
+			continue
+		}
+		field := value.Field(i)
+		if rules := structField.Tag.Get("validate"); rules != "" {
+			for _, rule := range strings.Split(rules, ",") {
+				if partial && rule == "required" {
+					continue
+				}
+				if message := checkRule(field, rule); message != "" {
+					code, _, _ := strings.Cut(rule, "=")
+					*fields = append(*fields, FieldError{Field: prefix + name, Code: code, Message: message})
+					// Une seule erreur par champ : "is required" rend les autres inutiles
+					break
+				}
+			}
+		}
+
+		switch field = reflect.Indirect(field); field.Kind() {
+		case reflect.Struct:
+			if structField.Anonymous {
+				validateStruct(field, prefix, partial, fields)
+			} else {
+				validateStruct(field, prefix+name+".", partial, fields)
+			}
+		case reflect.Slice:
+			if field.Type().Elem().Kind() != reflect.Struct {
+				continue
+			}
+			for j := 0; j < field.Len(); j++ {
+				validateStruct(field.Index(j), fmt.Sprintf("%s%s[%d].", prefix, name, j), partial, fields)
+			}
+		}
+	}
+}
+
+// checkRule renvoie le message d'erreur si la valeur ne respecte pas la règle
+func checkRule(value reflect.Value, rule string) string {
+	name, arg, _ := strings.Cut(rule, "=")
+	if name == "required" {
+		if value.IsZero() {
+			return "is required"
+		}
+		return ""
+	}
+	if value.IsZero() {
+		return ""
+	}
+	value = reflect.Indirect(value)
+
+	switch name {
+	case "min", "max":
+		limit, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			panic(fmt.Sprintf("web: invalid validation rule %q", rule))
+		}
+		n, unit := measure(value)
+		if name == "min" && n < limit {
+			return fmt.Sprintf("must be at least %s%s", arg, unit)
+		}
+		if name == "max" && n > limit {
+			return fmt.Sprintf("must be at most %s%s", arg, unit)
+		}
+	case "len":
+		if n, _ := strconv.Atoi(arg); value.Kind() == reflect.String && len([]rune(value.String())) != n {
+			return fmt.Sprintf("must be exactly %s characters", arg)
+		}
+	case "oneof":
+		allowed := strings.Fields(arg)
+		for _, a := range allowed {
+			if value.String() == a {
+				return ""
+			}
+		}
+		return "must be one of: " + strings.Join(allowed, ", ")
+	case "email":
+		// mail.ParseAddress accepte aussi "Nom <adresse>", refusé ici
+		address, err := mail.ParseAddress(value.String())
+		if err != nil || address.Address != value.String() {
+			return "must be a valid email address"
+		}
+	case "e164":
+		if !e164Pattern.MatchString(value.String()) {
+			return "must be an international phone number, such as +33612345678"
+		}
+	default:
+		panic(fmt.Sprintf("web: unknown validation rule %q", rule))
+	}
+	return ""
+}
+
+var e164Pattern = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
+
+// measure renvoie la valeur d'un nombre, ou la longueur d'une chaîne ou d'une liste
+func measure(value reflect.Value) (float64, string) {
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(value.Int()), ""
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(value.Uint()), ""
+	case reflect.Float32, reflect.Float64:
+		return value.Float(), ""
+	case reflect.String:
+		return float64(len([]rune(value.String()))), " characters"
+	case reflect.Slice, reflect.Map:
+		return float64(value.Len()), " items"
+	}
+	panic(fmt.Sprintf("web: min and max do not apply to %s", value.Type()))
+}
+
+// jsonName renvoie le nom du champ dans le corps JSON ; ok est faux pour un champ ignoré
+func jsonName(field reflect.StructField) (string, bool) {
+	if !field.IsExported() {
+		return "", false
+	}
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "-" {
+		return "", false
+	}
+	if name == "" {
+		name = field.Name
+	}
+	return name, true
+}
+
+func jsonType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	}
+	return "an object"
+}
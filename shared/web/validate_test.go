@@ -0,0 +1,216 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type validatedAddress struct {
+	Country string `json:"country" validate:"required,len=2"`
+}
+
+type validatedChannel struct {
+	Channel string `json:"channel" validate:"oneof=email sms"`
+}
+
+type validatedPayload struct {
+	Name     string             `json:"name" validate:"required,max=5"`
+	Email    string             `json:"email" validate:"email"`
+	Phone    string             `json:"phone" validate:"e164"`
+	Password string             `json:"password" validate:"min=8"`
+	Quantity int                `json:"quantity" validate:"min=1,max=10"`
+	Price    *float64           `json:"price" validate:"min=0"`
+	Tags     []string           `json:"tags" validate:"max=2"`
+	Address  validatedAddress   `json:"billing_address"`
+	Channels []validatedChannel `json:"event_channels"`
+	Internal string             `json:"-" validate:"required"`
+}
+
+// validPayload renvoie une charge valide, que chaque cas modifie
+func validPayload() validatedPayload {
+	return validatedPayload{Name: "Alice", Address: validatedAddress{Country: "FR"}}
+}
+
+func TestValidate(t *testing.T) {
+	negative := -1.0
+	tests := []struct {
+		name    string
+		modify  func(p *validatedPayload)
+		partial bool
+		want    []FieldError
+	}{
+		{
+			name:   "valid payload",
+			modify: func(p *validatedPayload) {},
+		},
+		{
+			name:   "missing required field",
+			modify: func(p *validatedPayload) { p.Name = "" },
+			want:   []FieldError{{Field: "name", Code: "required", Message: "is required"}},
+		},
+		{
+			name:    "required is skipped by partial validation",
+			modify:  func(p *validatedPayload) { p.Name = "" },
+			partial: true,
+		},
+		{
+			name:   "string too long",
+			modify: func(p *validatedPayload) { p.Name = "Béatrice" },
+			want:   []FieldError{{Field: "name", Code: "max", Message: "must be at most 5 characters"}},
+		},
+		{
+			name:   "length counts characters, not bytes",
+			modify: func(p *validatedPayload) { p.Name = "Éloïs" },
+		},
+		{
+			name:   "number below minimum",
+			modify: func(p *validatedPayload) { p.Quantity = -2 },
+			want:   []FieldError{{Field: "quantity", Code: "min", Message: "must be at least 1"}},
+		},
+		{
+			name:   "number above maximum",
+			modify: func(p *validatedPayload) { p.Quantity = 11 },
+			want:   []FieldError{{Field: "quantity", Code: "max", Message: "must be at most 10"}},
+		},
+		{
+			name:   "pointer is checked through",
+			modify: func(p *validatedPayload) { p.Price = &negative },
+			want:   []FieldError{{Field: "price", Code: "min", Message: "must be at least 0"}},
+		},
+		{
+			name:   "list too long",
+			modify: func(p *validatedPayload) { p.Tags = []string{"a", "b", "c"} },
+			want:   []FieldError{{Field: "tags", Code: "max", Message: "must be at most 2 items"}},
+		},
+		{
+			name:   "invalid email",
+			modify: func(p *validatedPayload) { p.Email = "alice" },
+			want:   []FieldError{{Field: "email", Code: "email", Message: "must be a valid email address"}},
+		},
+		{
+			name:   "email with a display name",
+			modify: func(p *validatedPayload) { p.Email = "Alice <alice@example.com>" },
+			want:   []FieldError{{Field: "email", Code: "email", Message: "must be a valid email address"}},
+		},
+		{
+			name:   "international phone number",
+			modify: func(p *validatedPayload) { p.Phone = "+33612345678" },
+		},
+		{
+			name:   "national phone number",
+			modify: func(p *validatedPayload) { p.Phone = "0612345678" },
+			want:   []FieldError{{Field: "phone", Code: "e164", Message: "must be an international phone number, such as +33612345678"}},
+		},
+		{
+			name:   "phone number with spaces",
+			modify: func(p *validatedPayload) { p.Phone = "+33 6 12 34 56 78" },
+			want:   []FieldError{{Field: "phone", Code: "e164", Message: "must be an international phone number, such as +33612345678"}},
+		},
+		{
+			name:   "rules other than required skip empty values",
+			modify: func(p *validatedPayload) { p.Email, p.Password, p.Quantity = "", "", 0 },
+		},
+		{
+			name:   "short non-empty password",
+			modify: func(p *validatedPayload) { p.Password = "secret" },
+			want:   []FieldError{{Field: "password", Code: "min", Message: "must be at least 8 characters"}},
+		},
+		{
+			name:   "nested struct",
+			modify: func(p *validatedPayload) { p.Address.Country = "FRA" },
+			want:   []FieldError{{Field: "billing_address.country", Code: "len", Message: "must be exactly 2 characters"}},
+		},
+		{
+			name: "list of structs",
+			modify: func(p *validatedPayload) {
+				p.Channels = []validatedChannel{{Channel: "email"}, {Channel: "fax"}}
+			},
+			want: []FieldError{{Field: "event_channels[1].channel", Code: "oneof", Message: "must be one of: email, sms"}},
+		},
+		{
+			name: "one error per field, all fields reported",
+			modify: func(p *validatedPayload) {
+				p.Name, p.Quantity, p.Address.Country = "", 20, ""
+			},
+			want: []FieldError{
+				{Field: "name", Code: "required", Message: "is required"},
+				{Field: "quantity", Code: "max", Message: "must be at most 10"},
+				{Field: "billing_address.country", Code: "required", Message: "is required"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload := validPayload()
+			tt.modify(&payload)
+			var err error
+			if tt.partial {
+				err = ValidatePartial(&payload)
+			} else {
+				err = Validate(&payload)
+			}
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("Validate() error = %v", err)
+				}
+				return
+			}
+			var statusErr *StatusError
+			if !errors.As(err, &statusErr) {
+				t.Fatalf("Validate() error = %v, want a StatusError", err)
+			}
+			if statusErr.Status != http.StatusUnprocessableEntity || statusErr.Code != "validation_failed" {
+				t.Errorf("Validate() = %d %s, want 422 validation_failed", statusErr.Status, statusErr.Code)
+			}
+			if !reflect.DeepEqual(statusErr.Fields, tt.want) {
+				t.Errorf("Validate() fields = %+v, want %+v", statusErr.Fields, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeJSON(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantCode   string
+		wantField  string
+	}{
+		{name: "valid body", body: `{"name":"Alice","billing_address":{"country":"FR"}}`},
+		{name: "empty body", body: ``, wantStatus: http.StatusBadRequest, wantCode: "empty_body"},
+		{name: "malformed JSON", body: `{"name":`, wantStatus: http.StatusBadRequest, wantCode: "invalid_json"},
+		{name: "trailing data", body: `{"name":"Alice","billing_address":{"country":"FR"}} {}`, wantStatus: http.StatusBadRequest, wantCode: "invalid_json"},
+		{name: "unknown field", body: `{"nickname":"Al"}`, wantStatus: http.StatusUnprocessableEntity, wantCode: "validation_failed", wantField: "nickname"},
+		{name: "wrong type", body: `{"quantity":"two"}`, wantStatus: http.StatusUnprocessableEntity, wantCode: "validation_failed", wantField: "quantity"},
+		{name: "invalid value", body: `{"name":"Alice","billing_address":{"country":"FRA"}}`, wantStatus: http.StatusUnprocessableEntity, wantCode: "validation_failed", wantField: "billing_address.country"},
+		{name: "body too large", body: `{"name":"` + strings.Repeat("a", int(MaxBodyBytes)) + `"}`, wantStatus: http.StatusRequestEntityTooLarge, wantCode: "payload_too_large"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/users", strings.NewReader(tt.body))
+			var payload validatedPayload
+			err := DecodeJSON(httptest.NewRecorder(), req, &payload)
+			if tt.wantCode == "" {
+				if err != nil {
+					t.Fatalf("DecodeJSON() error = %v", err)
+				}
+				return
+			}
+			var statusErr *StatusError
+			if !errors.As(err, &statusErr) {
+				t.Fatalf("DecodeJSON() error = %v, want a StatusError", err)
+			}
+			if statusErr.Status != tt.wantStatus || statusErr.Code != tt.wantCode {
+				t.Errorf("DecodeJSON() = %d %s, want %d %s", statusErr.Status, statusErr.Code, tt.wantStatus, tt.wantCode)
+			}
+			if tt.wantField != "" && (len(statusErr.Fields) != 1 || statusErr.Fields[0].Field != tt.wantField) {
+				t.Errorf("DecodeJSON() fields = %+v, want %s", statusErr.Fields, tt.wantField)
+			}
+		})
+	}
+}
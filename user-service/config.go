@@ -0,0 +1,13 @@
+package main
+
+import "shared/config"
+
+// Config est lue au démarrage depuis l'environnement, voir le paquet shared/config
+type Config struct {
+	config.Server
+	config.Database
+	config.Auth
+	config.Internal
+}
+
+var cfg = Config{Server: config.Server{Port: 8082}}
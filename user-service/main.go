@@ -1,177 +1,138 @@
 package main
 
 import (
-	"context"
-	"encoding/json"
-	"fmt"
-	"log"
+	"embed"
+	"errors"
 	"net/http"
-	"strings"
+	"strconv"
 
-	"gorm.io/driver/postgres"
+	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
+	"shared/service"
+	"shared/web"
 )
 
 type User struct {
-	ID       uint   `gorm:"primaryKey;autoIncrement" json:"id"`
-	Name     string `json:"name"`
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	ID    uint   `gorm:"primaryKey;autoIncrement" json:"id"`
+	Name  string `json:"name" validate:"required,max=100"`
+	Email string `json:"email" validate:"required,email,max=254"`
+	// Numéro pour les notifications SMS, facultatif
+	Phone string `json:"phone" validate:"e164"`
+	// Mot de passe reçu à la création ou à la modification ; seul son hash bcrypt est stocké
+	Password     string `gorm:"-" json:"password,omitempty" validate:"required,min=8,max=128"`
+	PasswordHash string `json:"-"`
 }
 
-var (
-	db *gorm.DB
-)
-
-// Define a custom type for the context key
-type contextKey string
+// Migrations SQL du schéma, appliquées par la sous-commande migrate
+//
+//go:embed migrations/*.sql
+var migrations embed.FS
 
-const userIDKey contextKey = "user_id"
+// Données de démonstration, chargées en développement avec DB_SEED_DEMO ou la sous-commande seed
+//
+//go:embed seeds/*.sql
+var seeds embed.FS
 
-func initDB() {
-	dsn := "host=db user=user password=password dbname=microservices port=5432 sslmode=disable TimeZone=Asia/Shanghai"
-	var err error
-	db, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
-	if err != nil {
-		log.Fatalf("failed to connect database: %v", err)
-	}
-}
+var db *gorm.DB
 
-func migrate() {
-	db.AutoMigrate(&User{})
-}
-
-func usersHandler(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case "GET":
-		getUsers(w)
-	case "POST":
-		createUser(w, r)
-	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-	}
+var users = web.Resource[User]{
+	Name:   "User",
+	Prefix: "/users/",
+	// Vérifiez si l'utilisateur avec le même email existe déjà
+	BeforeCreate: func(tx *gorm.DB, user *User) error {
+		var existingUser User
+		err := tx.Where("email = ?", user.Email).First(&existingUser).Error
+		if err == nil {
+			return web.NewError(http.StatusConflict, "email_taken", "Email already in use")
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		return hashPassword(user)
+	},
+	BeforeUpdate: func(tx *gorm.DB, user *User) error {
+		return hashPassword(user)
+	},
 }
 
-func userHandler(w http.ResponseWriter, r *http.Request) {
-	id := r.URL.Path[len("/users/"):]
-	switch r.Method {
-	case "GET":
-		getUser(w, id)
-	case "PUT":
-		updateUser(w, r, id)
-	case "DELETE":
-		deleteUser(w, id)
-	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+// hashPassword remplace le mot de passe reçu par son hash ; sans mot de passe, le hash
+// existant est conservé
+func hashPassword(user *User) error {
+	if user.Password == "" {
+		return nil
 	}
-}
-
-func getUsers(w http.ResponseWriter) {
-	var users []User
-	if err := db.Find(&users).Error; err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	hash, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
 	}
-	json.NewEncoder(w).Encode(users)
+	user.PasswordHash, user.Password = string(hash), ""
+	return nil
 }
 
-func createUser(w http.ResponseWriter, r *http.Request) {
-	var user User
-	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
+// unknownUserHash est comparé quand l'utilisateur n'existe pas, pour que la réponse prenne
+// le même temps qu'avec un mot de passe faux
+var unknownUserHash, _ = bcrypt.GenerateFromPassword([]byte("unknown user"), bcrypt.DefaultCost)
 
-	// Vérifiez si l'utilisateur avec le même email existe déjà
-	var existingUser User
-	if err := db.Where("email = ?", user.Email).First(&existingUser).Error; err == nil {
-		http.Error(w, "Email already in use", http.StatusConflict) // Renvoie un code 409
-		return
+// credentialsHandler vérifie le mot de passe d'un utilisateur, désigné par son identifiant
+// ou son email, pour auth-service ; il renvoie l'identifiant à placer dans le jeton
+func credentialsHandler(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		UserID   string `json:"user_id" validate:"required,max=254"`
+		Password string `json:"password" validate:"required,max=128"`
 	}
-
-	// Créez l'utilisateur si l'email n'existe pas
-	if err := db.Create(&user).Error; err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := web.DecodeJSON(w, r, &request); err != nil {
+		web.WriteError(w, err, "Credentials")
 		return
 	}
-	w.WriteHeader(http.StatusCreated)
-}
 
-func getUser(w http.ResponseWriter, id string) {
 	var user User
-	if err := db.First(&user, "id = ?", id).Error; err != nil {
-		http.Error(w, "User not found", http.StatusNotFound)
-		return
+	query := db.WithContext(r.Context())
+	var err error
+	if id, parseErr := strconv.ParseUint(request.UserID, 10, 64); parseErr == nil {
+		err = query.First(&user, "id = ?", id).Error
+	} else {
+		err = query.First(&user, "email = ?", request.UserID).Error
 	}
-	json.NewEncoder(w).Encode(user)
-}
-
-func updateUser(w http.ResponseWriter, r *http.Request, id string) {
-	var user User
-	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		web.WriteError(w, err, "User")
 		return
 	}
-	if err := db.Model(&User{}).Where("id = ?", id).Updates(user).Error; err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	hash := []byte(user.PasswordHash)
+	if user.PasswordHash == "" {
+		hash = unknownUserHash
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(request.Password)) != nil || user.PasswordHash == "" {
+		web.Error(w, http.StatusUnauthorized, "invalid_credentials", "Invalid user or password")
 		return
 	}
-	w.WriteHeader(http.StatusOK)
+	web.WriteJSON(w, http.StatusOK, map[string]string{"user_id": strconv.FormatUint(uint64(user.ID), 10)})
 }
 
-func deleteUser(w http.ResponseWriter, id string) {
-	if err := db.Delete(&User{}, "id = ?", id).Error; err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+// internalUserHandler expose le contact d'un utilisateur aux autres services, sans le mot de passe
+func internalUserHandler(w http.ResponseWriter, r *http.Request) {
+	var user User
+	if err := db.First(&user, "id = ?", web.PathID(r, "/internal/users/")).Error; err != nil {
+		web.WriteError(w, err, "User")
 		return
 	}
-	w.WriteHeader(http.StatusOK)
-}
-
-func jwtMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		token := r.Header.Get("Authorization")
-		if token == "" {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-
-		resp, err := http.Post("http://auth-service:8080/verify-token", "application/json", strings.NewReader(fmt.Sprintf(`{"token":"%s"}`, token)))
-		if err != nil {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-
-		if resp.StatusCode != http.StatusOK {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-
-		var result struct {
-			UserID string `json:"user_id"`
-		}
-		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-		ctx := context.WithValue(r.Context(), userIDKey, result.UserID)
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
-}
-
-func healthHandler(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("OK"))
+	web.WriteJSON(w, http.StatusOK, struct {
+		ID    uint   `json:"id"`
+		Name  string `json:"name"`
+		Email string `json:"email"`
+		Phone string `json:"phone"`
+	}{user.ID, user.Name, user.Email, user.Phone})
 }
 
 func main() {
-	initDB()
-	migrate()
-
-	mux := http.NewServeMux()
-	mux.Handle("/users", jwtMiddleware(http.HandlerFunc(usersHandler)))
-	mux.Handle("/users/", jwtMiddleware(http.HandlerFunc(userHandler)))
-	mux.HandleFunc("/health", healthHandler)
-
-	log.Println("Starting User Service on :8082")
-	log.Fatal(http.ListenAndServe(":8082", mux))
+	svc := service.New("User Service", &cfg)
+	db = svc.DB
+	svc.Migrate(migrations)
+	svc.Seed(seeds)
+
+	users.DB = db
+	svc.Router.Handle("/users", users.Collection(), svc.RequireUser())
+	svc.Router.Handle("/users/", users.Item(), svc.RequireUser())
+	svc.Router.Handle("/internal/users/", web.Methods{"GET": internalUserHandler}, svc.RequireInternal())
+	svc.Router.Handle("/internal/credentials", web.Methods{"POST": credentialsHandler}, svc.RequireInternal())
+	svc.Run()
 }
@@ -0,0 +1,8 @@
+-- Schéma créé jusqu'ici par AutoMigrate et db/init_db.sql ; une base existante s'adopte avec
+-- "migrate baseline", qui vérifie qu'elle correspond à cette migration
+CREATE TABLE users (
+    id bigserial PRIMARY KEY,
+    name text,
+    email text,
+    password text
+);
@@ -0,0 +1,2 @@
+-- Deux inscriptions simultanées passaient toutes deux la vérification de BeforeCreate
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email ON users (email);
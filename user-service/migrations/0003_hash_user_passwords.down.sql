@@ -0,0 +1,3 @@
+-- Les mots de passe en clair ne sont pas récupérables : chaque compte devra en redéfinir un
+ALTER TABLE users ADD COLUMN password text;
+ALTER TABLE users DROP COLUMN password_hash;
@@ -0,0 +1,6 @@
+-- Les mots de passe étaient stockés en clair. crypt() de pgcrypto produit des hash bcrypt
+-- ($2a$) que golang.org/x/crypto/bcrypt sait vérifier
+CREATE EXTENSION IF NOT EXISTS pgcrypto;
+ALTER TABLE users ADD COLUMN password_hash text;
+UPDATE users SET password_hash = crypt(password, gen_salt('bf', 10)) WHERE password <> '';
+ALTER TABLE users DROP COLUMN password;
@@ -0,0 +1,2 @@
+-- Numéro au format E.164, lu par notification-service pour les SMS
+ALTER TABLE users ADD COLUMN phone text;
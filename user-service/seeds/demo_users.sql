@@ -0,0 +1,8 @@
+-- Comptes de démonstration, mot de passe "password" ; réservés au développement
+INSERT INTO users (name, email, password_hash)
+SELECT seed.name, seed.email, crypt(seed.password, gen_salt('bf', 10))
+FROM (VALUES
+    ('User1', 'user1@example.com', 'password'),
+    ('User2', 'user2@example.com', 'password')
+) AS seed (name, email, password)
+WHERE NOT EXISTS (SELECT 1 FROM users WHERE users.email = seed.email);
@@ -13,19 +13,40 @@ export async function GET(request: NextRequest, { params }: { params: Promise<{
   const resolvedParams = await params
   const path = resolvedParams.path
   const [service, ...rest] = path
-  const url = `${SERVICES[service as keyof typeof SERVICES]}/${rest.join('/')}`
+  const searchParams = new URLSearchParams(request.nextUrl.searchParams)
+  // EventSource ne peut pas envoyer d'en-tête Authorization
+  const accessToken = searchParams.get('access_token')
+  searchParams.delete('access_token')
+  const query = searchParams.toString()
+  const url = `${SERVICES[service as keyof typeof SERVICES]}/${rest.join('/')}${query ? `?${query}` : ''}`
 
   try {
+    const headers: Record<string, string> = {
+      'Authorization': request.headers.get('Authorization') || accessToken || '',
+    }
+    const lastEventId = request.headers.get('Last-Event-ID')
+    if (lastEventId) {
+      headers['Last-Event-ID'] = lastEventId
+    }
     const response = await fetch(url, {
-      headers: {
-        'Authorization': request.headers.get('Authorization') || '',
-      },
+      headers,
+      signal: request.signal,
     })
 
     const contentType = response.headers.get('content-type')
+    if (contentType && contentType.includes('text/event-stream')) {
+      return new NextResponse(response.body, {
+        status: response.status,
+        headers: {
+          'Content-Type': 'text/event-stream',
+          'Cache-Control': 'no-cache',
+          'Connection': 'keep-alive',
+        },
+      })
+    }
     if (contentType && contentType.includes('application/json')) {
       const data = await response.json()
-      return NextResponse.json(data)
+      return NextResponse.json(data, { status: response.status })
     } else {
       const text = await response.text()
       return new NextResponse(text, {
@@ -49,19 +70,24 @@ export async function POST(request: NextRequest, { params }: { params: Promise<{
 
   try {
     const body = await request.json()
+    const headers: Record<string, string> = {
+      'Content-Type': 'application/json',
+      'Authorization': request.headers.get('Authorization') || '',
+    }
+    const idempotencyKey = request.headers.get('Idempotency-Key')
+    if (idempotencyKey) {
+      headers['Idempotency-Key'] = idempotencyKey
+    }
     const response = await fetch(url, {
       method: 'POST',
-      headers: {
-        'Content-Type': 'application/json',
-        'Authorization': request.headers.get('Authorization') || '',
-      },
+      headers,
       body: JSON.stringify(body),
     })
 
     const contentType = response.headers.get('content-type')
     if (contentType && contentType.includes('application/json')) {
       const data = await response.json()
-      return NextResponse.json(data)
+      return NextResponse.json(data, { status: response.status })
     } else {
       const text = await response.text()
       return new NextResponse(text, {
@@ -97,7 +123,7 @@ export async function PUT(request: NextRequest, { params }: { params: Promise<{
     const contentType = response.headers.get('content-type')
     if (contentType && contentType.includes('application/json')) {
       const data = await response.json()
-      return NextResponse.json(data)
+      return NextResponse.json(data, { status: response.status })
     } else {
       const text = await response.text()
       return new NextResponse(text, {
@@ -130,7 +156,7 @@ export async function DELETE(request: NextRequest, { params }: { params: Promise
     const contentType = response.headers.get('content-type')
     if (contentType && contentType.includes('application/json')) {
       const data = await response.json()
-      return NextResponse.json(data)
+      return NextResponse.json(data, { status: response.status })
     } else {
       const text = await response.text()
       return new NextResponse(text, {
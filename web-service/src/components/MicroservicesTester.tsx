@@ -29,7 +29,8 @@ type TableConfig = {
   columns: {
     key: string
     label: string
-    type: 'string' | 'number'
+    // password : champ du formulaire seulement, jamais affiché dans le tableau
+    type: 'string' | 'number' | 'password'
   }[]
 }
 
@@ -39,6 +40,7 @@ const SERVICE_TABLES: Record<string, TableConfig> = {
       { key: 'id', label: 'ID', type: 'string' },
       { key: 'name', label: 'Name', type: 'string' },
       { key: 'email', label: 'Email', type: 'string' },
+      { key: 'password', label: 'Password', type: 'password' },
     ]
   },
   product: {
@@ -78,13 +80,14 @@ const SERVICE_TABLES: Record<string, TableConfig> = {
 
 function ResultsTable({ service, data, onEdit, onDelete }: { service: string; data: any[]; onEdit: (item: any) => void; onDelete: (id: string) => void }) {
   const config = SERVICE_TABLES[service]
+  const columns = config.columns.filter(column => column.type !== 'password')
   
   return (
     <div className="rounded-md border">
       <Table>
         <TableHeader>
           <TableRow>
-            {config.columns.map((column) => (
+            {columns.map((column) => (
               <TableHead key={column.key}>{column.label}</TableHead>
             ))}
             <TableHead>Actions</TableHead>
@@ -93,7 +96,7 @@ function ResultsTable({ service, data, onEdit, onDelete }: { service: string; da
         <TableBody>
           {data.map((item) => (
             <TableRow key={item.id}>
-              {config.columns.map((column) => (
+              {columns.map((column) => (
                 <TableCell key={column.key}>{String(item[column.key] ?? '-')}</TableCell>
               ))}
               <TableCell>
@@ -108,6 +111,19 @@ function ResultsTable({ service, data, onEdit, onDelete }: { service: string; da
   )
 }
 
+// Les services répondent en application/problem+json : detail est destiné à l'utilisateur
+// et errors détaille les champs invalides
+async function responseError(response: Response): Promise<Error> {
+  try {
+    const problem = await response.json();
+    const fields = (problem.errors ?? []).map((e: any) => `${e.field}: ${e.message}`);
+    const message = [problem.detail || problem.title, ...fields].filter(Boolean).join(' — ');
+    return new Error(message || `HTTP error! status: ${response.status}`);
+  } catch {
+    return new Error(`HTTP error! status: ${response.status}`);
+  }
+}
+
 async function getJwtToken(userId: string, password: string): Promise<string> {
   const response = await fetch('/api/proxy/auth/login', {
     method: 'POST',
@@ -115,7 +131,7 @@ async function getJwtToken(userId: string, password: string): Promise<string> {
     body: JSON.stringify({ user_id: userId, password }),
   });
 
-  if (!response.ok) throw new Error(`HTTP error! status: ${response.status}`);
+  if (!response.ok) throw await responseError(response);
 
   const data = await response.json();
   return data.token;
@@ -126,7 +142,7 @@ async function fetchData(serviceName: string, jwtToken: string): Promise<any[]>
     headers: { 'Authorization': `${jwtToken}` },
   });
 
-  if (!response.ok) throw new Error(`HTTP error! status: ${response.status}`);
+  if (!response.ok) throw await responseError(response);
 
   return response.json();
 }
@@ -141,7 +157,7 @@ async function createItem(serviceName: string, jwtToken: string, item: any): Pro
     body: JSON.stringify(item)
   });
 
-  if (!response.ok) throw new Error(`HTTP error! status: ${response.status}`);
+  if (!response.ok) throw await responseError(response);
 
   return response;
 }
@@ -156,7 +172,7 @@ async function updateItem(serviceName: string, jwtToken: string, item: any): Pro
     body: JSON.stringify(item)
   });
 
-  if (!response.ok) throw new Error(`HTTP error! status: ${response.status}`);
+  if (!response.ok) throw await responseError(response);
 
   return response;
 }
@@ -167,7 +183,7 @@ async function deleteItem(serviceName: string, jwtToken: string, id: string): Pr
     headers: { 'Authorization': `${jwtToken}` },
   });
 
-  if (!response.ok) throw new Error(`HTTP error! status: ${response.status}`);
+  if (!response.ok) throw await responseError(response);
 }
 
 async function resetService(serviceName: string, jwtToken: string): Promise<void> {
@@ -200,6 +216,11 @@ export default function MicroservicesTester() {
               return [column.key, z.string().min(1, { message: `${column.label} is required` })];
             case 'number':
               return [column.key, z.number().min(1, { message: `${column.label} is required` })];
+            case 'password': {
+              // Laissé vide à la modification, le mot de passe reste inchangé
+              const password = z.string().min(8, { message: `${column.label} must be at least 8 characters` });
+              return [column.key, editingItem ? password.or(z.literal('')) : password];
+            }
             default:
               return [column.key, z.string().min(1, { message: `${column.label} is required` })];
           }
@@ -283,7 +304,12 @@ export default function MicroservicesTester() {
 
   const handleEdit = (item: any) => {
     setEditingItem(item)
-    form.reset(item)
+    form.reset({
+      ...item,
+      ...Object.fromEntries(SERVICE_TABLES[currentService].columns
+        .filter(column => column.type === 'password')
+        .map(column => [column.key, ''])),
+    })
     setIsDialogOpen(true)
   }
 
@@ -326,7 +352,7 @@ export default function MicroservicesTester() {
         {!jwtToken ? (
           <form onSubmit={handleLogin} className="space-y-4 mb-4">
             <div>
-              <Label htmlFor="userId">User ID</Label>
+              <Label htmlFor="userId">User ID or email</Label>
               <Input
                 id="userId"
                 value={userId}
@@ -397,7 +423,7 @@ export default function MicroservicesTester() {
                                 <FormControl>
                                   <Input
                                     {...field}
-                                    type={column.type === 'number' ? 'number' : 'text'}
+                                    type={column.type === 'number' ? 'number' : column.type === 'password' ? 'password' : 'text'}
                                     onChange={(e) => {
                                       const value = column.type === 'number' ? Number(e.target.value) : e.target.value;
                                       field.onChange(value);